@@ -19,6 +19,10 @@ func main() {
 	cfg := config.Load()
 
 	logging.Init(cfg.LogLevel)
+	if err := cfg.Validate(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
 	slog.Info("starting forgor coordination server",
 		"bind_addr", cfg.BindAddr,
 		"db_path", cfg.DBPath,
@@ -39,6 +43,7 @@ func main() {
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
 	}
+	httpServer.RegisterOnShutdown(server.Close)
 
 	go func() {
 		slog.Info("HTTP server listening", "addr", cfg.BindAddr)
@@ -7,15 +7,30 @@ import (
 )
 
 type APIError struct {
-	StatusCode int    `json:"-"`
-	Code       string `json:"code"`
-	Message    string `json:"message"`
+	StatusCode int         `json:"-"`
+	Code       string      `json:"code"`
+	Message    string      `json:"message"`
+	Details    interface{} `json:"details,omitempty"`
 }
 
 func (e *APIError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Code, e.Message)
 }
 
+// WithDetails returns a copy of the error carrying extra structured context,
+// such as the current chain head a client should rebase onto.
+func (e *APIError) WithDetails(details interface{}) *APIError {
+	withDetails := *e
+	withDetails.Details = details
+	return &withDetails
+}
+
+// BatchItemError reports why one element of a batch request was rejected.
+type BatchItemError struct {
+	Index int `json:"index"`
+	*APIError
+}
+
 func (e *APIError) WriteJSON(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(e.StatusCode)
@@ -126,6 +141,18 @@ func OwnerRequired() *APIError {
 	return Forbidden("only the vault owner can perform this action")
 }
 
+func OwnerCannotLeave() *APIError {
+	return BadRequest("owner_cannot_leave", "the owner must transfer ownership before leaving the vault")
+}
+
+func CannotRemoveOwner() *APIError {
+	return BadRequest("cannot_remove_owner", "the owner cannot be removed; ownership must be transferred first")
+}
+
+func AdminRequired() *APIError {
+	return Forbidden("only a vault owner or admin can perform this action")
+}
+
 func InviteAlreadyUsed() *APIError {
 	return Conflict("invite has already been used")
 }
@@ -135,9 +162,167 @@ func DuplicateDevice() *APIError {
 }
 
 func EventChainBroken() *APIError {
-	return BadRequest("event_chain_broken", "event counter or prev_hash does not match expected chain")
+	return &APIError{
+		StatusCode: http.StatusConflict,
+		Code:       "event_chain_broken",
+		Message:    "event counter or prev_hash does not match expected chain",
+	}
 }
 
 func MembershipChainBroken() *APIError {
-	return BadRequest("membership_chain_broken", "member_seq or prev_hash does not match expected chain")
+	return &APIError{
+		StatusCode: http.StatusConflict,
+		Code:       "membership_chain_broken",
+		Message:    "member_seq or prev_hash does not match expected chain",
+	}
+}
+
+func Equivocation() *APIError {
+	return &APIError{
+		StatusCode: http.StatusConflict,
+		Code:       "equivocation",
+		Message:    "signer already signed a different message at this chain position",
+	}
+}
+
+func DeviceFrozen() *APIError {
+	return &APIError{
+		StatusCode: http.StatusForbidden,
+		Code:       "device_frozen",
+		Message:    "device is frozen after equivocation until it is removed",
+	}
+}
+
+func ReadOnlyMember() *APIError {
+	return &APIError{
+		StatusCode: http.StatusForbidden,
+		Code:       "read_only_member",
+		Message:    "device is a reader in this vault and cannot push events",
+	}
+}
+
+func StaleKeyEpoch() *APIError {
+	return &APIError{
+		StatusCode: http.StatusConflict,
+		Code:       "stale_key_epoch",
+		Message:    "key_epoch is not usable by this device for this vault",
+	}
+}
+
+func RotationPending() *APIError {
+	return &APIError{
+		StatusCode: http.StatusConflict,
+		Code:       "rotation_pending",
+		Message:    "vault key must be rotated after a member removal before writing under the current epoch",
+	}
+}
+
+func KeyEpochUsed() *APIError {
+	return &APIError{
+		StatusCode: http.StatusConflict,
+		Code:       "key_epoch_used",
+		Message:    "key_epoch already has key updates from before the pending rotation; rotate to a higher epoch",
+	}
+}
+
+func VaultDeleted() *APIError {
+	return &APIError{
+		StatusCode: http.StatusGone,
+		Code:       "vault_deleted",
+		Message:    "vault has been deleted",
+	}
+}
+
+func InviteExpired() *APIError {
+	return &APIError{
+		StatusCode: http.StatusGone,
+		Code:       "invite_expired",
+		Message:    "invite has expired",
+	}
+}
+
+func InviteRevoked() *APIError {
+	return &APIError{
+		StatusCode: http.StatusGone,
+		Code:       "invite_revoked",
+		Message:    "invite has been revoked",
+	}
+}
+
+func MissingAuthentication() *APIError {
+	return &APIError{
+		StatusCode: http.StatusUnauthorized,
+		Code:       "missing_authentication",
+		Message:    "request authentication headers are missing",
+	}
+}
+
+func InvalidRequestSignature() *APIError {
+	return &APIError{
+		StatusCode: http.StatusUnauthorized,
+		Code:       "invalid_request_signature",
+		Message:    "request signature verification failed",
+	}
+}
+
+func RequestExpired() *APIError {
+	return &APIError{
+		StatusCode: http.StatusUnauthorized,
+		Code:       "request_expired",
+		Message:    "request timestamp is outside the allowed window",
+	}
+}
+
+func RequestReplayed() *APIError {
+	return &APIError{
+		StatusCode: http.StatusUnauthorized,
+		Code:       "request_replayed",
+		Message:    "request nonce has already been used",
+	}
+}
+
+func UnknownDevice() *APIError {
+	return &APIError{
+		StatusCode: http.StatusUnauthorized,
+		Code:       "unknown_device",
+		Message:    "device is not registered",
+	}
+}
+
+func DeviceMismatch() *APIError {
+	return &APIError{
+		StatusCode: http.StatusForbidden,
+		Code:       "device_mismatch",
+		Message:    "authenticated device does not match the requested device",
+	}
+}
+
+func InvalidSession() *APIError {
+	return &APIError{
+		StatusCode: http.StatusUnauthorized,
+		Code:       "invalid_session",
+		Message:    "session token is invalid, expired or revoked",
+	}
+}
+
+func InvalidChallenge() *APIError {
+	return &APIError{
+		StatusCode: http.StatusUnauthorized,
+		Code:       "invalid_challenge",
+		Message:    "challenge is unknown, expired or issued to another device",
+	}
+}
+
+// BatchRejected reports that nothing in a batch was committed, listing the
+// entries that caused it. The status is that of the first failing entry so
+// that, for example, a chain conflict still surfaces as 409.
+func BatchRejected(items []BatchItemError) *APIError {
+	return &APIError{
+		StatusCode: items[0].StatusCode,
+		Code:       "batch_rejected",
+		Message:    "batch was not committed; see details for the rejected entries",
+		Details: struct {
+			Errors []BatchItemError `json:"errors"`
+		}{Errors: items},
+	}
 }
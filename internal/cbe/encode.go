@@ -96,6 +96,24 @@ func (e *Encoder) WriteStringArray(arr []string) {
 	}
 }
 
+// WriteDeviceIDSet writes the device IDs sorted, so the encoding does not
+// depend on the order they were given in.
+func (e *Encoder) WriteDeviceIDSet(deviceIDs [][]byte) error {
+	sorted := make([][]byte, len(deviceIDs))
+	copy(sorted, deviceIDs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	e.WriteU32(uint32(len(sorted)))
+	for _, deviceID := range sorted {
+		if err := e.WriteDeviceID(deviceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type DeviceIDCounterEntry struct {
 	DeviceID []byte
 	Counter  uint64
@@ -128,37 +128,109 @@ func SignBytesMemberRemove(memberEventID, vaultID []byte, memberSeq uint64, prev
 	return e.Bytes(), nil
 }
 
+// SignBytesOwnerTransfer covers an owner_transfer member event, in which the
+// current owner (actor) hands ownership to another member (subject).
+func SignBytesOwnerTransfer(memberEventID, vaultID []byte, memberSeq uint64, prevHash, actorDeviceID, subjectDeviceID []byte) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("owner_transfer")
+	if err := e.WriteUUID(memberEventID); err != nil {
+		return nil, fmt.Errorf("member_event_id: %w", err)
+	}
+	if err := e.WriteUUID(vaultID); err != nil {
+		return nil, fmt.Errorf("vault_id: %w", err)
+	}
+	e.WriteU64(memberSeq)
+	if err := e.WriteHash(prevHash); err != nil {
+		return nil, fmt.Errorf("prev_hash: %w", err)
+	}
+	if err := e.WriteDeviceID(actorDeviceID); err != nil {
+		return nil, fmt.Errorf("actor_device_id: %w", err)
+	}
+	if err := e.WriteDeviceID(subjectDeviceID); err != nil {
+		return nil, fmt.Errorf("subject_device_id: %w", err)
+	}
+	return e.Bytes(), nil
+}
+
 func SignBytesInvite(inviteID, vaultID, targetDeviceID, targetPubkeySign, targetPubkeyBox, targetBundleSig, nonce, wrappedPayload, createdByDeviceID []byte, singleUse bool) ([]byte, error) {
 	e := NewEncoder()
 	e.WriteString(SignPrefix)
 	e.WriteString("invite")
+	if err := writeInviteFields(e, inviteID, vaultID, targetDeviceID, targetPubkeySign, targetPubkeyBox, targetBundleSig, nonce, wrappedPayload, createdByDeviceID, singleUse); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// SignBytesInviteV2 is the invite layout with a lifetime: the v1 fields under
+// the "invite_v2" type, followed by expires_at in Unix seconds.
+func SignBytesInviteV2(inviteID, vaultID, targetDeviceID, targetPubkeySign, targetPubkeyBox, targetBundleSig, nonce, wrappedPayload, createdByDeviceID []byte, singleUse bool, expiresAt uint64) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("invite_v2")
+	if err := writeInviteFields(e, inviteID, vaultID, targetDeviceID, targetPubkeySign, targetPubkeyBox, targetBundleSig, nonce, wrappedPayload, createdByDeviceID, singleUse); err != nil {
+		return nil, err
+	}
+	e.WriteU64(expiresAt)
+	return e.Bytes(), nil
+}
+
+// SignBytesInviteMulti covers a multi-use invite. An empty target set means
+// any registered device may use it; expiresAt is 0 when it never expires.
+func SignBytesInviteMulti(inviteID, vaultID []byte, targetDeviceIDs [][]byte, nonce, wrappedPayload, createdByDeviceID []byte, maxUses, expiresAt uint64) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("invite_multi")
 	if err := e.WriteUUID(inviteID); err != nil {
 		return nil, fmt.Errorf("invite_id: %w", err)
 	}
 	if err := e.WriteUUID(vaultID); err != nil {
 		return nil, fmt.Errorf("vault_id: %w", err)
 	}
+	if err := e.WriteDeviceIDSet(targetDeviceIDs); err != nil {
+		return nil, fmt.Errorf("target_device_ids: %w", err)
+	}
+	if err := e.WriteNonce(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	e.WriteBytes(wrappedPayload)
+	if err := e.WriteDeviceID(createdByDeviceID); err != nil {
+		return nil, fmt.Errorf("created_by_device_id: %w", err)
+	}
+	e.WriteU64(maxUses)
+	e.WriteU64(expiresAt)
+	return e.Bytes(), nil
+}
+
+func writeInviteFields(e *Encoder, inviteID, vaultID, targetDeviceID, targetPubkeySign, targetPubkeyBox, targetBundleSig, nonce, wrappedPayload, createdByDeviceID []byte, singleUse bool) error {
+	if err := e.WriteUUID(inviteID); err != nil {
+		return fmt.Errorf("invite_id: %w", err)
+	}
+	if err := e.WriteUUID(vaultID); err != nil {
+		return fmt.Errorf("vault_id: %w", err)
+	}
 	if err := e.WriteDeviceID(targetDeviceID); err != nil {
-		return nil, fmt.Errorf("target_device_id: %w", err)
+		return fmt.Errorf("target_device_id: %w", err)
 	}
 	if err := e.WritePublicKey(targetPubkeySign); err != nil {
-		return nil, fmt.Errorf("target_pubkey_sign: %w", err)
+		return fmt.Errorf("target_pubkey_sign: %w", err)
 	}
 	if err := e.WritePublicKey(targetPubkeyBox); err != nil {
-		return nil, fmt.Errorf("target_pubkey_box: %w", err)
+		return fmt.Errorf("target_pubkey_box: %w", err)
 	}
 	if err := e.WriteSignature(targetBundleSig); err != nil {
-		return nil, fmt.Errorf("target_bundle_sig: %w", err)
+		return fmt.Errorf("target_bundle_sig: %w", err)
 	}
 	if err := e.WriteNonce(nonce); err != nil {
-		return nil, fmt.Errorf("nonce: %w", err)
+		return fmt.Errorf("nonce: %w", err)
 	}
 	e.WriteBytes(wrappedPayload)
 	if err := e.WriteDeviceID(createdByDeviceID); err != nil {
-		return nil, fmt.Errorf("created_by_device_id: %w", err)
+		return fmt.Errorf("created_by_device_id: %w", err)
 	}
 	e.WriteBool(singleUse)
-	return e.Bytes(), nil
+	return nil
 }
 
 func SignBytesInviteClaim(inviteID, vaultID, deviceID []byte) ([]byte, error) {
@@ -177,6 +249,23 @@ func SignBytesInviteClaim(inviteID, vaultID, deviceID []byte) ([]byte, error) {
 	return e.Bytes(), nil
 }
 
+// SignBytesInviteRevoke covers the creator's request to cancel an invite.
+func SignBytesInviteRevoke(inviteID, vaultID, deviceID []byte) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("invite_revoke")
+	if err := e.WriteUUID(inviteID); err != nil {
+		return nil, fmt.Errorf("invite_id: %w", err)
+	}
+	if err := e.WriteUUID(vaultID); err != nil {
+		return nil, fmt.Errorf("vault_id: %w", err)
+	}
+	if err := e.WriteDeviceID(deviceID); err != nil {
+		return nil, fmt.Errorf("device_id: %w", err)
+	}
+	return e.Bytes(), nil
+}
+
 func SignBytesKeyUpdate(keyUpdateID, vaultID []byte, memberSeq uint64, memberHeadHash, targetDeviceID []byte, keyEpoch uint64, nonce, wrappedPayload, createdByDeviceID []byte) ([]byte, error) {
 	e := NewEncoder()
 	e.WriteString(SignPrefix)
@@ -251,3 +340,133 @@ func SignBytesSnapshot(snapshotID, vaultID []byte, baseSeq, memberSeq uint64, me
 	}
 	return e.Bytes(), nil
 }
+
+func SignBytesRequest(deviceID []byte, method, path, query string, timestamp uint64, nonce []byte) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("request")
+	if err := e.WriteDeviceID(deviceID); err != nil {
+		return nil, fmt.Errorf("device_id: %w", err)
+	}
+	e.WriteString(method)
+	e.WriteString(path)
+	e.WriteString(query)
+	e.WriteU64(timestamp)
+	if err := e.WriteNonce(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	return e.Bytes(), nil
+}
+
+func SignBytesAuthChallenge(deviceID, challenge []byte) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("auth_challenge")
+	if err := e.WriteDeviceID(deviceID); err != nil {
+		return nil, fmt.Errorf("device_id: %w", err)
+	}
+	if err := e.WriteFixedBytes(challenge, 32); err != nil {
+		return nil, fmt.Errorf("challenge: %w", err)
+	}
+	return e.Bytes(), nil
+}
+
+// SignBytesMemberLeave covers a member_leave event, signed by the departing
+// device itself.
+func SignBytesMemberLeave(memberEventID, vaultID []byte, memberSeq uint64, prevHash, deviceID []byte) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("member_leave")
+	if err := e.WriteUUID(memberEventID); err != nil {
+		return nil, fmt.Errorf("member_event_id: %w", err)
+	}
+	if err := e.WriteUUID(vaultID); err != nil {
+		return nil, fmt.Errorf("vault_id: %w", err)
+	}
+	e.WriteU64(memberSeq)
+	if err := e.WriteHash(prevHash); err != nil {
+		return nil, fmt.Errorf("prev_hash: %w", err)
+	}
+	if err := e.WriteDeviceID(deviceID); err != nil {
+		return nil, fmt.Errorf("device_id: %w", err)
+	}
+	return e.Bytes(), nil
+}
+
+// SignBytesVaultPolicy covers a vault_policy event, signed by the owner, that
+// sets how many admin signatures a membership change needs.
+func SignBytesVaultPolicy(memberEventID, vaultID []byte, memberSeq uint64, prevHash, actorDeviceID []byte, quorum uint64) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("vault_policy")
+	if err := e.WriteUUID(memberEventID); err != nil {
+		return nil, fmt.Errorf("member_event_id: %w", err)
+	}
+	if err := e.WriteUUID(vaultID); err != nil {
+		return nil, fmt.Errorf("vault_id: %w", err)
+	}
+	e.WriteU64(memberSeq)
+	if err := e.WriteHash(prevHash); err != nil {
+		return nil, fmt.Errorf("prev_hash: %w", err)
+	}
+	if err := e.WriteDeviceID(actorDeviceID); err != nil {
+		return nil, fmt.Errorf("actor_device_id: %w", err)
+	}
+	e.WriteU64(quorum)
+	return e.Bytes(), nil
+}
+
+// SignBytesVaultDelete covers the owner's request to delete a vault, bound to
+// the membership head the owner saw.
+func SignBytesVaultDelete(vaultID, deviceID []byte, memberSeq uint64, memberHeadHash []byte) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString("vault_delete")
+	if err := e.WriteUUID(vaultID); err != nil {
+		return nil, fmt.Errorf("vault_id: %w", err)
+	}
+	if err := e.WriteDeviceID(deviceID); err != nil {
+		return nil, fmt.Errorf("device_id: %w", err)
+	}
+	e.WriteU64(memberSeq)
+	if err := e.WriteHash(memberHeadHash); err != nil {
+		return nil, fmt.Errorf("member_head_hash: %w", err)
+	}
+	return e.Bytes(), nil
+}
+
+// SignBytesMemberPromote covers a member_promote event raising the subject to
+// role.
+func SignBytesMemberPromote(memberEventID, vaultID []byte, memberSeq uint64, prevHash, actorDeviceID, subjectDeviceID []byte, role string) ([]byte, error) {
+	return signBytesMemberRole("member_promote", memberEventID, vaultID, memberSeq, prevHash, actorDeviceID, subjectDeviceID, role)
+}
+
+// SignBytesMemberDemote covers a member_demote event lowering the subject to
+// role.
+func SignBytesMemberDemote(memberEventID, vaultID []byte, memberSeq uint64, prevHash, actorDeviceID, subjectDeviceID []byte, role string) ([]byte, error) {
+	return signBytesMemberRole("member_demote", memberEventID, vaultID, memberSeq, prevHash, actorDeviceID, subjectDeviceID, role)
+}
+
+func signBytesMemberRole(msgType string, memberEventID, vaultID []byte, memberSeq uint64, prevHash, actorDeviceID, subjectDeviceID []byte, role string) ([]byte, error) {
+	e := NewEncoder()
+	e.WriteString(SignPrefix)
+	e.WriteString(msgType)
+	if err := e.WriteUUID(memberEventID); err != nil {
+		return nil, fmt.Errorf("member_event_id: %w", err)
+	}
+	if err := e.WriteUUID(vaultID); err != nil {
+		return nil, fmt.Errorf("vault_id: %w", err)
+	}
+	e.WriteU64(memberSeq)
+	if err := e.WriteHash(prevHash); err != nil {
+		return nil, fmt.Errorf("prev_hash: %w", err)
+	}
+	if err := e.WriteDeviceID(actorDeviceID); err != nil {
+		return nil, fmt.Errorf("actor_device_id: %w", err)
+	}
+	if err := e.WriteDeviceID(subjectDeviceID); err != nil {
+		return nil, fmt.Errorf("subject_device_id: %w", err)
+	}
+	e.WriteString(role)
+	return e.Bytes(), nil
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"os"
 	"strconv"
@@ -19,6 +20,30 @@ type Config struct {
 	WriteTimeout       time.Duration
 	IdleTimeout        time.Duration
 
+	RequestMaxClockSkew time.Duration
+	AuthChallengeTTL    time.Duration
+	SessionTTL          time.Duration
+
+	DefaultPageSize int
+	MaxPageSize     int
+
+	FreezeOnEquivocation bool
+
+	VaultPurgeGrace    time.Duration
+	VaultPurgeInterval time.Duration
+
+	InviteSweepInterval time.Duration
+	InviteMaxLifetime   time.Duration
+
+	PairingSlotTTL     time.Duration
+	PairingLookupRPS   float64
+	PairingLookupBurst int
+
+	LongPollMaxWait   time.Duration
+	StreamMaxDuration time.Duration
+	StreamHeartbeat   time.Duration
+	StreamBufferSize  int
+
 	LogLevel string
 }
 
@@ -32,6 +57,23 @@ func Load() *Config {
 		ReadTimeout:                time.Duration(getEnvIntOrDefault("FORGOR_READ_TIMEOUT_SEC", 30)) * time.Second,
 		WriteTimeout:               time.Duration(getEnvIntOrDefault("FORGOR_WRITE_TIMEOUT_SEC", 60)) * time.Second,
 		IdleTimeout:                time.Duration(getEnvIntOrDefault("FORGOR_IDLE_TIMEOUT_SEC", 120)) * time.Second,
+		RequestMaxClockSkew:        time.Duration(getEnvIntOrDefault("FORGOR_REQUEST_MAX_SKEW_SEC", 300)) * time.Second,
+		AuthChallengeTTL:           time.Duration(getEnvIntOrDefault("FORGOR_AUTH_CHALLENGE_TTL_SEC", 120)) * time.Second,
+		SessionTTL:                 time.Duration(getEnvIntOrDefault("FORGOR_SESSION_TTL_SEC", 3600)) * time.Second,
+		DefaultPageSize:            getEnvIntOrDefault("FORGOR_DEFAULT_PAGE_SIZE", 100),
+		MaxPageSize:                getEnvIntOrDefault("FORGOR_MAX_PAGE_SIZE", 500),
+		FreezeOnEquivocation:       getEnvBoolOrDefault("FORGOR_FREEZE_ON_EQUIVOCATION", false),
+		VaultPurgeGrace:            time.Duration(getEnvIntOrDefault("FORGOR_VAULT_PURGE_GRACE_SEC", 7*24*3600)) * time.Second,
+		VaultPurgeInterval:         time.Duration(getEnvIntOrDefault("FORGOR_VAULT_PURGE_INTERVAL_SEC", 3600)) * time.Second,
+		InviteSweepInterval:        time.Duration(getEnvIntOrDefault("FORGOR_INVITE_SWEEP_INTERVAL_SEC", 300)) * time.Second,
+		InviteMaxLifetime:          time.Duration(getEnvIntOrDefault("FORGOR_INVITE_MAX_LIFETIME_SEC", 7*24*3600)) * time.Second,
+		PairingSlotTTL:             time.Duration(getEnvIntOrDefault("FORGOR_PAIRING_SLOT_TTL_SEC", 600)) * time.Second,
+		PairingLookupRPS:           getEnvFloatOrDefault("FORGOR_PAIRING_LOOKUP_RPS", 0.1),
+		PairingLookupBurst:         getEnvIntOrDefault("FORGOR_PAIRING_LOOKUP_BURST", 5),
+		LongPollMaxWait:            time.Duration(getEnvIntOrDefault("FORGOR_LONG_POLL_MAX_WAIT_SEC", 25)) * time.Second,
+		StreamMaxDuration:          time.Duration(getEnvIntOrDefault("FORGOR_STREAM_MAX_DURATION_SEC", 3600)) * time.Second,
+		StreamHeartbeat:            time.Duration(getEnvIntOrDefault("FORGOR_STREAM_HEARTBEAT_SEC", 15)) * time.Second,
+		StreamBufferSize:           getEnvIntOrDefault("FORGOR_STREAM_BUFFER_SIZE", 64),
 		LogLevel:                   getEnvOrDefault("FORGOR_LOG_LEVEL", "info"),
 	}
 
@@ -43,6 +85,22 @@ func Load() *Config {
 	return cfg
 }
 
+// Validate rejects settings the server cannot run with. A page size below
+// one would make every listing an empty page with has_more set, and a
+// non-positive invite lifetime would expire every invite on creation.
+func (c *Config) Validate() error {
+	if c.DefaultPageSize < 1 {
+		return errors.New("FORGOR_DEFAULT_PAGE_SIZE must be positive")
+	}
+	if c.MaxPageSize < 1 {
+		return errors.New("FORGOR_MAX_PAGE_SIZE must be positive")
+	}
+	if c.InviteMaxLifetime <= 0 {
+		return errors.New("FORGOR_INVITE_MAX_LIFETIME_SEC must be positive")
+	}
+	return nil
+}
+
 func getEnvOrDefault(key, defaultVal string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
@@ -67,3 +125,12 @@ func getEnvFloatOrDefault(key string, defaultVal float64) float64 {
 	}
 	return defaultVal
 }
+
+func getEnvBoolOrDefault(key string, defaultVal bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRejectsNonPositivePageSizes(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultPageSize int
+		maxPageSize     int
+		ok              bool
+	}{
+		{"defaults", 100, 500, true},
+		{"default above max", 100, 1, true},
+		{"zero max", 100, 0, false},
+		{"negative max", 100, -1, false},
+		{"zero default", 0, 500, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{DefaultPageSize: tt.defaultPageSize, MaxPageSize: tt.maxPageSize, InviteMaxLifetime: time.Hour}
+			if err := cfg.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate: want ok=%v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsNonPositiveInviteLifetime(t *testing.T) {
+	for _, lifetime := range []time.Duration{0, -time.Second} {
+		cfg := &Config{DefaultPageSize: 100, MaxPageSize: 500, InviteMaxLifetime: lifetime}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate with invite lifetime %v: want error", lifetime)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"fmt"
@@ -19,7 +20,9 @@ type DB struct {
 }
 
 func Open(dbPath string) (*DB, error) {
-	connStr := fmt.Sprintf("file:%s?_journal_mode=WAL&_foreign_keys=ON&_busy_timeout=5000&_synchronous=NORMAL", dbPath)
+	// Write transactions use BEGIN IMMEDIATE so that a validate-then-write
+	// sequence holds the write lock from its first read.
+	connStr := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)&_txlock=immediate", dbPath)
 
 	sqlDB, err := sql.Open("sqlite", connStr)
 	if err != nil {
@@ -120,6 +123,32 @@ func (db *DB) migrate() error {
 	return nil
 }
 
+// WithTx runs fn inside a transaction, committing if it returns nil and
+// rolling back on error or panic.
+func (db *DB) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 func (db *DB) Close() error {
 	return db.DB.Close()
 }
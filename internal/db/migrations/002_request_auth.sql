@@ -0,0 +1,8 @@
+CREATE TABLE request_nonces (
+    device_id    TEXT NOT NULL,
+    nonce        BLOB NOT NULL,
+    created_at   TEXT NOT NULL,
+    PRIMARY KEY (device_id, nonce)
+);
+
+CREATE INDEX idx_request_nonces_created_at ON request_nonces(created_at);
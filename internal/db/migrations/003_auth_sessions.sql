@@ -0,0 +1,19 @@
+CREATE TABLE auth_challenges (
+    challenge    BLOB PRIMARY KEY,
+    device_id    TEXT NOT NULL,
+    created_at   TEXT NOT NULL,
+    expires_at   TEXT NOT NULL
+);
+
+CREATE INDEX idx_auth_challenges_expires_at ON auth_challenges(expires_at);
+
+CREATE TABLE auth_sessions (
+    token_hash   BLOB PRIMARY KEY,
+    device_id    TEXT NOT NULL,
+    created_at   TEXT NOT NULL,
+    expires_at   TEXT NOT NULL,
+    revoked      INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_auth_sessions_device_id ON auth_sessions(device_id);
+CREATE INDEX idx_auth_sessions_expires_at ON auth_sessions(expires_at);
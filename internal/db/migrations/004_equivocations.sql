@@ -0,0 +1,16 @@
+CREATE TABLE equivocations (
+    equivocation_id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    vault_id             BLOB NOT NULL,
+    device_id            TEXT NOT NULL,
+    kind                 TEXT NOT NULL,
+    position             INTEGER NOT NULL,
+    stored_message       TEXT NOT NULL,
+    conflicting_message  TEXT NOT NULL,
+    conflicting_hash     BLOB NOT NULL,
+    created_at           TEXT NOT NULL
+);
+
+CREATE INDEX idx_equivocations_vault ON equivocations(vault_id, equivocation_id);
+CREATE UNIQUE INDEX idx_equivocations_conflict ON equivocations(vault_id, kind, device_id, position, conflicting_hash);
+
+ALTER TABLE vault_members ADD COLUMN frozen INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE vaults ADD COLUMN key_epoch INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- member_seq of the member_remove that requires a key rotation; 0 when none
+-- is pending.
+ALTER TABLE vaults ADD COLUMN rotation_pending_seq INTEGER NOT NULL DEFAULT 0;
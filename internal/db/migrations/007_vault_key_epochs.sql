@@ -0,0 +1,13 @@
+CREATE TABLE vault_key_epochs (
+    vault_id         BLOB NOT NULL,
+    key_epoch        INTEGER NOT NULL,
+    member_seq       INTEGER NOT NULL,
+    member_head_hash BLOB NOT NULL,
+    completed_at     TEXT NOT NULL,
+    PRIMARY KEY (vault_id, key_epoch)
+);
+
+-- Every vault starts at epoch 1, bound to its genesis member event.
+INSERT INTO vault_key_epochs (vault_id, key_epoch, member_seq, member_head_hash, completed_at)
+SELECT vault_id, 1, member_seq, member_hash, created_at
+FROM member_events WHERE member_seq = 1;
@@ -0,0 +1,10 @@
+ALTER TABLE vault_members ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+
+UPDATE vault_members SET role = 'owner'
+WHERE EXISTS (
+    SELECT 1 FROM vaults
+    WHERE vaults.vault_id = vault_members.vault_id AND vaults.owner_device_id = vault_members.device_id
+);
+
+-- Role carried by member_promote / member_demote; empty for other types.
+ALTER TABLE member_events ADD COLUMN role TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,37 @@
+-- Number of admin signatures (the proposer's included) a membership change
+-- needs before it is appended; set by vault_policy events.
+ALTER TABLE vaults ADD COLUMN member_quorum INTEGER NOT NULL DEFAULT 1;
+
+-- Quorum carried by vault_policy; 0 for other types.
+ALTER TABLE member_events ADD COLUMN quorum INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE member_proposals (
+    member_event_id     BLOB PRIMARY KEY,
+    vault_id            BLOB NOT NULL,
+    member_seq          INTEGER NOT NULL,
+    prev_hash           BLOB NOT NULL,
+    actor_device_id     TEXT NOT NULL,
+    subject_device_id   TEXT NOT NULL,
+    msg_type            TEXT NOT NULL,
+    subject_pubkey_sign BLOB,
+    subject_pubkey_box  BLOB,
+    subject_bundle_sig  BLOB,
+    invite_id           BLOB,
+    claim_sig           BLOB,
+    role                TEXT NOT NULL DEFAULT '',
+    quorum              INTEGER NOT NULL DEFAULT 0,
+    signature           BLOB NOT NULL,
+    member_hash         BLOB NOT NULL,
+    status              TEXT NOT NULL,
+    created_at          TEXT NOT NULL
+);
+
+CREATE INDEX idx_member_proposals_vault_status ON member_proposals(vault_id, status);
+
+CREATE TABLE member_proposal_signatures (
+    member_event_id BLOB NOT NULL,
+    device_id       TEXT NOT NULL,
+    signature       BLOB NOT NULL,
+    created_at      TEXT NOT NULL,
+    PRIMARY KEY (member_event_id, device_id)
+);
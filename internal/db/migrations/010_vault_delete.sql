@@ -0,0 +1,8 @@
+-- A deleted vault keeps its row as a tombstone so its id stays taken and
+-- requests get 410 Gone; its data is purged once the grace period is over.
+ALTER TABLE vaults ADD COLUMN deleted_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE vaults ADD COLUMN deleted_by_device_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE vaults ADD COLUMN delete_signature BLOB;
+ALTER TABLE vaults ADD COLUMN purged_at TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_vaults_deleted_at ON vaults(deleted_at) WHERE deleted_at != '';
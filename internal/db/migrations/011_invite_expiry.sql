@@ -0,0 +1,5 @@
+-- RFC3339 expiry signed into v2 invites; empty for v1 invites, which expire
+-- FORGOR_INVITE_MAX_LIFETIME_SEC after created_at instead.
+ALTER TABLE invites ADD COLUMN expires_at TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_invites_expires_at ON invites(expires_at) WHERE expires_at != '';
@@ -0,0 +1,6 @@
+-- Set when the creator revokes an invite; a revoked invite can no longer be
+-- claimed or used in a member_add.
+ALTER TABLE invites ADD COLUMN revoked_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE invites ADD COLUMN revoke_signature BLOB;
+
+CREATE INDEX idx_invites_created_by_created_at ON invites(created_by_device_id, created_at);
@@ -0,0 +1,18 @@
+-- Invites count their uses against max_uses (0 = unlimited) instead of a
+-- used flag. Multi-use invites have no target_device_id; they are addressed
+-- to the devices in invite_targets, or to any registered device when it has
+-- none.
+ALTER TABLE invites ADD COLUMN max_uses INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE invites ADD COLUMN use_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE invites SET max_uses = single_use, use_count = used;
+
+ALTER TABLE invites DROP COLUMN used;
+
+CREATE TABLE invite_targets (
+    invite_id BLOB NOT NULL,
+    device_id TEXT NOT NULL,
+    PRIMARY KEY (invite_id, device_id)
+);
+
+CREATE INDEX idx_invite_targets_device_id ON invite_targets(device_id);
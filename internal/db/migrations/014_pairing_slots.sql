@@ -0,0 +1,13 @@
+-- A new device waiting to be invited, addressed by the SHA-256 of a short
+-- code it shows its user. An admin looks it up once to learn the device's
+-- bundle and then sends an ordinary invite.
+CREATE TABLE pairing_slots (
+    code_hash              BLOB PRIMARY KEY,
+    device_id              TEXT NOT NULL,
+    expires_at             TEXT NOT NULL,
+    looked_up_by_device_id TEXT NOT NULL DEFAULT '',
+    created_at             TEXT NOT NULL
+);
+
+CREATE INDEX idx_pairing_slots_device_id ON pairing_slots(device_id);
+CREATE INDEX idx_pairing_slots_expires_at ON pairing_slots(expires_at);
@@ -0,0 +1,10 @@
+-- When the invite was last used by a member_add, backfilled from the
+-- membership log.
+ALTER TABLE invites ADD COLUMN last_used_at TEXT NOT NULL DEFAULT '';
+
+UPDATE invites SET last_used_at = COALESCE((
+    SELECT MAX(me.created_at) FROM member_events me
+    WHERE me.invite_id = invites.invite_id AND me.msg_type = 'member_add'
+), '');
+
+CREATE INDEX idx_invites_vault_created_at ON invites(vault_id, created_at);
@@ -0,0 +1,242 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/cbe"
+	"forgor-server/internal/crypto"
+	"forgor-server/internal/logging"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+const (
+	HeaderDeviceID  = "X-Forgor-Device-ID"
+	HeaderTimestamp = "X-Forgor-Timestamp"
+	HeaderNonce     = "X-Forgor-Nonce"
+	HeaderSignature = "X-Forgor-Signature"
+)
+
+type contextKey string
+
+const (
+	authDeviceIDKey contextKey = "auth_device_id"
+)
+
+func withAuthenticatedDevice(ctx context.Context, deviceID string) context.Context {
+	return context.WithValue(ctx, authDeviceIDKey, deviceID)
+}
+
+func authenticatedDeviceID(ctx context.Context) string {
+	deviceID, _ := ctx.Value(authDeviceIDKey).(string)
+	return deviceID
+}
+
+func requireDevice(r *http.Request, deviceID string) *apierror.APIError {
+	if authenticatedDeviceID(r.Context()) != deviceID {
+		return apierror.DeviceMismatch()
+	}
+	return nil
+}
+
+type RequestVerifier struct {
+	devices *storage.DevicesRepository
+	auth    *storage.AuthRepository
+	maxSkew time.Duration
+	cleanup time.Duration
+}
+
+func NewRequestVerifier(devices *storage.DevicesRepository, auth *storage.AuthRepository, maxSkew time.Duration) *RequestVerifier {
+	rv := &RequestVerifier{
+		devices: devices,
+		auth:    auth,
+		maxSkew: maxSkew,
+		cleanup: time.Minute * 5,
+	}
+
+	go rv.cleanupLoop()
+
+	return rv
+}
+
+// Verify authenticates the request either by a session bearer token or by the
+// signed-request headers, and returns the authenticated device_id.
+func (rv *RequestVerifier) Verify(r *http.Request) (string, *apierror.APIError) {
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		token, ok := strings.CutPrefix(authz, "Bearer ")
+		if !ok {
+			return "", apierror.InvalidSession()
+		}
+		return rv.verifyToken(r.Context(), token)
+	}
+
+	deviceIDStr := r.Header.Get(HeaderDeviceID)
+	timestampStr := r.Header.Get(HeaderTimestamp)
+	nonceStr := r.Header.Get(HeaderNonce)
+	signatureStr := r.Header.Get(HeaderSignature)
+	if deviceIDStr == "" || timestampStr == "" || nonceStr == "" || signatureStr == "" {
+		return "", apierror.MissingAuthentication()
+	}
+
+	deviceID := models.DeviceID(deviceIDStr)
+	if err := deviceID.Validate(); err != nil {
+		return "", apierror.InvalidDeviceID()
+	}
+
+	timestamp, err := strconv.ParseUint(timestampStr, 10, 64)
+	if err != nil {
+		return "", apierror.BadRequest("invalid_timestamp", "timestamp must be unix seconds")
+	}
+	skew := time.Since(time.Unix(int64(timestamp), 0))
+	if skew < -rv.maxSkew || skew > rv.maxSkew {
+		return "", apierror.RequestExpired()
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(nonceStr)
+	if err != nil || len(nonce) != models.NonceLength {
+		return "", apierror.InvalidNonce()
+	}
+	signature, err := base64.StdEncoding.DecodeString(signatureStr)
+	if err != nil || len(signature) != models.SignatureLength {
+		return "", apierror.InvalidRequestSignature()
+	}
+
+	ctx := r.Context()
+
+	device, err := rv.devices.Get(ctx, deviceIDStr)
+	if err != nil {
+		return "", apierror.InternalError()
+	}
+	if device == nil {
+		return "", apierror.UnknownDevice()
+	}
+
+	deviceIDBytes, err := crypto.DeviceIDToBytes(deviceIDStr)
+	if err != nil {
+		return "", apierror.InvalidDeviceID()
+	}
+
+	signBytes, err := cbe.SignBytesRequest(deviceIDBytes, r.Method, r.URL.Path, r.URL.Query().Encode(), timestamp, nonce)
+	if err != nil {
+		return "", apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+
+	if err := crypto.VerifySignature(device.DevicePubkeySign, signBytes, signature); err != nil {
+		return "", apierror.InvalidRequestSignature()
+	}
+
+	fresh, err := rv.auth.RecordRequestNonce(ctx, deviceIDStr, nonce)
+	if err != nil {
+		return "", apierror.InternalError()
+	}
+	if !fresh {
+		return "", apierror.RequestReplayed()
+	}
+
+	return deviceIDStr, nil
+}
+
+func (rv *RequestVerifier) verifyToken(ctx context.Context, token string) (string, *apierror.APIError) {
+	tokenHash, err := hashSessionToken(token)
+	if err != nil {
+		return "", apierror.InvalidSession()
+	}
+
+	session, err := rv.auth.GetSession(ctx, tokenHash)
+	if err != nil {
+		return "", apierror.InternalError()
+	}
+	if session == nil || session.Revoked {
+		return "", apierror.InvalidSession()
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, session.ExpiresAt)
+	if err != nil || time.Now().After(expiresAt) {
+		return "", apierror.InvalidSession()
+	}
+
+	return session.DeviceID, nil
+}
+
+func hashSessionToken(token string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != models.TokenLength {
+		return nil, fmt.Errorf("token must be %d bytes", models.TokenLength)
+	}
+	return crypto.SHA256Hash(raw), nil
+}
+
+func (rv *RequestVerifier) cleanupLoop() {
+	ticker := time.NewTicker(rv.cleanup)
+	for range ticker.C {
+		ctx := context.Background()
+		// Nonces older than twice the skew window can never be replayed successfully.
+		if err := rv.auth.PruneRequestNonces(ctx, time.Now().Add(-2*rv.maxSkew)); err != nil {
+			slog.Error("failed to prune request nonces", "error", err)
+		}
+		if err := rv.auth.PruneExpired(ctx, time.Now()); err != nil {
+			slog.Error("failed to prune auth sessions", "error", err)
+		}
+	}
+}
+
+func RequestAuthMiddleware(verifier *RequestVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deviceID, apiErr := verifier.Verify(r)
+			if apiErr != nil {
+				logging.FromContext(r.Context()).Info("request authentication failed", "code", apiErr.Code, "path", r.URL.Path)
+				apiErr.WriteJSON(w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withAuthenticatedDevice(r.Context(), deviceID)))
+		})
+	}
+}
+
+// VaultMemberMiddleware requires the authenticated device to be a current
+// member of the vault named by the {vault_id} path parameter, and the vault
+// not to have been deleted.
+func VaultMemberMiddleware(vaults *storage.VaultsRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			vaultID, err := extractVaultID(r)
+			if err != nil {
+				apierror.InvalidUUID("vault_id").WriteJSON(w)
+				return
+			}
+
+			isMember, err := vaults.IsMember(r.Context(), vaultID, authenticatedDeviceID(r.Context()))
+			if err != nil {
+				apierror.InternalError().WriteJSON(w)
+				return
+			}
+			if !isMember {
+				apierror.MembershipRequired().WriteJSON(w)
+				return
+			}
+
+			vault, err := vaults.Get(r.Context(), vaultID)
+			if err != nil {
+				apierror.InternalError().WriteJSON(w)
+				return
+			}
+			if vault != nil && vault.DeletedAt != "" {
+				apierror.VaultDeleted().WriteJSON(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
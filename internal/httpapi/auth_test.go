@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReadsRequireSignedRequests(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, outsider := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(outsider)
+	v := ts.genesis(owner)
+
+	reads := []string{
+		"/v1/devices/" + owner.id,
+		"/v1/invites?device_id=" + owner.id,
+		"/v1/key_updates?device_id=" + owner.id,
+		"/v1/invite_claims?created_by_device_id=" + owner.id,
+		v.path("/events"),
+		v.path("/member_events"),
+		v.path("/members"),
+		v.path("/snapshots/latest"),
+	}
+	for _, path := range reads {
+		if r := ts.do("GET", path, nil, nil); r.status != http.StatusUnauthorized || r.errorCode() != "missing_authentication" {
+			t.Errorf("unsigned GET %s: want 401 missing_authentication, got %s", path, r)
+		}
+	}
+
+	ts.must(ts.do("GET", "/v1/devices/"+owner.id, nil, outsider), http.StatusOK, "signed device lookup")
+	ts.must(ts.do("GET", v.path("/members"), nil, owner), http.StatusOK, "member reads members")
+	ts.must(ts.do("GET", v.path("/members"), nil, outsider), http.StatusForbidden, "non-member reads members")
+	ts.must(ts.do("GET", "/v1/invites?device_id="+owner.id, nil, outsider), http.StatusForbidden, "reading another device's invites")
+
+	req := ts.newRequest("GET", v.path("/events"), nil, owner)
+	req.Header.Set("X-Forgor-Signature", b64(outsider.sign([]byte("not the request"))))
+	if r := ts.send(req); r.status != http.StatusUnauthorized || r.errorCode() != "invalid_request_signature" {
+		t.Errorf("forged signature: want 401 invalid_request_signature, got %s", r)
+	}
+
+	req = ts.newRequest("GET", v.path("/events"), nil, owner)
+	ts.must(ts.send(req), http.StatusOK, "signed read")
+	if r := ts.send(req); r.status != http.StatusUnauthorized || r.errorCode() != "request_replayed" {
+		t.Errorf("replayed request: want 401 request_replayed, got %s", r)
+	}
+}
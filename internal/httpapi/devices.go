@@ -1,6 +1,7 @@
 package httpapi
 
 import (
+	"database/sql"
 	"net/http"
 
 	"forgor-server/internal/apierror"
@@ -24,28 +25,30 @@ func (s *Server) handleDeviceRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if apiErr := s.deviceValidator.CheckImmutability(ctx, &bundle); apiErr != nil {
-		apiErr.WriteJSON(w)
-		return
-	}
-
-	existing, err := s.devices.Get(ctx, string(bundle.DeviceID))
+	status := http.StatusCreated
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if apiErr := s.deviceValidator.WithTx(tx).CheckImmutability(ctx, &bundle); apiErr != nil {
+			return apiErr
+		}
+
+		devices := s.devices.WithTx(tx)
+		existing, err := devices.Get(ctx, string(bundle.DeviceID))
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			status = http.StatusOK
+			return nil
+		}
+
+		return devices.Create(ctx, &bundle)
+	})
 	if err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
-	}
-
-	if existing != nil {
-		writeJSON(w, http.StatusOK, bundle)
-		return
-	}
-
-	if err := s.devices.Create(ctx, &bundle); err != nil {
-		apierror.InternalError().WriteJSON(w)
+		writeError(w, r, err)
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, bundle)
+	writeJSON(w, status, bundle)
 }
 
 func (s *Server) handleDeviceGet(w http.ResponseWriter, r *http.Request) {
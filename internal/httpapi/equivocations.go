@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/crypto"
+	"forgor-server/internal/logging"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+// isEquivocation reports whether err rejected a message for conflicting with
+// one the signer already has at that chain position.
+func isEquivocation(err error) bool {
+	var apiErr *apierror.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == "equivocation"
+}
+
+// recordEventEquivocation keeps the stored event and the conflicting one as
+// evidence. It runs after the rejecting transaction has rolled back, so a
+// failure here is logged and does not change the response.
+func (s *Server) recordEventEquivocation(ctx context.Context, event *models.Event) {
+	stored, err := s.events.GetByCounter(ctx, event.VaultID.Bytes(), string(event.DeviceID), uint64(event.Counter))
+	if err != nil || stored == nil {
+		logging.FromContext(ctx).Error("failed to load equivocated event", "error", err)
+		return
+	}
+	s.recordEquivocation(ctx, &storage.EquivocationRow{
+		VaultID:         stored.VaultID,
+		DeviceID:        stored.DeviceID,
+		Kind:            "event",
+		Position:        stored.Counter,
+		ConflictingHash: crypto.SHA256Hash(event.Signature),
+	}, eventFromRow(stored), event)
+}
+
+func (s *Server) recordMemberEquivocation(ctx context.Context, event *models.MemberEvent) {
+	stored, err := s.memberEvents.GetBySeq(ctx, event.VaultID.Bytes(), uint64(event.MemberSeq))
+	if err != nil || stored == nil {
+		logging.FromContext(ctx).Error("failed to load equivocated member event", "error", err)
+		return
+	}
+	s.recordEquivocation(ctx, &storage.EquivocationRow{
+		VaultID:         stored.VaultID,
+		DeviceID:        stored.ActorDeviceID,
+		Kind:            "member_event",
+		Position:        stored.MemberSeq,
+		ConflictingHash: crypto.SHA256Hash(event.Signature),
+	}, memberEventFromRow(stored), event)
+}
+
+// recordEquivocation stores both messages on row and, if configured, freezes
+// the signer's chain.
+func (s *Server) recordEquivocation(ctx context.Context, row *storage.EquivocationRow, stored, conflicting any) {
+	logger := logging.FromContext(ctx)
+
+	var err error
+	if row.StoredMessage, err = json.Marshal(stored); err == nil {
+		row.ConflictingMessage, err = json.Marshal(conflicting)
+	}
+	if err != nil {
+		logger.Error("failed to encode equivocation", "error", err)
+		return
+	}
+
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.equivocations.WithTx(tx).Create(ctx, row); err != nil {
+			return err
+		}
+		if !s.config.FreezeOnEquivocation {
+			return nil
+		}
+		return s.vaults.WithTx(tx).SetMemberFrozen(ctx, row.VaultID, row.DeviceID)
+	})
+	if err != nil {
+		logger.Error("failed to record equivocation", "error", err)
+		return
+	}
+	logger.Warn("equivocation recorded", "device_id", row.DeviceID, "kind", row.Kind, "position", row.Position, "frozen", s.config.FreezeOnEquivocation)
+}
+
+func (s *Server) handleEquivocationsList(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	page, apiErr := s.parsePage(r, "equivocations")
+	if apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	pw := newPageWriter(w, "equivocations", page)
+	err = s.equivocations.ListByVault(r.Context(), vaultID, page, func(e *storage.EquivocationRow) error {
+		item := models.Equivocation{
+			EquivocationID:     models.Uint64String(e.EquivocationID),
+			VaultID:            bytesToUUID(e.VaultID),
+			DeviceID:           models.DeviceID(e.DeviceID),
+			Kind:               e.Kind,
+			Position:           models.Uint64String(e.Position),
+			StoredMessage:      e.StoredMessage,
+			ConflictingMessage: e.ConflictingMessage,
+			CreatedAt:          e.CreatedAt,
+		}
+		return pw.write(item, storage.Cursor{Seq: e.EquivocationID})
+	})
+	if err != nil {
+		pw.fail(r, err)
+		return
+	}
+	pw.finish()
+}
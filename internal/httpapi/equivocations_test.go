@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"forgor-server/internal/config"
+)
+
+func TestFrozenDeviceCannotWriteUntilRemoved(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.FreezeOnEquivocation = true })
+	owner, admin, member, outsider := newTestDevice(t), newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, admin, member, outsider} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, admin)
+	ts.addMember(v, owner, member)
+	ts.must(ts.roleChange(v, "member_promote", owner, admin, "admin"), http.StatusCreated, "promote")
+
+	ts.must(ts.push(v, admin, 1), http.StatusCreated, "first event")
+	conflicting, _ := ts.eventBody(v, admin, 1, 1, nil)
+	ts.must(ts.do("POST", v.path("/events"), conflicting, admin), http.StatusConflict, "equivocating event")
+
+	frozen := func(what string, r testResponse) {
+		t.Helper()
+		if r.status != http.StatusForbidden || r.errorCode() != "device_frozen" {
+			t.Errorf("%s: want 403 device_frozen, got %s", what, r)
+		}
+	}
+	frozen("push", ts.push(v, admin, 1))
+	_, r := ts.tryInvite(v, admin, outsider)
+	frozen("invite", r)
+	frozen("member_remove", ts.memberRemove(v, admin, member))
+	frozen("member_demote", ts.roleChange(v, "member_demote", admin, member, "reader"))
+	frozen("key_update", ts.keyUpdate(v, admin, member, 2))
+	frozen("key_update_ack", ts.ack(v, admin, 1))
+
+	// Leaving is still allowed and ends the freeze.
+	ts.must(ts.memberLeave(v, admin), http.StatusCreated, "frozen device leaves")
+	if n := ts.count("SELECT frozen FROM vault_members WHERE vault_id = ? AND device_id = ?", v.id[:], admin.id); n != 0 {
+		t.Errorf("frozen after leave: want 0, got %d", n)
+	}
+
+	ts.addMember(v, owner, admin)
+	if n := ts.count("SELECT frozen FROM vault_members WHERE vault_id = ? AND device_id = ?", v.id[:], admin.id); n != 0 {
+		t.Errorf("frozen after re-add: want 0, got %d", n)
+	}
+}
+
+func TestAdminTakesOverFromFrozenOwner(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.FreezeOnEquivocation = true })
+	owner, admin, member := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, admin, member} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, admin)
+	ts.addMember(v, owner, member)
+	ts.must(ts.roleChange(v, "member_promote", owner, admin, "admin"), http.StatusCreated, "promote")
+
+	if r := ts.ownerTransfer(v, admin, admin); r.status != http.StatusForbidden {
+		t.Fatalf("admin takeover from an active owner: want 403, got %s", r)
+	}
+
+	// The owner signs two different member events at the same member_seq.
+	seq, head := v.seq, v.head
+	ts.must(ts.policy(v, owner, 1), http.StatusCreated, "policy")
+	nextSeq, nextHead := v.seq, v.head
+	v.seq, v.head = seq, head
+	ts.must(ts.policy(v, owner, 2), http.StatusConflict, "equivocating policy")
+	v.seq, v.head = nextSeq, nextHead
+	if n := ts.count("SELECT frozen FROM vault_members WHERE vault_id = ? AND device_id = ?", v.id[:], owner.id); n != 1 {
+		t.Fatalf("owner frozen: want 1, got %d", n)
+	}
+
+	if r := ts.memberRemove(v, owner, member); r.errorCode() != "device_frozen" {
+		t.Errorf("frozen owner removes a member: want device_frozen, got %s", r)
+	}
+	if r := ts.memberRemove(v, admin, owner); r.status != http.StatusBadRequest || r.errorCode() != "cannot_remove_owner" {
+		t.Errorf("removing the frozen owner: want 400 cannot_remove_owner, got %s", r)
+	}
+	if r := ts.ownerTransfer(v, member, member); r.status != http.StatusForbidden {
+		t.Errorf("member takeover: want 403, got %s", r)
+	}
+
+	ts.must(ts.ownerTransfer(v, admin, admin), http.StatusCreated, "admin takes over from the frozen owner")
+	ts.must(ts.memberRemove(v, admin, owner), http.StatusCreated, "new owner removes the frozen device")
+	if n := ts.count("SELECT frozen FROM vault_members WHERE vault_id = ? AND device_id = ?", v.id[:], owner.id); n != 0 {
+		t.Errorf("frozen after removal: want 0, got %d", n)
+	}
+	m := ts.must(ts.do("GET", v.path("/members"), nil, admin), http.StatusOK, "members").json()
+	if m["owner_device_id"] != admin.id {
+		t.Errorf("owner_device_id: want %s, got %v", admin.id, m["owner_device_id"])
+	}
+}
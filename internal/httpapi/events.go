@@ -2,11 +2,17 @@ package httpapi
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/models"
 	"forgor-server/internal/storage"
+	"forgor-server/internal/validation"
 )
 
 func (s *Server) handleEventCreate(w http.ResponseWriter, r *http.Request) {
@@ -27,35 +33,190 @@ func (s *Server) handleEventCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if apiErr := requireDevice(r, string(event.DeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
 	ctx := r.Context()
 
-	row, apiErr := s.eventsValidator.ValidateEvent(ctx, &event)
-	if apiErr != nil {
+	unlock := s.writeLocks.Lock(deviceChainLockKey(vaultID, string(event.DeviceID)))
+	defer unlock()
+
+	var created *storage.EventRow
+	var resubmitted bool
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		row, apiErr := s.eventsValidator.WithTx(tx).ValidateEvent(ctx, &event)
+		if apiErr != nil {
+			return apiErr
+		}
+		created = row
+		if row.Seq != 0 {
+			resubmitted = true
+			return nil
+		}
+
+		seq, err := s.appendEvent(ctx, tx, row)
+		if err != nil {
+			return err
+		}
+		row.Seq = seq
+		return nil
+	})
+	if isEquivocation(err) {
+		s.recordEventEquivocation(ctx, &event)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// A retry of an event that was already committed gets the original seq.
+	if resubmitted {
+		writeJSON(w, http.StatusOK, models.EventResponse{Seq: models.Uint64String(created.Seq)})
+		return
+	}
+
+	s.hub.Publish(vaultID, StreamMessage{
+		Event: "event",
+		Seq:   created.Seq,
+		Data:  eventFromRow(created),
+	})
+
+	response := models.EventResponse{
+		Seq: models.Uint64String(created.Seq),
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// handleEventBatchCreate accepts an ordered chain of the authenticated
+// device's events and commits all of them or none.
+func (s *Server) handleEventBatchCreate(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	var batch []models.Event
+	if apiErr := parseJSON(r, &batch); apiErr != nil {
 		apiErr.WriteJSON(w)
 		return
 	}
 
-	seq, err := s.events.Create(ctx, row)
+	if len(batch) == 0 {
+		apierror.BadRequest("empty_batch", "batch must contain at least one event").WriteJSON(w)
+		return
+	}
+	if len(batch) > models.MaxEventBatch {
+		apierror.BadRequest("batch_too_large", fmt.Sprintf("batch may contain at most %d events", models.MaxEventBatch)).WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+	deviceID := authenticatedDeviceID(ctx)
+
+	var itemErrs []apierror.BatchItemError
+	for i := range batch {
+		if !bytes.Equal(vaultID, batch[i].VaultID.Bytes()) {
+			itemErrs = append(itemErrs, apierror.BatchItemError{Index: i, APIError: apierror.BadRequest("vault_id_mismatch", "vault_id in path does not match body")})
+			continue
+		}
+		if apiErr := requireDevice(r, string(batch[i].DeviceID)); apiErr != nil {
+			itemErrs = append(itemErrs, apierror.BatchItemError{Index: i, APIError: apiErr})
+		}
+	}
+	if len(itemErrs) > 0 {
+		apierror.BatchRejected(itemErrs).WriteJSON(w)
+		return
+	}
+
+	unlock := s.writeLocks.Lock(deviceChainLockKey(vaultID, deviceID))
+	defer unlock()
+
+	// Each event is validated against the head left by the one before it, so
+	// the first failure stops the batch; later entries would only report the
+	// same broken chain.
+	// Entries that were already committed (a retried batch) keep their seq
+	// and are neither appended nor published again.
+	seqs := make([]models.Uint64String, 0, len(batch))
+	created := make([]*storage.EventRow, 0, len(batch))
+	var equivocated *models.Event
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		validator := s.eventsValidator.WithTx(tx)
+		for i := range batch {
+			row, apiErr := validator.ValidateEvent(ctx, &batch[i])
+			if apiErr != nil {
+				if isEquivocation(apiErr) {
+					equivocated = &batch[i]
+				}
+				return apierror.BatchRejected([]apierror.BatchItemError{{Index: i, APIError: apiErr}})
+			}
+			if row.Seq != 0 {
+				seqs = append(seqs, models.Uint64String(row.Seq))
+				continue
+			}
+
+			seq, err := s.appendEvent(ctx, tx, row)
+			if errors.As(err, &apiErr) {
+				return apierror.BatchRejected([]apierror.BatchItemError{{Index: i, APIError: apiErr}})
+			}
+			if err != nil {
+				return err
+			}
+			row.Seq = seq
+			seqs = append(seqs, models.Uint64String(seq))
+			created = append(created, row)
+		}
+		return nil
+	})
+	if equivocated != nil {
+		s.recordEventEquivocation(ctx, equivocated)
+	}
 	if err != nil {
-		apierror.InternalError().WriteJSON(w)
+		writeError(w, r, err)
 		return
 	}
 
+	for _, row := range created {
+		s.hub.Publish(vaultID, StreamMessage{
+			Event: "event",
+			Seq:   row.Seq,
+			Data:  eventFromRow(row),
+		})
+	}
+
+	status := http.StatusCreated
+	if len(created) == 0 {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, models.EventBatchResponse{Seqs: seqs})
+}
+
+// appendEvent advances the device chain head with a compare-and-swap against
+// the event's prev_hash and then stores the event, returning its seq.
+func (s *Server) appendEvent(ctx context.Context, tx *sql.Tx, row *storage.EventRow) (uint64, error) {
+	events := s.events.WithTx(tx)
+
 	head := &storage.EventHead{
-		VaultID:     vaultID,
+		VaultID:     row.VaultID,
 		DeviceID:    row.DeviceID,
 		LastCounter: row.Counter,
 		LastHash:    row.EventHash,
 	}
-	if err := s.events.UpsertEventHead(ctx, head); err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
+	advanced, err := events.AdvanceEventHead(ctx, head, row.Counter-1, row.PrevHash)
+	if err != nil {
+		return 0, err
 	}
-
-	response := models.EventResponse{
-		Seq: models.Uint64String(seq),
+	if !advanced {
+		current, err := events.GetEventHead(ctx, row.VaultID, row.DeviceID)
+		if err != nil {
+			return 0, err
+		}
+		return 0, validation.EventChainBrokenAt(row.DeviceID, current)
 	}
-	writeJSON(w, http.StatusCreated, response)
+
+	return events.Create(ctx, row)
 }
 
 func (s *Server) handleEventsList(w http.ResponseWriter, r *http.Request) {
@@ -65,40 +226,105 @@ func (s *Server) handleEventsList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, apiErr := s.parsePage(r, "events")
+	if apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	// since_seq predates cursors and is still accepted for the first page.
 	sinceSeqStr := getQueryParam(r, "since_seq")
-	var sinceSeq uint64
-	if sinceSeqStr != "" {
-		sinceSeq, err = parseUint64(sinceSeqStr)
+	if sinceSeqStr != "" && page.After == nil {
+		sinceSeq, err := parseUint64(sinceSeqStr)
 		if err != nil {
 			apierror.BadRequest("invalid_since_seq", "since_seq must be a valid integer").WriteJSON(w)
 			return
 		}
+		page.After = &storage.Cursor{Seq: sinceSeq}
+	}
+
+	var wait time.Duration
+	if waitStr := getQueryParam(r, "wait"); waitStr != "" {
+		waitSec, err := parseUint64(waitStr)
+		if err != nil {
+			apierror.BadRequest("invalid_wait", "wait must be a number of seconds").WriteJSON(w)
+			return
+		}
+		wait = s.longPollWait(r.Context(), time.Duration(waitSec)*time.Second)
+	}
+
+	ctx := r.Context()
+
+	if wait > 0 {
+		var afterSeq uint64
+		if page.After != nil {
+			afterSeq = page.After.Seq
+		}
+		if err := s.waitForEvents(ctx, vaultID, afterSeq, wait); err != nil {
+			writeError(w, r, err)
+			return
+		}
 	}
 
-	events, err := s.events.ListSince(r.Context(), vaultID, sinceSeq)
+	pw := newPageWriter(w, "events", page)
+	err = s.events.ListSince(ctx, vaultID, page, func(e *storage.EventRow) error {
+		return pw.write(eventFromRow(e), storage.Cursor{Seq: e.Seq})
+	})
 	if err != nil {
-		apierror.InternalError().WriteJSON(w)
+		pw.fail(r, err)
 		return
 	}
+	pw.finish()
+}
 
-	response := make([]models.Event, 0, len(events))
-	for _, e := range events {
-		response = append(response, models.Event{
-			MsgType:    "event",
-			EventID:    bytesToUUID(e.EventID),
-			VaultID:    bytesToUUID(e.VaultID),
-			DeviceID:   models.DeviceID(e.DeviceID),
-			Counter:    models.Uint64String(e.Counter),
-			Lamport:    models.Uint64String(e.Lamport),
-			KeyEpoch:   models.Uint64String(e.KeyEpoch),
-			PrevHash:   e.PrevHash,
-			Nonce:      e.Nonce,
-			Ciphertext: e.Ciphertext,
-			Signature:  e.Signature,
-			Seq:        models.Uint64String(e.Seq),
-			CreatedAt:  e.CreatedAt,
-		})
+// longPollWait clamps a requested wait to the configured maximum and leaves
+// headroom before the request deadline so the response still gets written.
+func (s *Server) longPollWait(ctx context.Context, requested time.Duration) time.Duration {
+	wait := min(requested, s.config.LongPollMaxWait)
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = min(wait, time.Until(deadline)-time.Second)
 	}
+	return max(wait, 0)
+}
+
+// waitForEvents blocks up to wait until the vault has an event after
+// afterSeq, returning early if one already exists.
+func (s *Server) waitForEvents(ctx context.Context, vaultID []byte, afterSeq uint64, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		notify := s.hub.Wait(vaultID)
+
+		latest, err := s.events.LatestSeq(ctx, vaultID)
+		if err != nil || latest > afterSeq || s.hub.Closed() {
+			return err
+		}
 
-	writeJSON(w, http.StatusOK, response)
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func eventFromRow(e *storage.EventRow) models.Event {
+	return models.Event{
+		MsgType:    "event",
+		EventID:    bytesToUUID(e.EventID),
+		VaultID:    bytesToUUID(e.VaultID),
+		DeviceID:   models.DeviceID(e.DeviceID),
+		Counter:    models.Uint64String(e.Counter),
+		Lamport:    models.Uint64String(e.Lamport),
+		KeyEpoch:   models.Uint64String(e.KeyEpoch),
+		PrevHash:   e.PrevHash,
+		Nonce:      e.Nonce,
+		Ciphertext: e.Ciphertext,
+		Signature:  e.Signature,
+		Seq:        models.Uint64String(e.Seq),
+		CreatedAt:  e.CreatedAt,
+	}
 }
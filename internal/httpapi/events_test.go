@@ -0,0 +1,203 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentPushesDoNotForkChain races several events at the same
+// counter; exactly one may land and the rest must be conflicts, never a
+// 500 from the unique index.
+func TestConcurrentPushesDoNotForkChain(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+
+	const n = 8
+	reqs := make([]*http.Request, n)
+	for i := range reqs {
+		body, _ := ts.eventBody(v, owner, 1, 1, nil)
+		reqs[i] = ts.newRequest("POST", v.path("/events"), body, owner)
+	}
+	results := make([]testResponse, n)
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = ts.send(reqs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, r := range results {
+		switch r.status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("racing push: want 201 or 409, got %s", r)
+		}
+	}
+	if created != 1 {
+		t.Errorf("racing pushes: want exactly 1 created, got %d", created)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM events WHERE vault_id = ?", v.id[:]); n != 1 {
+		t.Errorf("events: want 1, got %d", n)
+	}
+}
+
+func TestChainBrokenReportsCurrentHead(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+	ts.must(ts.push(v, owner, 1), http.StatusCreated, "first event")
+
+	body, _ := ts.eventBody(v, owner, 1, 2, randomBytes(32))
+	r := ts.do("POST", v.path("/events"), body, owner)
+	if r.status != http.StatusConflict || r.errorCode() != "event_chain_broken" {
+		t.Fatalf("stale prev_hash: want 409 event_chain_broken, got %s", r)
+	}
+	details, _ := r.json()["details"].(map[string]any)
+	if details["last_counter"] != "1" || details["last_hash"] != b64(v.heads[owner.id]) {
+		t.Errorf("details: want the head at counter 1, got %v", details)
+	}
+}
+
+func TestLongPollWakesOnNewEvent(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+
+	start := time.Now()
+	r := ts.must(ts.do("GET", v.path("/events?since_seq=0&wait=1"), nil, owner), http.StatusOK, "idle long poll")
+	if items, _ := r.json()["items"].([]any); len(items) != 0 {
+		t.Errorf("idle long poll: want no events, got %d", len(items))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("idle long poll returned after %v, want the full wait", elapsed)
+	}
+
+	body, _ := ts.eventBody(v, owner, 1, 1, nil)
+	push := ts.newRequest("POST", v.path("/events"), body, owner)
+	pushed := make(chan testResponse, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		pushed <- ts.send(push)
+	}()
+
+	start = time.Now()
+	r = ts.must(ts.do("GET", v.path("/events?since_seq=0&wait=10"), nil, owner), http.StatusOK, "long poll")
+	if items, _ := r.json()["items"].([]any); len(items) != 1 {
+		t.Errorf("long poll: want the new event, got %s", r)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("long poll took %v, want it to wake on the push", elapsed)
+	}
+	ts.must(<-pushed, http.StatusCreated, "push during long poll")
+}
+
+func TestEventBatchCommitsAllOrNothing(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+
+	chain := func(from uint64, n int, prev []byte) ([]map[string]any, []byte) {
+		var batch []map[string]any
+		for i := 0; i < n; i++ {
+			body, hash := ts.eventBody(v, owner, 1, from+uint64(i), prev)
+			batch = append(batch, body)
+			prev = hash
+		}
+		return batch, prev
+	}
+
+	batch, head := chain(1, 3, nil)
+	r := ts.must(ts.do("POST", v.path("/events:batch"), batch, owner), http.StatusCreated, "batch")
+	if seqs := fmt.Sprint(r.json()["seqs"]); seqs != "[1 2 3]" {
+		t.Errorf("batch seqs: want [1 2 3], got %s", seqs)
+	}
+	r = ts.must(ts.do("POST", v.path("/events:batch"), batch, owner), http.StatusOK, "retried batch")
+	if seqs := fmt.Sprint(r.json()["seqs"]); seqs != "[1 2 3]" {
+		t.Errorf("retried batch seqs: want [1 2 3], got %s", seqs)
+	}
+
+	broken, _ := chain(4, 3, head)
+	bad, _ := ts.eventBody(v, owner, 1, 5, randomBytes(32))
+	broken[1] = bad
+	r = ts.do("POST", v.path("/events:batch"), broken, owner)
+	if r.status != http.StatusConflict || r.errorCode() != "batch_rejected" {
+		t.Fatalf("broken batch: want 409 batch_rejected, got %s", r)
+	}
+	details, _ := r.json()["details"].(map[string]any)
+	errs, _ := details["errors"].([]any)
+	if len(errs) != 1 {
+		t.Fatalf("broken batch: want one rejected entry, got %s", r)
+	}
+	if e := errs[0].(map[string]any); e["index"] != float64(1) || e["code"] != "event_chain_broken" {
+		t.Errorf("rejected entry: want index 1 event_chain_broken, got %v", e)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM events WHERE vault_id = ?", v.id[:]); n != 3 {
+		t.Errorf("events after broken batch: want 3, got %d", n)
+	}
+}
+
+func TestEventResubmissionReturnsOriginalSeq(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+	ts.must(ts.push(v, owner, 1), http.StatusCreated, "first event")
+
+	body, _ := ts.eventBody(v, owner, 1, 2, v.heads[owner.id])
+	ts.must(ts.do("POST", v.path("/events"), body, owner), http.StatusCreated, "second event")
+	r := ts.must(ts.do("POST", v.path("/events"), body, owner), http.StatusOK, "resubmitted event")
+	if seq := r.json()["seq"]; seq != "2" {
+		t.Errorf("resubmission: want seq 2, got %v", seq)
+	}
+
+	other, _ := ts.eventBody(v, owner, 1, 2, v.heads[owner.id])
+	if r := ts.do("POST", v.path("/events"), other, owner); r.status != http.StatusConflict || r.errorCode() != "equivocation" {
+		t.Errorf("different event at counter 2: want 409 equivocation, got %s", r)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM events WHERE vault_id = ?", v.id[:]); n != 2 {
+		t.Errorf("events: want 2, got %d", n)
+	}
+}
+
+func TestEventKeyEpochMustBeInMemberWindow(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+
+	window := func(r testResponse, vaultEpoch, memberEpoch string) {
+		t.Helper()
+		if r.status != http.StatusConflict || r.errorCode() != "stale_key_epoch" {
+			t.Fatalf("want 409 stale_key_epoch, got %s", r)
+		}
+		details, _ := r.json()["details"].(map[string]any)
+		if details["vault_key_epoch"] != vaultEpoch || details["member_key_epoch"] != memberEpoch {
+			t.Errorf("details: want window %s..%s, got %v", vaultEpoch, memberEpoch, details)
+		}
+	}
+
+	window(ts.push(v, owner, 2), "1", "1")
+	ts.must(ts.keyUpdate(v, owner, owner, 2), http.StatusCreated, "key update")
+	window(ts.push(v, owner, 2), "1", "1")
+
+	ts.must(ts.ack(v, owner, 2), http.StatusCreated, "ack")
+	ts.must(ts.push(v, owner, 2), http.StatusCreated, "event at the acked epoch")
+	window(ts.push(v, owner, 1), "2", "2")
+	if n := ts.count("SELECT COUNT(*) FROM events WHERE vault_id = ?", v.id[:]); n != 1 {
+		t.Errorf("events: want 1, got %d", n)
+	}
+}
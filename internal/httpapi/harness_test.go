@@ -0,0 +1,600 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"forgor-server/internal/cbe"
+	"forgor-server/internal/config"
+	"forgor-server/internal/crypto"
+	"forgor-server/internal/db"
+	"forgor-server/internal/logging"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/curve25519"
+)
+
+// testServer is a Server backed by a fresh on-disk database and served
+// over httptest.
+type testServer struct {
+	t   *testing.T
+	srv *Server
+	db  *db.DB
+	cfg *config.Config
+	url string
+}
+
+func newTestServer(t *testing.T, mutate func(*config.Config)) *testServer {
+	t.Helper()
+	logging.Init("error")
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "forgor.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+
+	cfg := &config.Config{
+		RateLimitRequestsPerSecond: 1000,
+		RateLimitBurst:             1000,
+		MaxRequestBodySize:         10 << 20,
+		RequestMaxClockSkew:        300 * time.Second,
+		WriteTimeout:               60 * time.Second,
+		AuthChallengeTTL:           120 * time.Second,
+		SessionTTL:                 time.Hour,
+		DefaultPageSize:            100,
+		MaxPageSize:                500,
+		LongPollMaxWait:            25 * time.Second,
+		StreamMaxDuration:          time.Hour,
+		StreamHeartbeat:            15 * time.Second,
+		StreamBufferSize:           64,
+		VaultPurgeGrace:            7 * 24 * time.Hour,
+		VaultPurgeInterval:         0,
+		InviteSweepInterval:        0,
+		InviteMaxLifetime:          7 * 24 * time.Hour,
+		PairingSlotTTL:             10 * time.Minute,
+		PairingLookupRPS:           0.1,
+		PairingLookupBurst:         5,
+	}
+	if mutate != nil {
+		mutate(cfg)
+	}
+
+	s := NewServer(database, cfg)
+	hs := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		hs.Close()
+		s.Close()
+		database.Close()
+	})
+
+	return &testServer{t: t, srv: s, db: database, cfg: cfg, url: hs.URL}
+}
+
+// testDevice holds the key material of a client device.
+type testDevice struct {
+	id        string
+	idb       []byte
+	pub       ed25519.PublicKey
+	priv      ed25519.PrivateKey
+	box       []byte
+	bundleSig []byte
+}
+
+func newTestDevice(t *testing.T) *testDevice {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	box, err := curve25519.X25519(randomBytes(32), curve25519.Basepoint)
+	if err != nil {
+		t.Fatalf("box key: %v", err)
+	}
+	idb, err := crypto.ComputeDeviceIDBytes(pub)
+	if err != nil {
+		t.Fatalf("device id: %v", err)
+	}
+	bundle, err := cbe.SignBytesDeviceBundle(idb, pub, box)
+	if err != nil {
+		t.Fatalf("bundle sign bytes: %v", err)
+	}
+	return &testDevice{
+		id:        hex.EncodeToString(idb),
+		idb:       idb,
+		pub:       pub,
+		priv:      priv,
+		box:       box,
+		bundleSig: ed25519.Sign(priv, bundle),
+	}
+}
+
+func (d *testDevice) sign(b []byte) []byte { return ed25519.Sign(d.priv, b) }
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func b64(b []byte) string { return base64.StdEncoding.EncodeToString(b) }
+
+func decodeB64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decode %q: %v", s, err)
+	}
+	return b
+}
+
+type testResponse struct {
+	status int
+	body   []byte
+}
+
+func (r testResponse) json() map[string]any {
+	var m map[string]any
+	json.Unmarshal(r.body, &m)
+	return m
+}
+
+func (r testResponse) errorCode() string {
+	code, _ := r.json()["code"].(string)
+	return code
+}
+
+func (r testResponse) String() string {
+	return fmt.Sprintf("%d %s", r.status, bytes.TrimSpace(r.body))
+}
+
+// do sends a request signed by d (or unsigned when d is nil).
+func (ts *testServer) do(method, path string, body any, d *testDevice) testResponse {
+	ts.t.Helper()
+	return ts.send(ts.newRequest(method, path, body, d))
+}
+
+// send issues a request built by newRequest.
+func (ts *testServer) send(req *http.Request) testResponse {
+	ts.t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	rb, _ := io.ReadAll(resp.Body)
+	return testResponse{status: resp.StatusCode, body: rb}
+}
+
+func (ts *testServer) newRequest(method, path string, body any, d *testDevice) *http.Request {
+	ts.t.Helper()
+
+	var rdr io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("marshal body: %v", err)
+		}
+		rdr = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, ts.url+path, rdr)
+	if err != nil {
+		ts.t.Fatalf("new request: %v", err)
+	}
+	if d != nil {
+		u, _ := url.Parse(ts.url + path)
+		timestamp := uint64(time.Now().Unix())
+		nonce := randomBytes(24)
+		sb, err := cbe.SignBytesRequest(d.idb, method, u.Path, u.Query().Encode(), timestamp, nonce)
+		if err != nil {
+			ts.t.Fatalf("request sign bytes: %v", err)
+		}
+		req.Header.Set("X-Forgor-Device-ID", d.id)
+		req.Header.Set("X-Forgor-Timestamp", strconv.FormatUint(timestamp, 10))
+		req.Header.Set("X-Forgor-Nonce", b64(nonce))
+		req.Header.Set("X-Forgor-Signature", b64(d.sign(sb)))
+	}
+	return req
+}
+
+// must fails the test unless r has the wanted status.
+func (ts *testServer) must(r testResponse, status int, what string) testResponse {
+	ts.t.Helper()
+	if r.status != status {
+		ts.t.Fatalf("%s: want %d, got %s", what, status, r)
+	}
+	return r
+}
+
+func (ts *testServer) register(d *testDevice) {
+	ts.t.Helper()
+	ts.must(ts.do("POST", "/v1/devices/register", map[string]any{
+		"device_id":          d.id,
+		"device_pubkey_sign": b64(d.pub),
+		"device_pubkey_box":  b64(d.box),
+		"device_bundle_sig":  b64(d.bundleSig),
+	}, nil), http.StatusCreated, "register device")
+}
+
+// testVault tracks the client-side view of a vault's chains.
+type testVault struct {
+	id       uuid.UUID
+	seq      uint64
+	head     []byte
+	heads    map[string][]byte
+	counters map[string]uint64
+}
+
+func (v *testVault) path(p string) string { return "/v1/vaults/" + v.id.String() + p }
+
+// genesis creates a vault owned by owner.
+func (ts *testServer) genesis(owner *testDevice) *testVault {
+	ts.t.Helper()
+	v, r := ts.tryGenesis(owner)
+	ts.must(r, http.StatusCreated, "genesis")
+	return v
+}
+
+// tryGenesis posts the genesis member_add for a new vault and returns the
+// response without checking it.
+func (ts *testServer) tryGenesis(owner *testDevice) (*testVault, testResponse) {
+	ts.t.Helper()
+	v := &testVault{id: uuid.New(), heads: map[string][]byte{}, counters: map[string]uint64{}}
+	zeroInvite := make([]byte, 16)
+	zeroClaim := make([]byte, 64)
+	r, sb := ts.memberEvent(v, owner, map[string]any{
+		"msg_type":            "member_add",
+		"subject_device_id":   owner.id,
+		"subject_pubkey_sign": b64(owner.pub),
+		"subject_pubkey_box":  b64(owner.box),
+		"subject_bundle_sig":  b64(owner.bundleSig),
+		"invite_id":           uuid.UUID{}.String(),
+		"claim_sig":           b64(zeroClaim),
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesMemberAdd(id, v.id[:], seq, prev, owner.idb, owner.idb, zeroInvite, zeroClaim, owner.bundleSig, owner.pub, owner.box)
+	})
+	if r.status == http.StatusCreated {
+		v.advanceMembership(sb)
+	}
+	return v, r
+}
+
+// memberEvent signs and posts a membership event at the vault's next seq.
+// The caller advances v on success.
+func (ts *testServer) memberEvent(v *testVault, actor *testDevice, fields map[string]any, signBytes func(id []byte, seq uint64, prev []byte) ([]byte, error)) (testResponse, []byte) {
+	ts.t.Helper()
+	id := uuid.New()
+	seq := v.seq + 1
+	prev := v.head
+	if prev == nil {
+		prev = make([]byte, 32)
+	}
+	sb, err := signBytes(id[:], seq, prev)
+	if err != nil {
+		ts.t.Fatalf("member event sign bytes: %v", err)
+	}
+	body := map[string]any{
+		"member_event_id": id.String(),
+		"vault_id":        v.id.String(),
+		"member_seq":      strconv.FormatUint(seq, 10),
+		"prev_hash":       b64(prev),
+		"actor_device_id": actor.id,
+		"signature":       b64(actor.sign(sb)),
+	}
+	for k, val := range fields {
+		body[k] = val
+	}
+	return ts.do("POST", v.path("/member_events"), body, actor), sb
+}
+
+func (v *testVault) advanceMembership(signBytes []byte) {
+	v.seq++
+	v.head = crypto.SHA256Hash(signBytes)
+}
+
+// invite creates a single-use invite from creator to target.
+func (ts *testServer) invite(v *testVault, creator, target *testDevice) uuid.UUID {
+	ts.t.Helper()
+	id, r := ts.tryInvite(v, creator, target)
+	ts.must(r, http.StatusCreated, "create invite")
+	return id
+}
+
+func (ts *testServer) tryInvite(v *testVault, creator, target *testDevice) (uuid.UUID, testResponse) {
+	ts.t.Helper()
+	id := uuid.New()
+	nonce := randomBytes(24)
+	payload := randomBytes(64)
+	sb, err := cbe.SignBytesInvite(id[:], v.id[:], target.idb, target.pub, target.box, target.bundleSig, nonce, payload, creator.idb, true)
+	if err != nil {
+		ts.t.Fatalf("invite sign bytes: %v", err)
+	}
+	r := ts.do("POST", v.path("/invites"), map[string]any{
+		"msg_type":                  "invite",
+		"invite_id":                 id.String(),
+		"vault_id":                  v.id.String(),
+		"target_device_id":          target.id,
+		"target_device_pubkey_sign": b64(target.pub),
+		"target_device_pubkey_box":  b64(target.box),
+		"target_device_bundle_sig":  b64(target.bundleSig),
+		"nonce":                     b64(nonce),
+		"wrapped_payload":           b64(payload),
+		"created_by_device_id":      creator.id,
+		"single_use":                true,
+		"signature":                 b64(creator.sign(sb)),
+	}, creator)
+	return id, r
+}
+
+// claim claims an invite as d and returns the claim signature.
+func (ts *testServer) claim(v *testVault, inviteID uuid.UUID, d *testDevice) []byte {
+	ts.t.Helper()
+	sb, err := cbe.SignBytesInviteClaim(inviteID[:], v.id[:], d.idb)
+	if err != nil {
+		ts.t.Fatalf("claim sign bytes: %v", err)
+	}
+	sig := d.sign(sb)
+	ts.must(ts.do("POST", "/v1/invites/"+inviteID.String()+"/claim", map[string]any{
+		"msg_type":  "invite_claim",
+		"invite_id": inviteID.String(),
+		"vault_id":  v.id.String(),
+		"device_id": d.id,
+		"signature": b64(sig),
+	}, d), http.StatusCreated, "claim invite")
+	return sig
+}
+
+// memberAdd posts the member_add for a claimed invite.
+func (ts *testServer) memberAdd(v *testVault, actor, subject *testDevice, inviteID uuid.UUID, claimSig []byte) testResponse {
+	ts.t.Helper()
+	r, sb := ts.memberEvent(v, actor, map[string]any{
+		"msg_type":            "member_add",
+		"subject_device_id":   subject.id,
+		"subject_pubkey_sign": b64(subject.pub),
+		"subject_pubkey_box":  b64(subject.box),
+		"subject_bundle_sig":  b64(subject.bundleSig),
+		"invite_id":           inviteID.String(),
+		"claim_sig":           b64(claimSig),
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesMemberAdd(id, v.id[:], seq, prev, actor.idb, subject.idb, inviteID[:], claimSig, subject.bundleSig, subject.pub, subject.box)
+	})
+	if r.status == http.StatusCreated {
+		v.advanceMembership(sb)
+	}
+	return r
+}
+
+// addMember runs the invite, claim and member_add flow.
+func (ts *testServer) addMember(v *testVault, actor, subject *testDevice) {
+	ts.t.Helper()
+	inviteID := ts.invite(v, actor, subject)
+	claimSig := ts.claim(v, inviteID, subject)
+	ts.must(ts.memberAdd(v, actor, subject, inviteID, claimSig), http.StatusCreated, "member_add")
+}
+
+// push appends the next event in d's chain.
+func (ts *testServer) push(v *testVault, d *testDevice, epoch uint64) testResponse {
+	ts.t.Helper()
+	counter := v.counters[d.id] + 1
+	body, hash := ts.eventBody(v, d, epoch, counter, v.heads[d.id])
+	r := ts.do("POST", v.path("/events"), body, d)
+	if r.status == http.StatusCreated {
+		v.counters[d.id] = counter
+		v.heads[d.id] = hash
+	}
+	return r
+}
+
+// eventBody builds a signed event at counter on top of prev and returns it
+// with its hash.
+func (ts *testServer) eventBody(v *testVault, d *testDevice, epoch, counter uint64, prev []byte) (map[string]any, []byte) {
+	ts.t.Helper()
+	id := uuid.New()
+	if prev == nil {
+		prev = make([]byte, 32)
+	}
+	nonce := randomBytes(24)
+	ct := randomBytes(100)
+	sb, err := cbe.SignBytesEvent(id[:], v.id[:], d.idb, counter, counter, epoch, prev, nonce, ct)
+	if err != nil {
+		ts.t.Fatalf("event sign bytes: %v", err)
+	}
+	return map[string]any{
+		"msg_type":   "event",
+		"event_id":   id.String(),
+		"vault_id":   v.id.String(),
+		"device_id":  d.id,
+		"counter":    strconv.FormatUint(counter, 10),
+		"lamport":    strconv.FormatUint(counter, 10),
+		"key_epoch":  strconv.FormatUint(epoch, 10),
+		"prev_hash":  b64(prev),
+		"nonce":      b64(nonce),
+		"ciphertext": b64(ct),
+		"signature":  b64(d.sign(sb)),
+	}, crypto.SHA256Hash(sb)
+}
+
+// memberRemove posts a member_remove of subject by actor.
+func (ts *testServer) memberRemove(v *testVault, actor, subject *testDevice) testResponse {
+	ts.t.Helper()
+	r, sb := ts.memberEvent(v, actor, map[string]any{
+		"msg_type":          "member_remove",
+		"subject_device_id": subject.id,
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesMemberRemove(id, v.id[:], seq, prev, actor.idb, subject.idb)
+	})
+	if r.status == http.StatusCreated {
+		v.advanceMembership(sb)
+	}
+	return r
+}
+
+// memberLeave posts a member_leave by d.
+func (ts *testServer) memberLeave(v *testVault, d *testDevice) testResponse {
+	ts.t.Helper()
+	r, sb := ts.memberEvent(v, d, map[string]any{
+		"msg_type":          "member_leave",
+		"subject_device_id": d.id,
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesMemberLeave(id, v.id[:], seq, prev, d.idb)
+	})
+	if r.status == http.StatusCreated {
+		v.advanceMembership(sb)
+	}
+	return r
+}
+
+// roleChange posts a member_promote or member_demote of subject to role.
+func (ts *testServer) roleChange(v *testVault, msgType string, actor, subject *testDevice, role string) testResponse {
+	ts.t.Helper()
+	r, sb := ts.memberEvent(v, actor, map[string]any{
+		"msg_type":          msgType,
+		"subject_device_id": subject.id,
+		"role":              role,
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		if msgType == "member_promote" {
+			return cbe.SignBytesMemberPromote(id, v.id[:], seq, prev, actor.idb, subject.idb, role)
+		}
+		return cbe.SignBytesMemberDemote(id, v.id[:], seq, prev, actor.idb, subject.idb, role)
+	})
+	if r.status == http.StatusCreated {
+		v.advanceMembership(sb)
+	}
+	return r
+}
+
+// ownerTransfer posts an owner_transfer naming subject as the new owner.
+func (ts *testServer) ownerTransfer(v *testVault, actor, subject *testDevice) testResponse {
+	ts.t.Helper()
+	r, sb := ts.memberEvent(v, actor, map[string]any{
+		"msg_type":          "owner_transfer",
+		"subject_device_id": subject.id,
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesOwnerTransfer(id, v.id[:], seq, prev, actor.idb, subject.idb)
+	})
+	if r.status == http.StatusCreated {
+		v.advanceMembership(sb)
+	}
+	return r
+}
+
+// policy posts a vault_policy setting the member quorum.
+func (ts *testServer) policy(v *testVault, owner *testDevice, quorum uint64) testResponse {
+	ts.t.Helper()
+	r, sb := ts.memberEvent(v, owner, map[string]any{
+		"msg_type":          "vault_policy",
+		"subject_device_id": owner.id,
+		"quorum":            strconv.FormatUint(quorum, 10),
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesVaultPolicy(id, v.id[:], seq, prev, owner.idb, quorum)
+	})
+	if r.status == http.StatusCreated {
+		v.advanceMembership(sb)
+	}
+	return r
+}
+
+// vaultDelete posts the owner's vault_delete at the current membership head.
+func (ts *testServer) vaultDelete(v *testVault, owner *testDevice) testResponse {
+	ts.t.Helper()
+	sb, err := cbe.SignBytesVaultDelete(v.id[:], owner.idb, v.seq, v.head)
+	if err != nil {
+		ts.t.Fatalf("vault delete sign bytes: %v", err)
+	}
+	return ts.do("POST", v.path("/delete"), map[string]any{
+		"msg_type":         "vault_delete",
+		"vault_id":         v.id.String(),
+		"device_id":        owner.id,
+		"member_seq":       strconv.FormatUint(v.seq, 10),
+		"member_head_hash": b64(v.head),
+		"signature":        b64(owner.sign(sb)),
+	}, owner)
+}
+
+// keyUpdate posts a key update for target at epoch, bound to the current
+// membership head.
+func (ts *testServer) keyUpdate(v *testVault, creator, target *testDevice, epoch uint64) testResponse {
+	ts.t.Helper()
+	return ts.do("POST", v.path("/key_updates"), ts.keyUpdateBody(v, creator, target, epoch), creator)
+}
+
+func (ts *testServer) keyUpdateBody(v *testVault, creator, target *testDevice, epoch uint64) map[string]any {
+	ts.t.Helper()
+	id := uuid.New()
+	nonce := randomBytes(24)
+	payload := randomBytes(64)
+	sb, err := cbe.SignBytesKeyUpdate(id[:], v.id[:], v.seq, v.head, target.idb, epoch, nonce, payload, creator.idb)
+	if err != nil {
+		ts.t.Fatalf("key update sign bytes: %v", err)
+	}
+	return map[string]any{
+		"msg_type":             "key_update",
+		"key_update_id":        id.String(),
+		"vault_id":             v.id.String(),
+		"member_seq":           strconv.FormatUint(v.seq, 10),
+		"member_head_hash":     b64(v.head),
+		"target_device_id":     target.id,
+		"key_epoch":            strconv.FormatUint(epoch, 10),
+		"nonce":                b64(nonce),
+		"wrapped_payload":      b64(payload),
+		"created_by_device_id": creator.id,
+		"signature":            b64(creator.sign(sb)),
+	}
+}
+
+// ack posts d's key_update_ack for epoch at the current membership head.
+func (ts *testServer) ack(v *testVault, d *testDevice, epoch uint64) testResponse {
+	ts.t.Helper()
+	sb, err := cbe.SignBytesKeyUpdateAck(v.id[:], d.idb, epoch, v.seq, v.head)
+	if err != nil {
+		ts.t.Fatalf("ack sign bytes: %v", err)
+	}
+	return ts.do("POST", v.path("/key_update_acks"), map[string]any{
+		"msg_type":         "key_update_ack",
+		"vault_id":         v.id.String(),
+		"device_id":        d.id,
+		"key_epoch":        strconv.FormatUint(epoch, 10),
+		"member_seq":       strconv.FormatUint(v.seq, 10),
+		"member_head_hash": b64(v.head),
+		"signature":        b64(d.sign(sb)),
+	}, d)
+}
+
+// count returns the result of a single-value COUNT query.
+func (ts *testServer) count(query string, args ...any) int {
+	ts.t.Helper()
+	var n int
+	if err := ts.db.QueryRow(query, args...).Scan(&n); err != nil {
+		ts.t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+// failWrites installs a trigger that aborts every op ("INSERT", "UPDATE"
+// or "DELETE") on table, and returns a func that removes it.
+func (ts *testServer) failWrites(table, op string) func() {
+	ts.t.Helper()
+	name := "test_fail_" + table
+	stmt := fmt.Sprintf("CREATE TRIGGER %s BEFORE %s ON %s BEGIN SELECT RAISE(ABORT, 'injected failure'); END", name, op, table)
+	if _, err := ts.db.Exec(stmt); err != nil {
+		ts.t.Fatalf("install trigger: %v", err)
+	}
+	return func() {
+		if _, err := ts.db.Exec("DROP TRIGGER " + name); err != nil {
+			ts.t.Fatalf("drop trigger: %v", err)
+		}
+	}
+}
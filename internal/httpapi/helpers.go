@@ -2,10 +2,12 @@ package httpapi
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"forgor-server/internal/apierror"
+	"forgor-server/internal/logging"
 
 	"github.com/google/uuid"
 )
@@ -26,6 +28,18 @@ func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	json.NewEncoder(w).Encode(v)
 }
 
+// writeError writes err as returned from a transaction: API errors are sent
+// as-is, anything else is logged and reported as an internal error.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		apiErr.WriteJSON(w)
+		return
+	}
+	logging.FromContext(r.Context()).Error("request failed", "error", err)
+	apierror.InternalError().WriteJSON(w)
+}
+
 func parseUUID(s string) (uuid.UUID, error) {
 	return uuid.Parse(s)
 }
@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+// StreamMessage is one committed change fanned out to a vault's subscribers.
+type StreamMessage struct {
+	Event string
+	// Seq and MemberSeq position the message in the events feed and the
+	// membership log; zero when the message belongs to neither.
+	Seq       uint64
+	MemberSeq uint64
+	// TargetDeviceID restricts delivery to one device (key updates).
+	TargetDeviceID string
+	Data           interface{}
+}
+
+// Subscriber receives a vault's messages on C. If C fills up because the
+// client is not reading fast enough, the subscriber is dropped and Done is
+// closed; the hub never blocks a writer on a slow consumer.
+type Subscriber struct {
+	C chan StreamMessage
+
+	deviceID string
+	done     chan struct{}
+}
+
+func (sub *Subscriber) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Hub wakes long-polling readers and feeds stream subscribers when a vault
+// changes. Waiters block on a per-vault channel that is closed (and replaced)
+// on every publish, so any number of them can wait without the publisher
+// tracking them.
+type Hub struct {
+	mu          sync.Mutex
+	vaults      map[string]chan struct{}
+	subscribers map[string]map[*Subscriber]struct{}
+	bufferSize  int
+	closed      bool
+	done        chan struct{}
+}
+
+func NewHub(bufferSize int) *Hub {
+	return &Hub{
+		vaults:      make(map[string]chan struct{}),
+		subscribers: make(map[string]map[*Subscriber]struct{}),
+		bufferSize:  bufferSize,
+		done:        make(chan struct{}),
+	}
+}
+
+// Wait returns a channel that is closed on the next publish for the vault or
+// when the hub shuts down. Callers must take it before reading the feed so a
+// publish between the read and the wait is not lost.
+func (h *Hub) Wait(vaultID []byte) <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return h.done
+	}
+
+	key := hex.EncodeToString(vaultID)
+	ch, exists := h.vaults[key]
+	if !exists {
+		ch = make(chan struct{})
+		h.vaults[key] = ch
+	}
+	return ch
+}
+
+// Subscribe registers a stream for the vault on behalf of deviceID. Like
+// Wait, subscribe before replaying history so nothing falls in between.
+func (h *Hub) Subscribe(vaultID []byte, deviceID string) *Subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscriber{
+		C:        make(chan StreamMessage, h.bufferSize),
+		deviceID: deviceID,
+		done:     make(chan struct{}),
+	}
+	if h.closed {
+		close(sub.done)
+		return sub
+	}
+
+	key := hex.EncodeToString(vaultID)
+	subs, exists := h.subscribers[key]
+	if !exists {
+		subs = make(map[*Subscriber]struct{})
+		h.subscribers[key] = subs
+	}
+	subs[sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) Unsubscribe(vaultID []byte, sub *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.drop(hex.EncodeToString(vaultID), sub)
+}
+
+// Publish wakes everyone waiting on the vault and hands msg to its
+// subscribers. Call it after the write has committed.
+func (h *Hub) Publish(vaultID []byte, msg StreamMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := hex.EncodeToString(vaultID)
+	if ch, exists := h.vaults[key]; exists {
+		close(ch)
+		delete(h.vaults, key)
+	}
+
+	for sub := range h.subscribers[key] {
+		if msg.TargetDeviceID != "" && msg.TargetDeviceID != sub.deviceID {
+			continue
+		}
+		select {
+		case sub.C <- msg:
+		default:
+			h.drop(key, sub)
+		}
+	}
+}
+
+// Closed reports whether the hub has shut down.
+func (h *Hub) Closed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+// Close releases all current and future waiters and subscribers.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	close(h.done)
+	for key, ch := range h.vaults {
+		close(ch)
+		delete(h.vaults, key)
+	}
+	for key, subs := range h.subscribers {
+		for sub := range subs {
+			h.drop(key, sub)
+		}
+	}
+}
+
+func (h *Hub) drop(key string, sub *Subscriber) {
+	subs, exists := h.subscribers[key]
+	if !exists {
+		return
+	}
+	if _, exists := subs[sub]; !exists {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subscribers, key)
+	}
+	close(sub.done)
+}
@@ -2,10 +2,15 @@ package httpapi
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
 )
 
 func (s *Server) handleInviteCreate(w http.ResponseWriter, r *http.Request) {
@@ -26,30 +31,46 @@ func (s *Server) handleInviteCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
-
-	row, apiErr := s.invitesValidator.ValidateInvite(ctx, &invite)
-	if apiErr != nil {
+	if apiErr := requireDevice(r, string(invite.CreatedByDeviceID)); apiErr != nil {
 		apiErr.WriteJSON(w)
 		return
 	}
 
-	if err := s.invites.RecordNonceUsed(ctx, "invite", vaultID, string(invite.CreatedByDeviceID), invite.Nonce); err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
-	}
+	ctx := r.Context()
+
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		row, apiErr := s.invitesValidator.WithTx(tx).ValidateInvite(ctx, &invite)
+		if apiErr != nil {
+			return apiErr
+		}
 
-	if err := s.invites.Create(ctx, row); err != nil {
-		apierror.InternalError().WriteJSON(w)
+		invites := s.invites.WithTx(tx)
+		if err := invites.RecordNonceUsed(ctx, "invite", vaultID, string(invite.CreatedByDeviceID), invite.Nonce); err != nil {
+			return err
+		}
+		return invites.Create(ctx, row)
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	writeJSON(w, http.StatusCreated, invite)
 }
 
+// handleInvitesList lists the invites addressed to device_id or, with
+// created_by_device_id, the invites that device created, revoked and expired
+// ones included.
 func (s *Server) handleInvitesList(w http.ResponseWriter, r *http.Request) {
 	deviceID := getQueryParam(r, "device_id")
-	if deviceID == "" {
+	createdBy := getQueryParam(r, "created_by_device_id")
+	switch {
+	case deviceID != "" && createdBy != "":
+		apierror.BadRequest("conflicting_device_id", "pass either device_id or created_by_device_id, not both").WriteJSON(w)
+		return
+	case createdBy != "":
+		deviceID = createdBy
+	case deviceID == "":
 		apierror.BadRequest("missing_device_id", "device_id query parameter is required").WriteJSON(w)
 		return
 	}
@@ -60,32 +81,59 @@ func (s *Server) handleInvitesList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	invites, err := s.invites.ListByTargetDevice(r.Context(), deviceID)
+	if apiErr := requireDevice(r, deviceID); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	page, apiErr := s.parsePage(r, "invites")
+	if apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	pw := newPageWriter(w, "invites", page)
+	write := func(inv *storage.InviteRow) error {
+		return pw.write(inviteFromRow(inv), storage.Cursor{CreatedAt: inv.CreatedAt, ID: inv.InviteID})
+	}
+	var err error
+	if createdBy != "" {
+		err = s.invites.ListByCreator(r.Context(), deviceID, page, write)
+	} else {
+		err = s.invites.ListByTargetDevice(r.Context(), deviceID, s.config.InviteMaxLifetime, page, write)
+	}
 	if err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
-	}
-
-	response := make([]models.Invite, 0, len(invites))
-	for _, inv := range invites {
-		response = append(response, models.Invite{
-			MsgType:               "invite",
-			InviteID:              bytesToUUID(inv.InviteID),
-			VaultID:               bytesToUUID(inv.VaultID),
-			TargetDeviceID:        models.DeviceID(inv.TargetDeviceID),
-			TargetDevicePubkeySign: inv.TargetDevicePubkeySign,
-			TargetDevicePubkeyBox:  inv.TargetDevicePubkeyBox,
-			TargetDeviceBundleSig:  inv.TargetDeviceBundleSig,
-			Nonce:                 inv.Nonce,
-			WrappedPayload:        inv.WrappedPayload,
-			CreatedByDeviceID:     models.DeviceID(inv.CreatedByDeviceID),
-			SingleUse:             inv.SingleUse,
-			Signature:             inv.Signature,
-			CreatedAt:             inv.CreatedAt,
-		})
-	}
-
-	writeJSON(w, http.StatusOK, response)
+		pw.fail(r, err)
+		return
+	}
+	pw.finish()
+}
+
+// handleInviteGet returns a single invite to its creator or to a device it
+// admits. This is how a device opening a link to an open multi-use invite
+// fetches it, since such invites appear in nobody's listing.
+func (s *Server) handleInviteGet(w http.ResponseWriter, r *http.Request) {
+	inviteUUID, err := parseUUID(getPathParam(r, "invite_id"))
+	if err != nil {
+		apierror.InvalidUUID("invite_id").WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	inv, err := s.invites.Get(ctx, inviteUUID[:])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	deviceID := authenticatedDeviceID(ctx)
+	if inv == nil || (inv.CreatedByDeviceID != deviceID && !inv.Admits(deviceID)) {
+		apierror.NotFound("invite").WriteJSON(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, inviteFromRow(inv))
 }
 
 func (s *Server) handleInviteClaim(w http.ResponseWriter, r *http.Request) {
@@ -107,20 +155,78 @@ func (s *Server) handleInviteClaim(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if apiErr := requireDevice(r, string(claim.DeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
 	ctx := r.Context()
 
-	row, apiErr := s.invitesValidator.ValidateInviteClaim(ctx, &claim)
-	if apiErr != nil {
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		row, apiErr := s.invitesValidator.WithTx(tx).ValidateInviteClaim(ctx, &claim)
+		if apiErr != nil {
+			return apiErr
+		}
+		return s.invites.WithTx(tx).CreateClaim(ctx, row)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, claim)
+}
+
+// handleInviteRevoke cancels an invite on its creator's signed request. Any
+// later claim or member_add referencing it is rejected.
+func (s *Server) handleInviteRevoke(w http.ResponseWriter, r *http.Request) {
+	inviteUUID, err := parseUUID(getPathParam(r, "invite_id"))
+	if err != nil {
+		apierror.InvalidUUID("invite_id").WriteJSON(w)
+		return
+	}
+
+	var revoke models.InviteRevoke
+	if apiErr := parseJSON(r, &revoke); apiErr != nil {
 		apiErr.WriteJSON(w)
 		return
 	}
 
-	if err := s.invites.CreateClaim(ctx, row); err != nil {
-		apierror.InternalError().WriteJSON(w)
+	if !bytes.Equal(inviteUUID[:], revoke.InviteID.Bytes()) {
+		apierror.BadRequest("invite_id_mismatch", "invite_id in path does not match body").WriteJSON(w)
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, claim)
+	if apiErr := requireDevice(r, string(revoke.DeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	revokedAt := time.Now().UTC().Format(time.RFC3339)
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		invite, apiErr := s.invitesValidator.WithTx(tx).ValidateInviteRevoke(ctx, &revoke)
+		if apiErr != nil {
+			return apiErr
+		}
+
+		revoked, err := s.invites.WithTx(tx).Revoke(ctx, invite.InviteID, revoke.Signature, revokedAt)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return apierror.Conflict("invite has already been revoked")
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	revoke.RevokedAt = revokedAt
+	writeJSON(w, http.StatusOK, revoke)
 }
 
 func (s *Server) handleInviteClaimsList(w http.ResponseWriter, r *http.Request) {
@@ -136,25 +242,145 @@ func (s *Server) handleInviteClaimsList(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	claims, err := s.invites.ListClaimsByCreator(r.Context(), deviceID)
-	if err != nil {
-		apierror.InternalError().WriteJSON(w)
+	if apiErr := requireDevice(r, deviceID); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	page, apiErr := s.parsePage(r, "invite_claims")
+	if apiErr != nil {
+		apiErr.WriteJSON(w)
 		return
 	}
 
-	response := make([]models.InviteClaim, 0, len(claims))
-	for _, c := range claims {
-		response = append(response, models.InviteClaim{
+	pw := newPageWriter(w, "invite_claims", page)
+	err := s.invites.ListClaimsByCreator(r.Context(), deviceID, page, func(c *storage.InviteClaimRow) error {
+		item := models.InviteClaim{
 			MsgType:   "invite_claim",
 			InviteID:  bytesToUUID(c.InviteID),
 			VaultID:   bytesToUUID(c.VaultID),
 			DeviceID:  models.DeviceID(c.DeviceID),
 			Signature: c.ClaimSig,
 			CreatedAt: c.CreatedAt,
-		})
+		}
+		return pw.write(item, storage.Cursor{CreatedAt: c.CreatedAt, ID: c.InviteID, DeviceID: c.DeviceID})
+	})
+	if err != nil {
+		pw.fail(r, err)
+		return
 	}
+	pw.finish()
+}
 
-	writeJSON(w, http.StatusOK, response)
+// handleVaultInvitesList shows the vault's admins every invite it has, with
+// its status and claimants.
+func (s *Server) handleVaultInvitesList(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	if apiErr := s.invitesValidator.ValidateVaultInvitesAccess(ctx, vaultID, authenticatedDeviceID(ctx)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	page, apiErr := s.parsePage(r, "vault_invites")
+	if apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	now := time.Now()
+	pw := newPageWriter(w, "vault_invites", page)
+	err = s.invites.ListByVault(ctx, vaultID, page, func(inv *storage.InviteRow, claims []*storage.InviteClaimRow) error {
+		item := models.VaultInvite{
+			Invite:    inviteFromRow(inv),
+			Status:    inviteStatus(inv, len(claims), now, s.config.InviteMaxLifetime),
+			Exhausted: inv.Exhausted(),
+			Claims:    make([]models.InviteClaimant, 0, len(claims)),
+		}
+		for _, c := range claims {
+			item.Claims = append(item.Claims, models.InviteClaimant{
+				DeviceID:  models.DeviceID(c.DeviceID),
+				ClaimedAt: c.CreatedAt,
+			})
+		}
+		return pw.write(item, storage.Cursor{CreatedAt: inv.CreatedAt, ID: inv.InviteID})
+	})
+	if err != nil {
+		pw.fail(r, err)
+		return
+	}
+	pw.finish()
+}
+
+// inviteStatus reports the first of revoked, used, expired, active, claimed
+// and pending that applies to inv. An invite is used once it has no uses
+// left, and active while it has admitted a device but can admit more.
+func inviteStatus(inv *storage.InviteRow, claims int, now time.Time, maxLifetime time.Duration) string {
+	switch {
+	case inv.RevokedAt != "":
+		return models.InviteStatusRevoked
+	case inv.Exhausted():
+		return models.InviteStatusUsed
+	case inv.Expired(now, maxLifetime):
+		return models.InviteStatusExpired
+	case inv.UseCount > 0:
+		return models.InviteStatusActive
+	case claims > 0:
+		return models.InviteStatusClaimed
+	}
+	return models.InviteStatusPending
+}
+
+// sweepExpiredInvites clears the wrapped payloads of invites whose lifetime
+// has run out, so they do not linger, and drops expired pairing slots.
+func (s *Server) sweepExpiredInvites(ctx context.Context) {
+	now := time.Now()
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := s.invites.WithTx(tx).ClearExpiredPayloads(ctx, now, s.config.InviteMaxLifetime); err != nil {
+			return err
+		}
+		return s.pairingSlots.WithTx(tx).PruneExpired(ctx, now)
+	})
+	if err != nil {
+		slog.Error("failed to sweep expired invites", "error", err)
+	}
+}
+
+func inviteFromRow(inv *storage.InviteRow) models.Invite {
+	item := models.Invite{
+		MsgType:                "invite",
+		InviteID:               bytesToUUID(inv.InviteID),
+		VaultID:                bytesToUUID(inv.VaultID),
+		TargetDeviceID:         models.DeviceID(inv.TargetDeviceID),
+		TargetDevicePubkeySign: inv.TargetDevicePubkeySign,
+		TargetDevicePubkeyBox:  inv.TargetDevicePubkeyBox,
+		TargetDeviceBundleSig:  inv.TargetDeviceBundleSig,
+		Nonce:                  inv.Nonce,
+		WrappedPayload:         inv.WrappedPayload,
+		CreatedByDeviceID:      models.DeviceID(inv.CreatedByDeviceID),
+		SingleUse:              inv.SingleUse,
+		ExpiresAt:              inv.ExpiresAt,
+		Signature:              inv.Signature,
+		CreatedAt:              inv.CreatedAt,
+		RevokedAt:              inv.RevokedAt,
+		MaxUses:                models.Uint64String(inv.MaxUses),
+		UseCount:               models.Uint64String(inv.UseCount),
+		LastUsedAt:             inv.LastUsedAt,
+	}
+	if inv.IsMulti() {
+		item.MsgType = "invite_multi"
+		item.TargetDeviceIDs = make([]models.DeviceID, 0, len(inv.Targets))
+		for _, target := range inv.Targets {
+			item.TargetDeviceIDs = append(item.TargetDeviceIDs, models.DeviceID(target))
+		}
+	}
+	return item
 }
 
 func bytesToUUID(b []byte) models.UUID {
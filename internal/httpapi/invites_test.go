@@ -0,0 +1,241 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forgor-server/internal/cbe"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+func TestUsedInviteIsNotListedForTarget(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, joiner := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(joiner)
+	v := ts.genesis(owner)
+
+	inviteID := ts.invite(v, owner, joiner)
+	listed := func() int {
+		t.Helper()
+		r := ts.must(ts.do("GET", "/v1/invites?device_id="+joiner.id, nil, joiner), http.StatusOK, "list invites")
+		items, _ := r.json()["items"].([]any)
+		return len(items)
+	}
+	if n := listed(); n != 1 {
+		t.Fatalf("before use: want 1 invite listed, got %d", n)
+	}
+
+	claimSig := ts.claim(v, inviteID, joiner)
+	ts.must(ts.memberAdd(v, owner, joiner, inviteID, claimSig), http.StatusCreated, "member_add")
+	if n := listed(); n != 0 {
+		t.Errorf("after use: want no invites listed, got %d", n)
+	}
+}
+
+// TestInviteWithoutExpiryLapses checks that an invite without expires_at
+// expires InviteMaxLifetime after its creation, and is swept like any other.
+func TestInviteWithoutExpiryLapses(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, joiner := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(joiner)
+	v := ts.genesis(owner)
+
+	inviteID := ts.invite(v, owner, joiner)
+	claimSig := ts.claim(v, inviteID, joiner)
+	createdAt := time.Now().Add(-ts.cfg.InviteMaxLifetime - time.Minute).UTC().Format(time.RFC3339)
+	if _, err := ts.db.Exec("UPDATE invites SET created_at = ? WHERE invite_id = ?", createdAt, inviteID[:]); err != nil {
+		t.Fatalf("backdate invite: %v", err)
+	}
+
+	r := ts.must(ts.do("GET", "/v1/invites?device_id="+joiner.id, nil, joiner), http.StatusOK, "list invites")
+	if items, _ := r.json()["items"].([]any); len(items) != 0 {
+		t.Errorf("lapsed invite: want not listed, got %d", len(items))
+	}
+	if r := ts.memberAdd(v, owner, joiner, inviteID, claimSig); r.status != http.StatusGone || r.errorCode() != "invite_expired" {
+		t.Errorf("member_add from a lapsed invite: want 410 invite_expired, got %s", r)
+	}
+
+	ts.srv.sweepExpiredInvites(context.Background())
+	if n := ts.count("SELECT length(wrapped_payload) FROM invites WHERE invite_id = ?", inviteID[:]); n != 0 {
+		t.Errorf("swept invite: want empty payload, got %d bytes", n)
+	}
+	r = ts.must(ts.do("GET", v.path("/invites"), nil, owner), http.StatusOK, "list vault invites")
+	items, _ := r.json()["items"].([]any)
+	if len(items) != 1 || items[0].(map[string]any)["status"] != models.InviteStatusExpired {
+		t.Errorf("vault invites: want the invite listed as expired, got %v", items)
+	}
+}
+
+func TestPartlyUsedMultiInviteIsActive(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, first, second := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, first, second} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+
+	inviteID := uuid.New()
+	nonce, payload := randomBytes(24), randomBytes(64)
+	sb, err := cbe.SignBytesInviteMulti(inviteID[:], v.id[:], nil, nonce, payload, owner.idb, 2, 0)
+	if err != nil {
+		t.Fatalf("invite_multi sign bytes: %v", err)
+	}
+	ts.must(ts.do("POST", v.path("/invites"), map[string]any{
+		"msg_type":             "invite_multi",
+		"invite_id":            inviteID.String(),
+		"vault_id":             v.id.String(),
+		"nonce":                b64(nonce),
+		"wrapped_payload":      b64(payload),
+		"created_by_device_id": owner.id,
+		"max_uses":             "2",
+		"signature":            b64(owner.sign(sb)),
+	}, owner), http.StatusCreated, "create invite_multi")
+
+	listed := func() map[string]any {
+		t.Helper()
+		r := ts.must(ts.do("GET", v.path("/invites"), nil, owner), http.StatusOK, "list vault invites")
+		items, _ := r.json()["items"].([]any)
+		for _, it := range items {
+			if it := it.(map[string]any); it["invite_id"] == inviteID.String() {
+				return it
+			}
+		}
+		t.Fatalf("vault invites: %s missing from %v", inviteID, items)
+		return nil
+	}
+
+	ts.must(ts.memberAdd(v, owner, first, inviteID, ts.claim(v, inviteID, first)), http.StatusCreated, "first member_add")
+	if it := listed(); it["status"] != models.InviteStatusActive || it["exhausted"] != false || it["use_count"] != "1" {
+		t.Errorf("after one of two uses: want active and not exhausted, got %v", it)
+	}
+
+	ts.must(ts.memberAdd(v, owner, second, inviteID, ts.claim(v, inviteID, second)), http.StatusCreated, "second member_add")
+	if it := listed(); it["status"] != models.InviteStatusUsed || it["exhausted"] != true {
+		t.Errorf("after both uses: want used and exhausted, got %v", it)
+	}
+}
+
+func TestInviteStatus(t *testing.T) {
+	const maxLifetime = 7 * 24 * time.Hour
+	now := time.Now().UTC()
+	past := now.Add(-time.Hour).Format(time.RFC3339)
+	future := now.Add(time.Hour).Format(time.RFC3339)
+	stale := now.Add(-maxLifetime - time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name      string
+		inv       storage.InviteRow
+		claims    int
+		status    string
+		exhausted bool
+	}{
+		{"pending", storage.InviteRow{MaxUses: 1, CreatedAt: past}, 0, models.InviteStatusPending, false},
+		{"pending until expiry", storage.InviteRow{MaxUses: 1, ExpiresAt: future, CreatedAt: past}, 0, models.InviteStatusPending, false},
+		{"claimed", storage.InviteRow{MaxUses: 1, CreatedAt: past}, 1, models.InviteStatusClaimed, false},
+		{"expired", storage.InviteRow{MaxUses: 1, ExpiresAt: past, CreatedAt: past}, 0, models.InviteStatusExpired, false},
+		{"expired after claim", storage.InviteRow{MaxUses: 1, ExpiresAt: past, CreatedAt: past}, 1, models.InviteStatusExpired, false},
+		{"expired past max lifetime", storage.InviteRow{MaxUses: 1, CreatedAt: stale}, 0, models.InviteStatusExpired, false},
+		{"used single-use", storage.InviteRow{MaxUses: 1, UseCount: 1, CreatedAt: past}, 1, models.InviteStatusUsed, true},
+		{"used multi-use", storage.InviteRow{MaxUses: 3, UseCount: 3, CreatedAt: past}, 3, models.InviteStatusUsed, true},
+		{"used then expired", storage.InviteRow{MaxUses: 3, UseCount: 3, ExpiresAt: past, CreatedAt: past}, 3, models.InviteStatusUsed, true},
+		{"active with uses left", storage.InviteRow{MaxUses: 3, UseCount: 1, CreatedAt: past}, 2, models.InviteStatusActive, false},
+		{"active unlimited", storage.InviteRow{UseCount: 5, CreatedAt: past}, 5, models.InviteStatusActive, false},
+		{"expired with uses left", storage.InviteRow{MaxUses: 3, UseCount: 1, ExpiresAt: past, CreatedAt: past}, 1, models.InviteStatusExpired, false},
+		{"revoked", storage.InviteRow{MaxUses: 1, RevokedAt: past, CreatedAt: past}, 0, models.InviteStatusRevoked, false},
+		{"revoked after use", storage.InviteRow{MaxUses: 3, UseCount: 3, RevokedAt: past, CreatedAt: past}, 3, models.InviteStatusRevoked, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inviteStatus(&tt.inv, tt.claims, now, maxLifetime); got != tt.status {
+				t.Errorf("status: want %s, got %s", tt.status, got)
+			}
+			if got := tt.inv.Exhausted(); got != tt.exhausted {
+				t.Errorf("exhausted: want %v, got %v", tt.exhausted, got)
+			}
+		})
+	}
+}
+
+func TestInviteRevocationByCreator(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, admin, joiner, late := newTestDevice(t), newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, admin, joiner, late} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, admin)
+	ts.must(ts.roleChange(v, "member_promote", owner, admin, "admin"), http.StatusCreated, "promote")
+
+	revoke := func(inviteID uuid.UUID, d *testDevice) testResponse {
+		t.Helper()
+		sb, err := cbe.SignBytesInviteRevoke(inviteID[:], v.id[:], d.idb)
+		if err != nil {
+			t.Fatalf("revoke sign bytes: %v", err)
+		}
+		return ts.do("POST", "/v1/invites/"+inviteID.String()+"/revoke", map[string]any{
+			"msg_type":  "invite_revoke",
+			"invite_id": inviteID.String(),
+			"vault_id":  v.id.String(),
+			"device_id": d.id,
+			"signature": b64(d.sign(sb)),
+		}, d)
+	}
+	claim := func(inviteID uuid.UUID, d *testDevice) testResponse {
+		t.Helper()
+		sb, err := cbe.SignBytesInviteClaim(inviteID[:], v.id[:], d.idb)
+		if err != nil {
+			t.Fatalf("claim sign bytes: %v", err)
+		}
+		return ts.do("POST", "/v1/invites/"+inviteID.String()+"/claim", map[string]any{
+			"msg_type":  "invite_claim",
+			"invite_id": inviteID.String(),
+			"vault_id":  v.id.String(),
+			"device_id": d.id,
+			"signature": b64(d.sign(sb)),
+		}, d)
+	}
+
+	claimed := ts.invite(v, owner, joiner)
+	claimSig := ts.claim(v, claimed, joiner)
+	unclaimed := ts.invite(v, owner, late)
+
+	if r := revoke(claimed, admin); r.status != http.StatusForbidden {
+		t.Fatalf("revoke by an admin who did not create the invite: want 403, got %s", r)
+	}
+	ts.must(revoke(claimed, owner), http.StatusOK, "revoke claimed invite")
+	ts.must(revoke(unclaimed, owner), http.StatusOK, "revoke unclaimed invite")
+	if r := revoke(claimed, owner); r.status != http.StatusConflict {
+		t.Errorf("second revoke: want 409, got %s", r)
+	}
+
+	if r := ts.memberAdd(v, owner, joiner, claimed, claimSig); r.status != http.StatusGone || r.errorCode() != "invite_revoked" {
+		t.Errorf("member_add from a revoked invite: want 410 invite_revoked, got %s", r)
+	}
+	if r := claim(unclaimed, late); r.status != http.StatusGone || r.errorCode() != "invite_revoked" {
+		t.Errorf("claim of a revoked invite: want 410 invite_revoked, got %s", r)
+	}
+
+	r := ts.must(ts.do("GET", v.path("/invites"), nil, owner), http.StatusOK, "list vault invites")
+	items, _ := r.json()["items"].([]any)
+	revoked := 0
+	for _, it := range items {
+		it := it.(map[string]any)
+		if it["invite_id"] != claimed.String() && it["invite_id"] != unclaimed.String() {
+			continue
+		}
+		revoked++
+		if it["status"] != models.InviteStatusRevoked || it["revoked_at"] == nil {
+			t.Errorf("invite %v: want revoked with revoked_at, got %v", it["invite_id"], it)
+		}
+	}
+	if revoked != 2 {
+		t.Errorf("vault invites: want both revoked invites listed, got %d", revoked)
+	}
+}
@@ -2,10 +2,14 @@ package httpapi
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
 )
 
 func (s *Server) handleKeyUpdateCreate(w http.ResponseWriter, r *http.Request) {
@@ -26,25 +30,181 @@ func (s *Server) handleKeyUpdateCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if apiErr := requireDevice(r, string(ku.CreatedByDeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
 	ctx := r.Context()
 
-	row, apiErr := s.keyUpdatesValidator.ValidateKeyUpdate(ctx, &ku)
-	if apiErr != nil {
+	unlock := s.writeLocks.Lock(vaultLockKey(vaultID))
+	defer unlock()
+
+	var created *storage.KeyUpdateRow
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		row, apiErr := s.keyUpdatesValidator.WithTx(tx).ValidateKeyUpdate(ctx, &ku)
+		if apiErr != nil {
+			return apiErr
+		}
+
+		if err := s.invites.WithTx(tx).RecordNonceUsed(ctx, "key_update", vaultID, string(ku.CreatedByDeviceID), ku.Nonce); err != nil {
+			return err
+		}
+		created = row
+		if err := s.keyUpdates.WithTx(tx).Create(ctx, row); err != nil {
+			return err
+		}
+		return s.completeRotation(ctx, tx, vaultID, row.KeyEpoch)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.hub.Publish(vaultID, StreamMessage{
+		Event:          "key_update",
+		TargetDeviceID: created.TargetDeviceID,
+		Data:           keyUpdateFromRow(created),
+	})
+
+	writeJSON(w, http.StatusCreated, ku)
+}
+
+// handleKeyUpdateBatchCreate distributes one epoch's key to every current
+// member in a single transaction, so an epoch is never left half-distributed.
+func (s *Server) handleKeyUpdateBatchCreate(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	var batch []models.KeyUpdate
+	if apiErr := parseJSON(r, &batch); apiErr != nil {
 		apiErr.WriteJSON(w)
 		return
 	}
 
-	if err := s.invites.RecordNonceUsed(ctx, "key_update", vaultID, string(ku.CreatedByDeviceID), ku.Nonce); err != nil {
-		apierror.InternalError().WriteJSON(w)
+	if len(batch) == 0 {
+		apierror.BadRequest("empty_batch", "batch must contain at least one key update").WriteJSON(w)
+		return
+	}
+	if len(batch) > models.MaxKeyUpdateBatch {
+		apierror.BadRequest("batch_too_large", fmt.Sprintf("batch may contain at most %d key updates", models.MaxKeyUpdateBatch)).WriteJSON(w)
 		return
 	}
 
-	if err := s.keyUpdates.Create(ctx, row); err != nil {
-		apierror.InternalError().WriteJSON(w)
+	keyEpoch := batch[0].KeyEpoch
+
+	var itemErrs []apierror.BatchItemError
+	for i := range batch {
+		if !bytes.Equal(vaultID, batch[i].VaultID.Bytes()) {
+			itemErrs = append(itemErrs, apierror.BatchItemError{Index: i, APIError: apierror.BadRequest("vault_id_mismatch", "vault_id in path does not match body")})
+			continue
+		}
+		if batch[i].KeyEpoch != keyEpoch {
+			itemErrs = append(itemErrs, apierror.BatchItemError{Index: i, APIError: apierror.BadRequest("key_epoch_mismatch", "all key updates in a batch must use the same key_epoch")})
+			continue
+		}
+		if apiErr := requireDevice(r, string(batch[i].CreatedByDeviceID)); apiErr != nil {
+			itemErrs = append(itemErrs, apierror.BatchItemError{Index: i, APIError: apiErr})
+		}
+	}
+	if len(itemErrs) > 0 {
+		apierror.BatchRejected(itemErrs).WriteJSON(w)
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, ku)
+	ctx := r.Context()
+
+	unlock := s.writeLocks.Lock(vaultLockKey(vaultID))
+	defer unlock()
+
+	// Each entry is validated after the previous ones are written, so a
+	// repeated target or nonce inside the batch is caught like any other.
+	created := make([]*storage.KeyUpdateRow, 0, len(batch))
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		validator := s.keyUpdatesValidator.WithTx(tx)
+		invites := s.invites.WithTx(tx)
+		keyUpdates := s.keyUpdates.WithTx(tx)
+
+		targets := make(map[string]bool, len(batch))
+		for i := range batch {
+			ku := &batch[i]
+			row, apiErr := validator.ValidateKeyUpdate(ctx, ku)
+			if apiErr != nil {
+				return apierror.BatchRejected([]apierror.BatchItemError{{Index: i, APIError: apiErr}})
+			}
+			if err := invites.RecordNonceUsed(ctx, "key_update", vaultID, string(ku.CreatedByDeviceID), ku.Nonce); err != nil {
+				return err
+			}
+			if err := keyUpdates.Create(ctx, row); err != nil {
+				return err
+			}
+			targets[row.TargetDeviceID] = true
+			created = append(created, row)
+		}
+
+		if apiErr := validator.ValidateCoverage(ctx, vaultID, targets); apiErr != nil {
+			return apiErr
+		}
+		return s.completeRotation(ctx, tx, vaultID, uint64(keyEpoch))
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	for _, row := range created {
+		s.hub.Publish(vaultID, StreamMessage{
+			Event:          "key_update",
+			TargetDeviceID: row.TargetDeviceID,
+			Data:           keyUpdateFromRow(row),
+		})
+	}
+
+	writeJSON(w, http.StatusCreated, batch)
+}
+
+// completeRotation makes keyEpoch the vault's current epoch once every
+// current member has a key update for it and has acked it, and records the
+// membership head it completed at. Earlier epochs stop being accepted for new
+// events from then on. While a rotation is pending, only key updates issued
+// after the removal that triggered it count.
+func (s *Server) completeRotation(ctx context.Context, tx *sql.Tx, vaultID []byte, keyEpoch uint64) error {
+	vaults := s.vaults.WithTx(tx)
+
+	vault, err := vaults.Get(ctx, vaultID)
+	if err != nil {
+		return err
+	}
+	if vault == nil || keyEpoch <= vault.KeyEpoch {
+		return nil
+	}
+
+	pending, err := s.keyUpdates.WithTx(tx).CountPendingMembers(ctx, vaultID, keyEpoch, vault.RotationPendingSeq)
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return nil
+	}
+
+	advanced, err := vaults.AdvanceKeyEpoch(ctx, vaultID, keyEpoch)
+	if err != nil || !advanced {
+		return err
+	}
+
+	head, err := vaults.GetMembershipHead(ctx, vaultID)
+	if err != nil {
+		return err
+	}
+	return vaults.CreateKeyEpoch(ctx, &storage.VaultKeyEpochRow{
+		VaultID:        vaultID,
+		KeyEpoch:       keyEpoch,
+		MemberSeq:      head.MemberSeq,
+		MemberHeadHash: head.MemberHeadHash,
+	})
 }
 
 func (s *Server) handleKeyUpdatesList(w http.ResponseWriter, r *http.Request) {
@@ -60,31 +220,192 @@ func (s *Server) handleKeyUpdatesList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updates, err := s.keyUpdates.ListByTargetDevice(r.Context(), deviceID)
+	if apiErr := requireDevice(r, deviceID); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	page, apiErr := s.parsePage(r, "key_updates")
+	if apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	pw := newPageWriter(w, "key_updates", page)
+	err := s.keyUpdates.ListByTargetDevice(r.Context(), deviceID, page, func(ku *storage.KeyUpdateRow) error {
+		return pw.write(keyUpdateFromRow(ku), storage.Cursor{CreatedAt: ku.CreatedAt, ID: ku.KeyUpdateID})
+	})
+	if err != nil {
+		pw.fail(r, err)
+		return
+	}
+	pw.finish()
+}
+
+// handleRotationStatus shows which current members still need a key update
+// before a pending rotation completes.
+func (s *Server) handleRotationStatus(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	vault, err := s.vaults.Get(ctx, vaultID)
+	if err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+	if vault == nil {
+		apierror.NotFound("vault").WriteJSON(w)
+		return
+	}
+
+	status := models.RotationStatus{
+		KeyEpoch:        models.Uint64String(vault.KeyEpoch),
+		RotationPending: vault.RotationPendingSeq != 0,
+		Distributed:     []models.DeviceID{},
+		Missing:         []models.DeviceID{},
+	}
+	if !status.RotationPending {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	status.PendingSinceMemberSeq = models.Uint64String(vault.RotationPendingSeq)
+
+	target, err := s.keyUpdates.LatestEpochSince(ctx, vaultID, vault.RotationPendingSeq)
+	if err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+	if target == 0 {
+		// Nothing distributed since the removal yet: everyone is missing,
+		// and the rotation has to skip any epoch already used before it.
+		used, err := s.keyUpdates.LatestEpochBefore(ctx, vaultID, vault.RotationPendingSeq)
+		if err != nil {
+			apierror.InternalError().WriteJSON(w)
+			return
+		}
+		target = max(used, vault.KeyEpoch) + 1
+	}
+	status.TargetEpoch = models.Uint64String(target)
+
+	targets, err := s.keyUpdates.ListTargets(ctx, vaultID, target, vault.RotationPendingSeq)
+	if err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+	distributed := make(map[string]bool, len(targets))
+	for _, deviceID := range targets {
+		distributed[deviceID] = true
+	}
+
+	members, err := s.vaults.ListMembers(ctx, vaultID)
+	if err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+	for _, m := range members {
+		if distributed[m.DeviceID] {
+			status.Distributed = append(status.Distributed, models.DeviceID(m.DeviceID))
+		} else {
+			status.Missing = append(status.Missing, models.DeviceID(m.DeviceID))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleKeyEpochStatus reports, for every current member, whether it has been
+// sent the epoch's key and whether it has acked it.
+func (s *Server) handleKeyEpochStatus(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	keyEpoch, err := parseUint64(getPathParam(r, "epoch"))
+	if err != nil || keyEpoch == 0 {
+		apierror.BadRequest("invalid_epoch", "epoch must be a positive integer").WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	vault, err := s.vaults.Get(ctx, vaultID)
 	if err != nil {
 		apierror.InternalError().WriteJSON(w)
 		return
 	}
+	if vault == nil {
+		apierror.NotFound("vault").WriteJSON(w)
+		return
+	}
 
-	response := make([]models.KeyUpdate, 0, len(updates))
-	for _, ku := range updates {
-		response = append(response, models.KeyUpdate{
-			MsgType:           "key_update",
-			KeyUpdateID:       bytesToUUID(ku.KeyUpdateID),
-			VaultID:           bytesToUUID(ku.VaultID),
-			MemberSeq:         models.Uint64String(ku.MemberSeq),
-			MemberHeadHash:    ku.MemberHeadHash,
-			TargetDeviceID:    models.DeviceID(ku.TargetDeviceID),
-			KeyEpoch:          models.Uint64String(ku.KeyEpoch),
-			Nonce:             ku.Nonce,
-			WrappedPayload:    ku.WrappedPayload,
-			CreatedByDeviceID: models.DeviceID(ku.CreatedByDeviceID),
-			Signature:         ku.Signature,
-			CreatedAt:         ku.CreatedAt,
+	completed, err := s.vaults.GetKeyEpoch(ctx, vaultID, keyEpoch)
+	if err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
+	members, err := s.vaults.ListMembers(ctx, vaultID)
+	if err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
+	status := models.KeyEpochStatus{
+		VaultID:  bytesToUUID(vaultID),
+		KeyEpoch: models.Uint64String(keyEpoch),
+		Current:  keyEpoch == vault.KeyEpoch,
+		Complete: completed != nil,
+		Members:  make([]models.KeyEpochMember, 0, len(members)),
+	}
+	if completed != nil {
+		status.MemberSeq = models.Uint64String(completed.MemberSeq)
+		status.MemberHeadHash = completed.MemberHeadHash
+		status.CompletedAt = completed.CompletedAt
+	}
+
+	for _, m := range members {
+		hasUpdate, err := s.keyUpdates.CheckExists(ctx, vaultID, keyEpoch, m.DeviceID)
+		if err != nil {
+			apierror.InternalError().WriteJSON(w)
+			return
+		}
+		ack, err := s.keyUpdates.GetAck(ctx, vaultID, keyEpoch, m.DeviceID)
+		if err != nil {
+			apierror.InternalError().WriteJSON(w)
+			return
+		}
+		status.Members = append(status.Members, models.KeyEpochMember{
+			DeviceID:  models.DeviceID(m.DeviceID),
+			KeyUpdate: hasUpdate,
+			Acked:     ack != nil,
 		})
 	}
 
-	writeJSON(w, http.StatusOK, response)
+	writeJSON(w, http.StatusOK, status)
+}
+
+func keyUpdateFromRow(ku *storage.KeyUpdateRow) models.KeyUpdate {
+	return models.KeyUpdate{
+		MsgType:           "key_update",
+		KeyUpdateID:       bytesToUUID(ku.KeyUpdateID),
+		VaultID:           bytesToUUID(ku.VaultID),
+		MemberSeq:         models.Uint64String(ku.MemberSeq),
+		MemberHeadHash:    ku.MemberHeadHash,
+		TargetDeviceID:    models.DeviceID(ku.TargetDeviceID),
+		KeyEpoch:          models.Uint64String(ku.KeyEpoch),
+		Nonce:             ku.Nonce,
+		WrappedPayload:    ku.WrappedPayload,
+		CreatedByDeviceID: models.DeviceID(ku.CreatedByDeviceID),
+		Signature:         ku.Signature,
+		CreatedAt:         ku.CreatedAt,
+	}
 }
 
 func (s *Server) handleKeyUpdateAck(w http.ResponseWriter, r *http.Request) {
@@ -105,21 +426,32 @@ func (s *Server) handleKeyUpdateAck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
-
-	row, apiErr := s.keyUpdatesValidator.ValidateKeyUpdateAck(ctx, &ack)
-	if apiErr != nil {
+	if apiErr := requireDevice(r, string(ack.DeviceID)); apiErr != nil {
 		apiErr.WriteJSON(w)
 		return
 	}
 
-	if err := s.keyUpdates.CreateAck(ctx, row); err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
-	}
+	ctx := r.Context()
 
-	if err := s.vaults.UpdateMemberKeyEpoch(ctx, vaultID, string(ack.DeviceID), uint64(ack.KeyEpoch)); err != nil {
-		apierror.InternalError().WriteJSON(w)
+	unlock := s.writeLocks.Lock(vaultLockKey(vaultID))
+	defer unlock()
+
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		row, apiErr := s.keyUpdatesValidator.WithTx(tx).ValidateKeyUpdateAck(ctx, &ack)
+		if apiErr != nil {
+			return apiErr
+		}
+
+		if err := s.keyUpdates.WithTx(tx).CreateAck(ctx, row); err != nil {
+			return err
+		}
+		if err := s.vaults.WithTx(tx).UpdateMemberKeyEpoch(ctx, vaultID, string(ack.DeviceID), uint64(ack.KeyEpoch)); err != nil {
+			return err
+		}
+		return s.completeRotation(ctx, tx, vaultID, row.KeyEpoch)
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,213 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestKeyUpdateAckRequiresKeyUpdateAndNeverRegresses(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(member)
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+
+	r := ts.ack(v, member, 2)
+	if r.status != http.StatusBadRequest || r.errorCode() != "key_update_not_found" {
+		t.Fatalf("ack without key update: want 400 key_update_not_found, got %s", r)
+	}
+
+	ts.must(ts.keyUpdate(v, owner, member, 2), http.StatusCreated, "key update")
+	ts.must(ts.ack(v, member, 2), http.StatusCreated, "ack")
+
+	ts.must(ts.keyUpdate(v, owner, member, 3), http.StatusCreated, "key update")
+	ts.must(ts.ack(v, member, 3), http.StatusCreated, "ack")
+
+	r = ts.ack(v, member, 2)
+	if r.status != http.StatusConflict || r.errorCode() != "stale_key_epoch" {
+		t.Fatalf("ack of an older epoch: want 409 stale_key_epoch, got %s", r)
+	}
+	if n := ts.count("SELECT key_epoch FROM vault_members WHERE vault_id = ? AND device_id = ?", v.id[:], member.id); n != 3 {
+		t.Errorf("member key_epoch: want 3, got %d", n)
+	}
+}
+
+func TestRotationSkipsEpochsUsedBeforeRemoval(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member, removed := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, member, removed} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+	ts.addMember(v, owner, removed)
+
+	// A rotation to epoch 2 is under way when the removal happens; the
+	// removed device already has epoch 2's key.
+	ts.must(ts.keyUpdate(v, owner, member, 2), http.StatusCreated, "key update")
+	ts.must(ts.keyUpdate(v, owner, removed, 2), http.StatusCreated, "key update")
+	ts.must(ts.memberRemove(v, owner, removed), http.StatusCreated, "member_remove")
+
+	status := ts.must(ts.do("GET", v.path("/rotation"), nil, owner), http.StatusOK, "rotation status").json()
+	if status["target_epoch"] != "3" {
+		t.Fatalf("target_epoch: want 3, got %v", status["target_epoch"])
+	}
+
+	r := ts.keyUpdate(v, owner, owner, 2)
+	if r.status != http.StatusConflict || r.errorCode() != "key_epoch_used" {
+		t.Fatalf("key update at a used epoch: want 409 key_epoch_used, got %s", r)
+	}
+
+	for _, d := range []*testDevice{owner, member} {
+		ts.must(ts.keyUpdate(v, owner, d, 3), http.StatusCreated, "key update")
+		ts.must(ts.ack(v, d, 3), http.StatusCreated, "ack")
+	}
+	status = ts.must(ts.do("GET", v.path("/rotation"), nil, owner), http.StatusOK, "rotation status").json()
+	if status["rotation_pending"] != false || status["key_epoch"] != "3" {
+		t.Errorf("after rotation: want epoch 3 with nothing pending, got %v", status)
+	}
+}
+
+func TestRotationBlocksEpochsDistributedBeforeRemoval(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member, removed := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, member, removed} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+	ts.addMember(v, owner, removed)
+
+	// Epoch 2 reaches everyone, but the removed device never acks, so the
+	// vault stays on epoch 1 while the others may already write at 2.
+	for _, d := range []*testDevice{owner, member, removed} {
+		ts.must(ts.keyUpdate(v, owner, d, 2), http.StatusCreated, "key update")
+	}
+	ts.must(ts.ack(v, owner, 2), http.StatusCreated, "ack")
+	ts.must(ts.push(v, owner, 2), http.StatusCreated, "event at epoch 2")
+	ts.must(ts.memberRemove(v, owner, removed), http.StatusCreated, "member_remove")
+
+	r := ts.push(v, owner, 2)
+	if r.status != http.StatusConflict || r.errorCode() != "rotation_pending" {
+		t.Fatalf("event at an epoch the removed device holds: want 409 rotation_pending, got %s", r)
+	}
+}
+
+func TestAckDuringRotationNeedsKeyUpdateAfterRemoval(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member, removed := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, member, removed} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+	ts.addMember(v, owner, removed)
+
+	ts.must(ts.keyUpdate(v, owner, member, 2), http.StatusCreated, "key update")
+	ts.must(ts.memberRemove(v, owner, removed), http.StatusCreated, "member_remove")
+
+	r := ts.ack(v, member, 2)
+	if r.status != http.StatusConflict || r.errorCode() != "rotation_pending" {
+		t.Fatalf("ack of a key sent before the removal: want 409 rotation_pending, got %s", r)
+	}
+	if n := ts.count("SELECT key_epoch FROM vault_members WHERE vault_id = ? AND device_id = ?", v.id[:], member.id); n != 1 {
+		t.Errorf("member key_epoch: want 1, got %d", n)
+	}
+
+	ts.must(ts.keyUpdate(v, owner, member, 3), http.StatusCreated, "key update after removal")
+	ts.must(ts.ack(v, member, 3), http.StatusCreated, "ack of a key sent after the removal")
+}
+
+func TestKeyEpochCompletesWhenAllMembersAck(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(member)
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+
+	epochStatus := func() map[string]any {
+		t.Helper()
+		return ts.must(ts.do("GET", v.path("/key_epochs/2"), nil, owner), http.StatusOK, "key epoch status").json()
+	}
+	memberStatus := func(status map[string]any, d *testDevice) map[string]any {
+		t.Helper()
+		members, _ := status["members"].([]any)
+		for _, m := range members {
+			if m := m.(map[string]any); m["device_id"] == d.id {
+				return m
+			}
+		}
+		t.Fatalf("members: %s missing from %v", d.id, members)
+		return nil
+	}
+
+	ts.must(ts.keyUpdate(v, owner, owner, 2), http.StatusCreated, "key update")
+	ts.must(ts.keyUpdate(v, owner, member, 2), http.StatusCreated, "key update")
+	ts.must(ts.ack(v, owner, 2), http.StatusCreated, "ack")
+
+	status := epochStatus()
+	if status["complete"] != false || status["current"] != false {
+		t.Errorf("half acked: want neither complete nor current, got %v", status)
+	}
+	if m := memberStatus(status, owner); m["key_update"] != true || m["acked"] != true {
+		t.Errorf("owner: want key update and ack, got %v", m)
+	}
+	if m := memberStatus(status, member); m["key_update"] != true || m["acked"] != false {
+		t.Errorf("member: want key update without ack, got %v", m)
+	}
+
+	ts.must(ts.ack(v, member, 2), http.StatusCreated, "ack")
+	status = epochStatus()
+	if status["complete"] != true || status["current"] != true {
+		t.Errorf("all acked: want complete and current, got %v", status)
+	}
+	if status["member_seq"] != strconv.FormatUint(v.seq, 10) || status["member_head_hash"] != b64(v.head) {
+		t.Errorf("binding: want seq %d and the current head, got %v", v.seq, status)
+	}
+	ts.must(ts.push(v, member, 2), http.StatusCreated, "event at the new epoch")
+	if r := ts.push(v, owner, 1); r.errorCode() != "stale_key_epoch" {
+		t.Errorf("event at the old epoch: want stale_key_epoch, got %s", r)
+	}
+}
+
+func TestKeyUpdateBatchMustCoverMembership(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member, other := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, member, other} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+	ts.addMember(v, owner, other)
+
+	partial := []map[string]any{
+		ts.keyUpdateBody(v, owner, owner, 2),
+		ts.keyUpdateBody(v, owner, member, 2),
+	}
+	r := ts.do("POST", v.path("/key_updates:batch"), partial, owner)
+	if r.status != http.StatusBadRequest || r.errorCode() != "incomplete_key_update_batch" {
+		t.Fatalf("batch missing a member: want 400 incomplete_key_update_batch, got %s", r)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM key_updates WHERE vault_id = ?", v.id[:]); n != 0 {
+		t.Fatalf("key_updates after rejected batch: want 0, got %d", n)
+	}
+
+	bad := ts.keyUpdateBody(v, owner, other, 2)
+	bad["signature"] = b64(owner.sign([]byte("something else")))
+	r = ts.do("POST", v.path("/key_updates:batch"), append(partial, bad), owner)
+	if r.status != http.StatusBadRequest || r.errorCode() != "batch_rejected" {
+		t.Fatalf("batch with a bad signature: want 400 batch_rejected, got %s", r)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM key_updates WHERE vault_id = ?", v.id[:]); n != 0 {
+		t.Fatalf("key_updates after rejected batch: want 0, got %d", n)
+	}
+
+	full := append(partial, ts.keyUpdateBody(v, owner, other, 2))
+	ts.must(ts.do("POST", v.path("/key_updates:batch"), full, owner), http.StatusCreated, "full batch")
+	if n := ts.count("SELECT COUNT(*) FROM key_updates WHERE vault_id = ? AND key_epoch = 2", v.id[:]); n != 3 {
+		t.Errorf("key_updates: want 3 at epoch 2, got %d", n)
+	}
+}
@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+// KeyedMutex hands out one mutex per key and forgets keys nobody holds, so it
+// can be keyed by vault or device chain without growing unbounded.
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock blocks until the key is free and returns the matching unlock func.
+func (k *KeyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	l, exists := k.locks[key]
+	if !exists {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+func vaultLockKey(vaultID []byte) string {
+	return "vault:" + hex.EncodeToString(vaultID)
+}
+
+func deviceChainLockKey(vaultID []byte, deviceID string) string {
+	return "chain:" + hex.EncodeToString(vaultID) + ":" + deviceID
+}
@@ -2,12 +2,15 @@ package httpapi
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"net/http"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/models"
 	"forgor-server/internal/storage"
+	"forgor-server/internal/validation"
 )
 
 func (s *Server) handleMemberEventCreate(w http.ResponseWriter, r *http.Request) {
@@ -31,97 +34,222 @@ func (s *Server) handleMemberEventCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	ctx := r.Context()
-	var row *storage.MemberEventRow
 	var event models.MemberEvent
 
 	switch msgTypeCheck.MsgType {
-	case "member_add":
+	case "member_add", "member_remove", "member_leave", "owner_transfer", "member_promote", "member_demote", "vault_policy":
 		if err := json.Unmarshal(raw, &event); err != nil {
-			apierror.BadRequest("invalid_json", "failed to parse member_add").WriteJSON(w)
+			apierror.BadRequest("invalid_json", "failed to parse "+msgTypeCheck.MsgType).WriteJSON(w)
 			return
 		}
 
-		if !bytes.Equal(vaultID, event.VaultID.Bytes()) {
-			apierror.BadRequest("vault_id_mismatch", "vault_id in path does not match body").WriteJSON(w)
-			return
-		}
+	default:
+		apierror.BadRequest("invalid_msg_type", "msg_type must be 'member_add', 'member_remove', 'member_leave', 'owner_transfer', 'member_promote', 'member_demote' or 'vault_policy'").WriteJSON(w)
+		return
+	}
+
+	if !bytes.Equal(vaultID, event.VaultID.Bytes()) {
+		apierror.BadRequest("vault_id_mismatch", "vault_id in path does not match body").WriteJSON(w)
+		return
+	}
+
+	if apiErr := requireDevice(r, string(event.ActorDeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	unlock := s.writeLocks.Lock(vaultLockKey(vaultID))
+	defer unlock()
 
+	var row *storage.MemberEventRow
+	var quorum uint64
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
 		var apiErr *apierror.APIError
-		row, apiErr = s.membershipValidator.ValidateMemberAdd(ctx, &event)
+		row, apiErr = validateMemberEvent(ctx, s.membershipValidator.WithTx(tx), &event)
 		if apiErr != nil {
-			apiErr.WriteJSON(w)
-			return
+			return apiErr
 		}
 
-	case "member_remove":
-		if err := json.Unmarshal(raw, &event); err != nil {
-			apierror.BadRequest("invalid_json", "failed to parse member_remove").WriteJSON(w)
-			return
+		vault, err := s.vaults.WithTx(tx).Get(ctx, vaultID)
+		if err != nil {
+			return err
 		}
-
-		if !bytes.Equal(vaultID, event.VaultID.Bytes()) {
-			apierror.BadRequest("vault_id_mismatch", "vault_id in path does not match body").WriteJSON(w)
-			return
+		quorum, err = requiredQuorum(ctx, s.vaults.WithTx(tx), vault, row)
+		if err != nil {
+			return err
 		}
-
-		var apiErr *apierror.APIError
-		row, apiErr = s.membershipValidator.ValidateMemberRemove(ctx, &event)
-		if apiErr != nil {
-			apiErr.WriteJSON(w)
-			return
+		if quorum > 1 {
+			return s.proposeMemberEvent(ctx, tx, row)
 		}
 
-	default:
-		apierror.BadRequest("invalid_msg_type", "msg_type must be 'member_add' or 'member_remove'").WriteJSON(w)
+		return s.applyMemberEvent(ctx, tx, row)
+	})
+	if isEquivocation(err) {
+		s.recordMemberEquivocation(ctx, &event)
+	}
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	// Held back until enough admins co-sign it.
+	if quorum > 1 {
+		writeJSON(w, http.StatusAccepted, models.MemberProposal{
+			MemberEvent: event,
+			Status:      storage.ProposalPending,
+			Quorum:      models.Uint64String(quorum),
+			Signatures: []models.MemberProposalSignature{{
+				DeviceID:  event.ActorDeviceID,
+				Signature: event.Signature,
+			}},
+		})
+		return
+	}
+
+	s.hub.Publish(vaultID, StreamMessage{
+		Event:     "member_event",
+		MemberSeq: row.MemberSeq,
+		Data:      memberEventFromRow(row),
+	})
+
+	writeJSON(w, http.StatusCreated, event)
+}
+
+// validateMemberEvent runs the validator for the event's msg_type.
+func validateMemberEvent(ctx context.Context, validator *validation.MembershipValidator, event *models.MemberEvent) (*storage.MemberEventRow, *apierror.APIError) {
+	switch event.MsgType {
+	case "member_add":
+		return validator.ValidateMemberAdd(ctx, event)
+	case "member_remove":
+		return validator.ValidateMemberRemove(ctx, event)
+	case "member_leave":
+		return validator.ValidateMemberLeave(ctx, event)
+	case "owner_transfer":
+		return validator.ValidateOwnerTransfer(ctx, event)
+	case "member_promote", "member_demote":
+		return validator.ValidateRoleChange(ctx, event)
+	case "vault_policy":
+		return validator.ValidateVaultPolicy(ctx, event)
+	}
+	return nil, apierror.BadRequest("invalid_msg_type", "unsupported msg_type")
+}
+
+// applyMemberEvent appends a validated member event to the log and updates
+// the derived membership state, all within the caller's transaction.
+func (s *Server) applyMemberEvent(ctx context.Context, tx *sql.Tx, row *storage.MemberEventRow) error {
+	vaults := s.vaults.WithTx(tx)
+
 	isGenesis := row.MemberSeq == 1
 
 	if isGenesis {
-		if err := s.vaults.Create(ctx, vaultID, row.ActorDeviceID); err != nil {
-			apierror.InternalError().WriteJSON(w)
-			return
+		if err := vaults.Create(ctx, row.VaultID, row.ActorDeviceID); err != nil {
+			return err
 		}
 	}
 
-	if err := s.memberEvents.Create(ctx, row); err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
+	advanced, err := vaults.AdvanceMembershipHead(ctx, row.VaultID, row.MemberSeq-1, row.PrevHash, row.MemberSeq, row.MemberHash)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		current, err := vaults.GetMembershipHead(ctx, row.VaultID)
+		if err != nil {
+			return err
+		}
+		return validation.MembershipChainBrokenAt(current)
 	}
 
-	if err := s.vaults.UpsertMembershipHead(ctx, vaultID, row.MemberSeq, row.MemberHash); err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
+	if err := s.memberEvents.WithTx(tx).Create(ctx, row); err != nil {
+		return err
+	}
+
+	if isGenesis {
+		if err := vaults.CreateKeyEpoch(ctx, &storage.VaultKeyEpochRow{
+			VaultID:        row.VaultID,
+			KeyEpoch:       1,
+			MemberSeq:      row.MemberSeq,
+			MemberHeadHash: row.MemberHash,
+		}); err != nil {
+			return err
+		}
 	}
 
-	if msgTypeCheck.MsgType == "member_add" {
+	switch row.MsgType {
+	case "member_add":
+		// A new member holds the key the vault is currently on, delivered
+		// with its invite.
+		vault, err := vaults.Get(ctx, row.VaultID)
+		if err != nil {
+			return err
+		}
+
 		member := &storage.VaultMemberRow{
-			VaultID:          vaultID,
+			VaultID:          row.VaultID,
 			DeviceID:         row.SubjectDeviceID,
 			DevicePubkeySign: row.SubjectPubkeySign,
 			DevicePubkeyBox:  row.SubjectPubkeyBox,
 			SubjectBundleSig: row.SubjectBundleSig,
 			IsMember:         true,
-			KeyEpoch:         1,
+			KeyEpoch:         vault.KeyEpoch,
+			Role:             models.RoleMember,
 		}
-		if err := s.vaults.UpsertMember(ctx, member); err != nil {
-			apierror.InternalError().WriteJSON(w)
-			return
+		if isGenesis {
+			member.Role = models.RoleOwner
+		}
+		if err := vaults.UpsertMember(ctx, member); err != nil {
+			return err
 		}
 
 		if !isGenesis && row.InviteID != nil {
-			if err := s.invites.MarkUsed(ctx, row.InviteID); err != nil { }
+			marked, err := s.invites.WithTx(tx).MarkUsed(ctx, row.InviteID)
+			if err != nil {
+				return err
+			}
+			if !marked {
+				return apierror.InviteAlreadyUsed()
+			}
 		}
-	} else {
-		if err := s.vaults.SetMemberRemoved(ctx, vaultID, row.SubjectDeviceID); err != nil {
-			apierror.InternalError().WriteJSON(w)
-			return
+
+	case "member_remove", "member_leave":
+		if err := vaults.SetMemberRemoved(ctx, row.VaultID, row.SubjectDeviceID); err != nil {
+			return err
+		}
+		// The removed device still has the current vault key.
+		if err := vaults.SetRotationPending(ctx, row.VaultID, row.MemberSeq); err != nil {
+			return err
+		}
+
+	case "owner_transfer":
+		vault, err := vaults.Get(ctx, row.VaultID)
+		if err != nil {
+			return err
+		}
+		if err := vaults.SetOwner(ctx, row.VaultID, row.SubjectDeviceID); err != nil {
+			return err
+		}
+		// The previous owner stays on as an admin.
+		if err := vaults.SetMemberRole(ctx, row.VaultID, vault.OwnerDeviceID, models.RoleAdmin); err != nil {
+			return err
+		}
+		if err := vaults.SetMemberRole(ctx, row.VaultID, row.SubjectDeviceID, models.RoleOwner); err != nil {
+			return err
+		}
+
+	case "member_promote", "member_demote":
+		if err := vaults.SetMemberRole(ctx, row.VaultID, row.SubjectDeviceID, row.Role); err != nil {
+			return err
+		}
+
+	case "vault_policy":
+		if err := vaults.SetMemberQuorum(ctx, row.VaultID, row.Quorum); err != nil {
+			return err
 		}
 	}
 
-	writeJSON(w, http.StatusCreated, event)
+	// Proposals for this position lost the race.
+	return s.memberProposals.WithTx(tx).SupersedePending(ctx, row.VaultID, row.MemberSeq)
 }
 
 func (s *Server) handleMemberEventsList(w http.ResponseWriter, r *http.Request) {
@@ -131,46 +259,55 @@ func (s *Server) handleMemberEventsList(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	page, apiErr := s.parsePage(r, "member_events")
+	if apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
 	sinceSeqStr := getQueryParam(r, "since_seq")
-	var sinceSeq uint64
-	if sinceSeqStr != "" {
-		sinceSeq, err = parseUint64(sinceSeqStr)
+	if sinceSeqStr != "" && page.After == nil {
+		sinceSeq, err := parseUint64(sinceSeqStr)
 		if err != nil {
 			apierror.BadRequest("invalid_since_seq", "since_seq must be a valid integer").WriteJSON(w)
 			return
 		}
+		page.After = &storage.Cursor{Seq: sinceSeq}
 	}
 
-	events, err := s.memberEvents.ListSince(r.Context(), vaultID, sinceSeq)
+	pw := newPageWriter(w, "member_events", page)
+	err = s.memberEvents.ListSince(r.Context(), vaultID, page, func(e *storage.MemberEventRow) error {
+		return pw.write(memberEventFromRow(e), storage.Cursor{Seq: e.MemberSeq})
+	})
 	if err != nil {
-		apierror.InternalError().WriteJSON(w)
+		pw.fail(r, err)
 		return
 	}
+	pw.finish()
+}
 
-	response := make([]models.MemberEvent, 0, len(events))
-	for _, e := range events {
-		me := models.MemberEvent{
-			MsgType:         e.MsgType,
-			MemberEventID:   bytesToUUID(e.MemberEventID),
-			VaultID:         bytesToUUID(e.VaultID),
-			MemberSeq:       models.Uint64String(e.MemberSeq),
-			PrevHash:        e.PrevHash,
-			ActorDeviceID:   models.DeviceID(e.ActorDeviceID),
-			SubjectDeviceID: models.DeviceID(e.SubjectDeviceID),
-			Signature:       e.Signature,
-			CreatedAt:       e.CreatedAt,
-		}
-		if e.MsgType == "member_add" {
-			me.SubjectPubkeySign = e.SubjectPubkeySign
-			me.SubjectPubkeyBox = e.SubjectPubkeyBox
-			me.SubjectBundleSig = e.SubjectBundleSig
-			me.InviteID = bytesToUUID(e.InviteID)
-			me.ClaimSig = e.ClaimSig
-		}
-		response = append(response, me)
+func memberEventFromRow(e *storage.MemberEventRow) models.MemberEvent {
+	me := models.MemberEvent{
+		MsgType:         e.MsgType,
+		MemberEventID:   bytesToUUID(e.MemberEventID),
+		VaultID:         bytesToUUID(e.VaultID),
+		MemberSeq:       models.Uint64String(e.MemberSeq),
+		PrevHash:        e.PrevHash,
+		ActorDeviceID:   models.DeviceID(e.ActorDeviceID),
+		SubjectDeviceID: models.DeviceID(e.SubjectDeviceID),
+		Signature:       e.Signature,
+		CreatedAt:       e.CreatedAt,
 	}
-
-	writeJSON(w, http.StatusOK, response)
+	if e.MsgType == "member_add" {
+		me.SubjectPubkeySign = e.SubjectPubkeySign
+		me.SubjectPubkeyBox = e.SubjectPubkeyBox
+		me.SubjectBundleSig = e.SubjectBundleSig
+		me.InviteID = bytesToUUID(e.InviteID)
+		me.ClaimSig = e.ClaimSig
+	}
+	me.Role = e.Role
+	me.Quorum = models.Uint64String(e.Quorum)
+	return me
 }
 
 func (s *Server) handleVaultMembersList(w http.ResponseWriter, r *http.Request) {
@@ -192,6 +329,12 @@ func (s *Server) handleVaultMembersList(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	vault, err := s.vaults.Get(ctx, vaultID)
+	if err != nil || vault == nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
 	members, err := s.vaults.ListMembers(ctx, vaultID)
 	if err != nil {
 		apierror.InternalError().WriteJSON(w)
@@ -201,17 +344,23 @@ func (s *Server) handleVaultMembersList(w http.ResponseWriter, r *http.Request)
 	memberList := make([]models.VaultMember, 0, len(members))
 	for _, m := range members {
 		memberList = append(memberList, models.VaultMember{
-			DeviceID:        models.DeviceID(m.DeviceID),
+			DeviceID:         models.DeviceID(m.DeviceID),
 			DevicePubkeySign: m.DevicePubkeySign,
 			DevicePubkeyBox:  m.DevicePubkeyBox,
-			KeyEpoch:        models.Uint64String(m.KeyEpoch),
+			KeyEpoch:         models.Uint64String(m.KeyEpoch),
+			Role:             m.Role,
+			Frozen:           m.Frozen,
 		})
 	}
 
 	response := models.VaultMembershipResponse{
-		MemberSeq: models.Uint64String(head.MemberSeq),
-		HeadHash:  head.MemberHeadHash,
-		Members:   memberList,
+		MemberSeq:       models.Uint64String(head.MemberSeq),
+		HeadHash:        head.MemberHeadHash,
+		OwnerDeviceID:   models.DeviceID(vault.OwnerDeviceID),
+		KeyEpoch:        models.Uint64String(vault.KeyEpoch),
+		RotationPending: vault.RotationPendingSeq != 0,
+		MemberQuorum:    models.Uint64String(vault.MemberQuorum),
+		Members:         memberList,
 	}
 
 	writeJSON(w, http.StatusOK, response)
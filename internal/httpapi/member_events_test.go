@@ -0,0 +1,178 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"forgor-server/internal/cbe"
+)
+
+func TestOwnerCannotBeRemoved(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, admin := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(admin)
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, admin)
+	ts.must(ts.roleChange(v, "member_promote", owner, admin, "admin"), http.StatusCreated, "promote")
+
+	for _, actor := range []*testDevice{admin, owner} {
+		r := ts.memberRemove(v, actor, owner)
+		if r.status != http.StatusBadRequest || r.errorCode() != "cannot_remove_owner" {
+			t.Errorf("remove owner: want 400 cannot_remove_owner, got %s", r)
+		}
+	}
+
+	r := ts.memberLeave(v, owner)
+	if r.status != http.StatusBadRequest || r.errorCode() != "owner_cannot_leave" {
+		t.Errorf("owner leave: want 400 owner_cannot_leave, got %s", r)
+	}
+}
+
+// members returns the vault's owner and each current member's role.
+func (ts *testServer) members(v *testVault, reader *testDevice) (string, map[string]string) {
+	ts.t.Helper()
+	m := ts.must(ts.do("GET", v.path("/members"), nil, reader), http.StatusOK, "members").json()
+	roles := map[string]string{}
+	items, _ := m["members"].([]any)
+	for _, it := range items {
+		it := it.(map[string]any)
+		roles[it["device_id"].(string)], _ = it["role"].(string)
+	}
+	owner, _ := m["owner_device_id"].(string)
+	return owner, roles
+}
+
+func TestOwnerTransfer(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member, outsider := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, member, outsider} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+
+	if r := ts.ownerTransfer(v, member, member); r.status != http.StatusForbidden {
+		t.Errorf("transfer by a member: want 403, got %s", r)
+	}
+	if r := ts.ownerTransfer(v, owner, outsider); r.errorCode() != "subject_not_member" {
+		t.Errorf("transfer to a non-member: want subject_not_member, got %s", r)
+	}
+
+	ts.must(ts.ownerTransfer(v, owner, member), http.StatusCreated, "owner_transfer")
+	newOwner, roles := ts.members(v, member)
+	if newOwner != member.id || roles[member.id] != "owner" || roles[owner.id] != "admin" {
+		t.Errorf("after transfer: want %s as owner and the old owner as admin, got owner %s, roles %v", member.id, newOwner, roles)
+	}
+
+	if r := ts.ownerTransfer(v, owner, owner); r.status != http.StatusForbidden {
+		t.Errorf("transfer back by the old owner: want 403, got %s", r)
+	}
+	ts.must(ts.vaultDelete(v, owner), http.StatusForbidden, "delete by the old owner")
+
+	log := ts.must(ts.do("GET", v.path("/member_events"), nil, member), http.StatusOK, "member events").json()
+	items, _ := log["items"].([]any)
+	last, _ := items[len(items)-1].(map[string]any)
+	if last["msg_type"] != "owner_transfer" || last["subject_device_id"] != member.id {
+		t.Errorf("member log: want the owner_transfer last, got %v", last)
+	}
+}
+
+func TestRolesGateMembershipChanges(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, admin, admin2, member, joiner := newTestDevice(t), newTestDevice(t), newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, admin, admin2, member, joiner} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, admin)
+	ts.addMember(v, owner, admin2)
+	ts.addMember(v, owner, member)
+	ts.must(ts.roleChange(v, "member_promote", owner, admin, "admin"), http.StatusCreated, "promote")
+	ts.must(ts.roleChange(v, "member_promote", owner, admin2, "admin"), http.StatusCreated, "promote")
+
+	if _, r := ts.tryInvite(v, member, joiner); r.status != http.StatusForbidden {
+		t.Errorf("invite by a member: want 403, got %s", r)
+	}
+	if r := ts.keyUpdate(v, member, admin, 2); r.status != http.StatusForbidden {
+		t.Errorf("key update by a member: want 403, got %s", r)
+	}
+	if r := ts.memberRemove(v, member, admin); r.status != http.StatusForbidden {
+		t.Errorf("remove by a member: want 403, got %s", r)
+	}
+
+	// Admins manage members, but only the owner manages admins.
+	ts.addMember(v, admin, joiner)
+	if r := ts.roleChange(v, "member_promote", admin, joiner, "admin"); r.status != http.StatusForbidden {
+		t.Errorf("admin grants admin: want 403, got %s", r)
+	}
+	if r := ts.memberRemove(v, admin, admin2); r.status != http.StatusForbidden {
+		t.Errorf("admin removes an admin: want 403, got %s", r)
+	}
+	ts.must(ts.roleChange(v, "member_demote", admin, joiner, "reader"), http.StatusCreated, "admin demotes a member")
+	ts.must(ts.memberRemove(v, admin, joiner), http.StatusCreated, "admin removes a member")
+	ts.must(ts.memberRemove(v, owner, admin2), http.StatusCreated, "owner removes an admin")
+
+	_, roles := ts.members(v, owner)
+	if roles[admin.id] != "admin" || roles[member.id] != "member" || roles[joiner.id] != "" || roles[admin2.id] != "" {
+		t.Errorf("roles: got %v", roles)
+	}
+}
+
+func TestReaderCannotPushButKeepsKeyFlows(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, reader := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(reader)
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, reader)
+	ts.must(ts.push(v, reader, 1), http.StatusCreated, "push as a member")
+	ts.must(ts.roleChange(v, "member_demote", owner, reader, "reader"), http.StatusCreated, "demote to reader")
+
+	r := ts.push(v, reader, 1)
+	if r.status != http.StatusForbidden || r.errorCode() != "read_only_member" {
+		t.Errorf("push as a reader: want 403 read_only_member, got %s", r)
+	}
+	next, _ := ts.eventBody(v, reader, 1, v.counters[reader.id]+1, v.heads[reader.id])
+	if r := ts.do("POST", v.path("/events:batch"), []map[string]any{next}, reader); r.status != http.StatusForbidden {
+		t.Errorf("batch push as a reader: want 403, got %s", r)
+	}
+
+	ts.must(ts.do("GET", v.path("/events"), nil, reader), http.StatusOK, "reader pulls events")
+	ts.must(ts.keyUpdate(v, owner, reader, 2), http.StatusCreated, "key update for the reader")
+	ts.must(ts.do("GET", "/v1/key_updates?device_id="+reader.id, nil, reader), http.StatusOK, "reader lists key updates")
+	ts.must(ts.ack(v, reader, 2), http.StatusCreated, "reader acks")
+}
+
+func TestMemberLeave(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, member, outsider := newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, member, outsider} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+
+	if r := ts.memberLeave(v, outsider); r.status != http.StatusForbidden {
+		t.Errorf("leave by a non-member: want 403, got %s", r)
+	}
+	r, _ := ts.memberEvent(v, owner, map[string]any{
+		"msg_type":          "member_leave",
+		"subject_device_id": member.id,
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesMemberLeave(id, v.id[:], seq, prev, member.idb)
+	})
+	if r.status != http.StatusBadRequest || r.errorCode() != "leave_actor_mismatch" {
+		t.Errorf("leave on another device's behalf: want 400 leave_actor_mismatch, got %s", r)
+	}
+
+	ts.must(ts.memberLeave(v, member), http.StatusCreated, "member_leave")
+	m := ts.must(ts.do("GET", v.path("/members"), nil, owner), http.StatusOK, "members").json()
+	if m["rotation_pending"] != true {
+		t.Errorf("rotation_pending after leave: want true, got %v", m["rotation_pending"])
+	}
+	if _, roles := ts.members(v, owner); roles[member.id] != "" {
+		t.Errorf("members after leave: %s still listed", member.id)
+	}
+	ts.must(ts.do("GET", v.path("/events"), nil, member), http.StatusForbidden, "read after leave")
+}
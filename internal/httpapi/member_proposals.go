@@ -0,0 +1,291 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+// requiredQuorum returns how many admin signatures row needs before it is
+// appended. Genesis and a member leaving on its own are never held back.
+func requiredQuorum(ctx context.Context, vaults *storage.VaultsRepository, vault *storage.VaultRow, row *storage.MemberEventRow) (uint64, error) {
+	if vault == nil || row.MsgType == "member_leave" {
+		return 1, nil
+	}
+	return memberQuorum(ctx, vaults, vault)
+}
+
+// memberQuorum is the vault's quorum capped at the admins (the owner
+// included) who can currently sign, so demoting, removing or freezing admins
+// never leaves membership changes unreachable.
+func memberQuorum(ctx context.Context, vaults *storage.VaultsRepository, vault *storage.VaultRow) (uint64, error) {
+	admins, err := vaults.CountAdmins(ctx, vault.VaultID)
+	if err != nil {
+		return 0, err
+	}
+	return max(min(vault.MemberQuorum, admins), 1), nil
+}
+
+// proposeMemberEvent stores a validated member event as a pending proposal
+// carrying the proposer's signature.
+func (s *Server) proposeMemberEvent(ctx context.Context, tx *sql.Tx, row *storage.MemberEventRow) error {
+	proposals := s.memberProposals.WithTx(tx)
+
+	existing, err := proposals.Get(ctx, row.MemberEventID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return apierror.Conflict("member_event_id has already been proposed")
+	}
+
+	if err := proposals.Create(ctx, row); err != nil {
+		return err
+	}
+	_, err = proposals.AddSignature(ctx, &storage.MemberProposalSignatureRow{
+		MemberEventID: row.MemberEventID,
+		DeviceID:      row.ActorDeviceID,
+		Signature:     row.Signature,
+	})
+	return err
+}
+
+// handleMemberProposalSign adds an admin's co-signature to a pending
+// proposal. The signature that reaches the vault's quorum appends the event.
+func (s *Server) handleMemberProposalSign(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	memberEventID, err := parseUUID(getPathParam(r, "member_event_id"))
+	if err != nil {
+		apierror.InvalidUUID("member_event_id").WriteJSON(w)
+		return
+	}
+
+	var sig models.MemberProposalSignature
+	if apiErr := parseJSON(r, &sig); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	if apiErr := requireDevice(r, string(sig.DeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	unlock := s.writeLocks.Lock(vaultLockKey(vaultID))
+	defer unlock()
+
+	var applied *storage.MemberEventRow
+	var response models.MemberProposal
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		proposals := s.memberProposals.WithTx(tx)
+		validator := s.membershipValidator.WithTx(tx)
+
+		p, err := proposals.Get(ctx, memberEventID[:])
+		if err != nil {
+			return err
+		}
+		if p == nil || !bytes.Equal(p.VaultID, vaultID) {
+			return apierror.NotFound("member_proposal")
+		}
+		if p.Status != storage.ProposalPending {
+			return apierror.Conflict("member proposal is " + p.Status)
+		}
+
+		event := memberEventFromRow(&p.MemberEventRow)
+		sigRow, apiErr := validator.ValidateProposalSignature(ctx, &event, &sig)
+		if apiErr != nil {
+			return apiErr
+		}
+		added, err := proposals.AddSignature(ctx, sigRow)
+		if err != nil {
+			return err
+		}
+		if !added {
+			return apierror.Conflict("device has already signed this proposal")
+		}
+
+		sigs, err := proposals.ListSignatures(ctx, p.MemberEventID)
+		if err != nil {
+			return err
+		}
+		count, apiErr := validator.CountQuorum(ctx, vaultID, sigs)
+		if apiErr != nil {
+			return apiErr
+		}
+
+		vault, err := s.vaults.WithTx(tx).Get(ctx, vaultID)
+		if err != nil {
+			return err
+		}
+		if vault == nil {
+			return apierror.NotFound("vault")
+		}
+		if vault.DeletedAt != "" {
+			return apierror.VaultDeleted()
+		}
+
+		quorum, err := memberQuorum(ctx, s.vaults.WithTx(tx), vault)
+		if err != nil {
+			return err
+		}
+		if count >= quorum {
+			// Membership may have changed while the proposal was pending, so
+			// the event is checked again as if it had just been submitted.
+			row, apiErr := validateMemberEvent(ctx, validator, &event)
+			if apiErr != nil {
+				return apiErr
+			}
+			if err := s.applyMemberEvent(ctx, tx, row); err != nil {
+				return err
+			}
+			if err := proposals.SetStatus(ctx, row.MemberEventID, storage.ProposalApplied); err != nil {
+				return err
+			}
+			p.Status = storage.ProposalApplied
+			applied = row
+		}
+
+		response = memberProposalFromRow(p, sigs, quorum)
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if applied == nil {
+		writeJSON(w, http.StatusAccepted, response)
+		return
+	}
+
+	s.hub.Publish(vaultID, StreamMessage{
+		Event:     "member_event",
+		MemberSeq: applied.MemberSeq,
+		Data:      memberEventFromRow(applied),
+	})
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (s *Server) handleMemberProposalsList(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	vault, err := s.vaults.Get(ctx, vaultID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if vault == nil {
+		apierror.NotFound("vault").WriteJSON(w)
+		return
+	}
+
+	quorum, err := memberQuorum(ctx, s.vaults, vault)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	pending, err := s.memberProposals.ListPending(ctx, vaultID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := models.MemberProposalsResponse{Proposals: make([]models.MemberProposal, 0, len(pending))}
+	for _, p := range pending {
+		sigs, err := s.memberProposals.ListSignatures(ctx, p.MemberEventID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		response.Proposals = append(response.Proposals, memberProposalFromRow(p, sigs, quorum))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleMemberProposalGet(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	memberEventID, err := parseUUID(getPathParam(r, "member_event_id"))
+	if err != nil {
+		apierror.InvalidUUID("member_event_id").WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	vault, err := s.vaults.Get(ctx, vaultID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if vault == nil {
+		apierror.NotFound("vault").WriteJSON(w)
+		return
+	}
+
+	p, err := s.memberProposals.Get(ctx, memberEventID[:])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if p == nil || !bytes.Equal(p.VaultID, vaultID) {
+		apierror.NotFound("member_proposal").WriteJSON(w)
+		return
+	}
+
+	sigs, err := s.memberProposals.ListSignatures(ctx, p.MemberEventID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	quorum, err := memberQuorum(ctx, s.vaults, vault)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, memberProposalFromRow(p, sigs, quorum))
+}
+
+func memberProposalFromRow(p *storage.MemberProposalRow, sigs []*storage.MemberProposalSignatureRow, quorum uint64) models.MemberProposal {
+	proposal := models.MemberProposal{
+		MemberEvent: memberEventFromRow(&p.MemberEventRow),
+		Status:      p.Status,
+		Quorum:      models.Uint64String(quorum),
+		Signatures:  make([]models.MemberProposalSignature, 0, len(sigs)),
+	}
+	for _, sig := range sigs {
+		proposal.Signatures = append(proposal.Signatures, models.MemberProposalSignature{
+			DeviceID:  models.DeviceID(sig.DeviceID),
+			Signature: sig.Signature,
+			CreatedAt: sig.CreatedAt,
+		})
+	}
+	return proposal
+}
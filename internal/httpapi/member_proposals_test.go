@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"forgor-server/internal/cbe"
+)
+
+func TestQuorumIsCappedAtRemainingAdmins(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, admin, leaving, member := newTestDevice(t), newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, admin, leaving, member} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	for _, d := range []*testDevice{admin, leaving, member} {
+		ts.addMember(v, owner, d)
+	}
+	ts.must(ts.roleChange(v, "member_promote", owner, admin, "admin"), http.StatusCreated, "promote")
+	ts.must(ts.roleChange(v, "member_promote", owner, leaving, "admin"), http.StatusCreated, "promote")
+	ts.must(ts.policy(v, owner, 3), http.StatusCreated, "policy")
+
+	// Leaving is never held back, and takes the third admin away.
+	ts.must(ts.memberLeave(v, leaving), http.StatusCreated, "admin leaves")
+
+	r, sb := ts.memberEvent(v, owner, map[string]any{
+		"msg_type":          "member_remove",
+		"subject_device_id": member.id,
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesMemberRemove(id, v.id[:], seq, prev, owner.idb, member.idb)
+	})
+	proposal := ts.must(r, http.StatusAccepted, "propose removal").json()
+	if proposal["quorum"] != "2" {
+		t.Fatalf("quorum: want 2 (the remaining admins), got %v", proposal["quorum"])
+	}
+
+	id := proposal["member_event"].(map[string]any)["member_event_id"].(string)
+	r = ts.do("POST", v.path("/member_proposals/"+id+"/signatures"), map[string]any{
+		"device_id": admin.id,
+		"signature": b64(admin.sign(sb)),
+	}, admin)
+	ts.must(r, http.StatusCreated, "co-sign")
+	if r.json()["status"] != "applied" {
+		t.Errorf("status: want applied, got %s", r)
+	}
+}
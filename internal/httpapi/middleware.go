@@ -25,6 +25,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+// and extend deadlines on streaming responses.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestID := uuid.New().String()
@@ -160,9 +166,15 @@ func SecurityHeadersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func TimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+// TimeoutMiddleware bounds each request's context; requests matching skip
+// (long-lived streams) manage their own lifetime.
+func TimeoutMiddleware(timeout time.Duration, skip func(*http.Request) bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip != nil && skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			ctx, cancel := context.WithTimeout(r.Context(), timeout)
 			defer cancel()
 			next.ServeHTTP(w, r.WithContext(ctx))
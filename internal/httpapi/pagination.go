@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/logging"
+	"forgor-server/internal/storage"
+)
+
+// pageCursor is the opaque next_cursor handed to clients. Kind ties it to the
+// listing that issued it so it cannot be replayed against another one.
+type pageCursor struct {
+	Kind      string `json:"k"`
+	Seq       uint64 `json:"s,omitempty"`
+	CreatedAt string `json:"t,omitempty"`
+	ID        []byte `json:"i,omitempty"`
+	DeviceID  string `json:"d,omitempty"`
+}
+
+func encodeCursor(kind string, c *storage.Cursor) string {
+	data, _ := json.Marshal(pageCursor{
+		Kind:      kind,
+		Seq:       c.Seq,
+		CreatedAt: c.CreatedAt,
+		ID:        c.ID,
+		DeviceID:  c.DeviceID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(kind, s string) (*storage.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var pc pageCursor
+	if err := json.Unmarshal(data, &pc); err != nil {
+		return nil, err
+	}
+	if pc.Kind != kind {
+		return nil, errors.New("cursor belongs to another listing")
+	}
+	return &storage.Cursor{
+		Seq:       pc.Seq,
+		CreatedAt: pc.CreatedAt,
+		ID:        pc.ID,
+		DeviceID:  pc.DeviceID,
+	}, nil
+}
+
+// parsePage reads the limit and cursor query parameters. The returned page
+// asks the repository for one row more than the page size so the writer can
+// tell whether another page follows.
+func (s *Server) parsePage(r *http.Request, kind string) (storage.Page, *apierror.APIError) {
+	size := s.config.DefaultPageSize
+	if limitStr := getQueryParam(r, "limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return storage.Page{}, apierror.BadRequest("invalid_limit", "limit must be a positive integer")
+		}
+		size = limit
+	}
+	size = min(size, s.config.MaxPageSize)
+
+	page := storage.Page{Limit: size + 1}
+	if cursorStr := getQueryParam(r, "cursor"); cursorStr != "" {
+		after, err := decodeCursor(kind, cursorStr)
+		if err != nil {
+			return storage.Page{}, apierror.BadRequest("invalid_cursor", "cursor is not valid for this listing")
+		}
+		page.After = after
+	}
+	return page, nil
+}
+
+// pageWriter encodes a listing envelope
+// {"items": [...], "has_more": bool, "next_cursor": "..."} as rows arrive
+// from the repository instead of collecting them first.
+type pageWriter struct {
+	w       http.ResponseWriter
+	kind    string
+	size    int
+	count   int
+	hasMore bool
+	next    *storage.Cursor
+	started bool
+}
+
+func newPageWriter(w http.ResponseWriter, kind string, page storage.Page) *pageWriter {
+	return &pageWriter{
+		w:    w,
+		kind: kind,
+		size: page.Limit - 1,
+		next: page.After,
+	}
+}
+
+// write adds one item positioned at cursor. The extra row parsePage asked for
+// is not written; it only sets has_more.
+func (pw *pageWriter) write(item interface{}, cursor storage.Cursor) error {
+	if pw.count == pw.size {
+		pw.hasMore = true
+		return nil
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if !pw.started {
+		pw.start()
+	} else if _, err := pw.w.Write([]byte(",")); err != nil {
+		return err
+	}
+	if _, err := pw.w.Write(data); err != nil {
+		return err
+	}
+
+	pw.count++
+	pw.next = &cursor
+	return nil
+}
+
+func (pw *pageWriter) start() {
+	pw.w.Header().Set("Content-Type", "application/json")
+	pw.w.WriteHeader(http.StatusOK)
+	pw.w.Write([]byte(`{"items":[`))
+	pw.started = true
+}
+
+// finish closes the envelope. next_cursor is the position of the last item
+// (or the request's cursor for an empty page) so feeds can be resumed later
+// even when has_more is false.
+func (pw *pageWriter) finish() {
+	if !pw.started {
+		pw.start()
+	}
+
+	tail := struct {
+		HasMore    bool   `json:"has_more"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}{HasMore: pw.hasMore}
+	if pw.next != nil {
+		tail.NextCursor = encodeCursor(pw.kind, pw.next)
+	}
+
+	data, _ := json.Marshal(tail)
+	pw.w.Write([]byte("],"))
+	pw.w.Write(data[1:])
+}
+
+// fail reports err as a normal error response if nothing was written yet.
+// Otherwise the response is already committed, so it is cut off unterminated
+// and the client sees invalid JSON rather than a short page.
+func (pw *pageWriter) fail(r *http.Request, err error) {
+	if !pw.started {
+		writeError(pw.w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Error("listing failed mid-response", "error", err)
+}
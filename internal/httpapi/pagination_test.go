@@ -0,0 +1,53 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"forgor-server/internal/config"
+)
+
+func TestEventsPageThroughCursor(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.MaxPageSize = 2 })
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+	for i := 0; i < 5; i++ {
+		ts.must(ts.push(v, owner, 1), http.StatusCreated, "push")
+	}
+
+	var seqs []any
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("paging did not finish, got seqs %v", seqs)
+		}
+		path := v.path("/events?limit=100")
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		m := ts.must(ts.do("GET", path, nil, owner), http.StatusOK, "page").json()
+		items, _ := m["items"].([]any)
+		if len(items) > 2 {
+			t.Fatalf("page: want at most MaxPageSize items, got %d", len(items))
+		}
+		for _, it := range items {
+			seqs = append(seqs, it.(map[string]any)["seq"])
+		}
+		if m["has_more"] != true {
+			break
+		}
+		cursor, _ = m["next_cursor"].(string)
+	}
+	if len(seqs) != 5 || seqs[0] != "1" || seqs[4] != "5" {
+		t.Errorf("seqs: want 1 through 5, got %v", seqs)
+	}
+
+	m := ts.must(ts.do("GET", v.path("/events?limit=1"), nil, owner), http.StatusOK, "first page").json()
+	eventsCursor, _ := m["next_cursor"].(string)
+	r := ts.do("GET", v.path("/member_events?cursor="+url.QueryEscape(eventsCursor)), nil, owner)
+	if r.status != http.StatusBadRequest || r.errorCode() != "invalid_cursor" {
+		t.Errorf("cursor from another listing: want 400 invalid_cursor, got %s", r)
+	}
+}
@@ -0,0 +1,166 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"time"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/crypto"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+// handlePairingSlotCreate registers the calling device for PairingSlotTTL
+// under a short code the server generates, replacing any slot it had before.
+// Generating the code means a caller never learns that some code is taken.
+func (s *Server) handlePairingSlotCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.allowPairing(w, r) {
+		return
+	}
+
+	var slot models.PairingSlot
+	if apiErr := parseJSON(r, &slot); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	if apiErr := requireDevice(r, string(slot.DeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	expiresAt := time.Now().Add(s.config.PairingSlotTTL).UTC().Format(time.RFC3339)
+	var code string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		row, apiErr := s.invitesValidator.WithTx(tx).ValidatePairingSlot(ctx, &slot)
+		if apiErr != nil {
+			return apiErr
+		}
+		row.ExpiresAt = expiresAt
+
+		// A live slot may already hold the code; draw another rather than
+		// tell the caller.
+		for attempt := 0; attempt < pairingCodeAttempts; attempt++ {
+			c, err := generatePairingCode()
+			if err != nil {
+				return err
+			}
+			row.CodeHash = crypto.SHA256Hash([]byte(c))
+			created, err := s.pairingSlots.WithTx(tx).Create(ctx, row)
+			if err != nil {
+				return err
+			}
+			if created {
+				code = c
+				return nil
+			}
+		}
+		return errors.New("no free pairing code")
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, models.PairingSlot{
+		DeviceID:  slot.DeviceID,
+		Code:      code,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handlePairingSlotLookup resolves a pairing code to the waiting device's
+// bundle, which the admin then invites as usual. Each code resolves once.
+func (s *Server) handlePairingSlotLookup(w http.ResponseWriter, r *http.Request) {
+	if !s.allowPairing(w, r) {
+		return
+	}
+
+	var lookup models.PairingLookup
+	if apiErr := parseJSON(r, &lookup); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	if apiErr := requireDevice(r, string(lookup.DeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	var device *storage.DeviceRow
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if apiErr := s.invitesValidator.WithTx(tx).ValidatePairingLookup(ctx, &lookup); apiErr != nil {
+			return apiErr
+		}
+
+		slot, err := s.pairingSlots.WithTx(tx).Take(ctx, lookup.CodeHash, string(lookup.DeviceID))
+		if err != nil {
+			return err
+		}
+		if slot == nil {
+			return apierror.NotFound("pairing_slot")
+		}
+
+		device, err = s.devices.WithTx(tx).Get(ctx, slot.DeviceID)
+		if err != nil {
+			return err
+		}
+		if device == nil {
+			return apierror.NotFound("device")
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.DeviceBundle{
+		DeviceID:         models.DeviceID(device.DeviceID),
+		DevicePubkeySign: device.DevicePubkeySign,
+		DevicePubkeyBox:  device.DevicePubkeyBox,
+		DeviceBundleSig:  device.DeviceBundleSig,
+	})
+}
+
+// pairingCodeAttempts bounds how many codes slot creation draws before
+// giving up; with the code space this large, more than one is rare.
+const pairingCodeAttempts = 5
+
+// allowPairing limits pairing requests per client IP and per device far more
+// tightly than other requests, since short codes can be guessed. Slot
+// creation and lookups share the budget.
+func (s *Server) allowPairing(w http.ResponseWriter, r *http.Request) bool {
+	ip := getClientIP(r)
+	deviceID := authenticatedDeviceID(r.Context())
+	if !s.pairingLimiter.getLimiter(ip).Allow() || !s.pairingLimiter.getLimiter("device:"+deviceID).Allow() {
+		slog.Warn("pairing rate limit exceeded", "ip", ip, "device_id", deviceID)
+		apierror.TooManyRequests("pairing rate limit exceeded").WriteJSON(w)
+		return false
+	}
+	return true
+}
+
+// generatePairingCode draws a uniformly random code from
+// PairingCodeAlphabet, which leaves out characters that are easy to confuse.
+func generatePairingCode() (string, error) {
+	alphabet := big.NewInt(int64(len(models.PairingCodeAlphabet)))
+	code := make([]byte, models.PairingCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabet)
+		if err != nil {
+			return "", err
+		}
+		code[i] = models.PairingCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"forgor-server/internal/crypto"
+	"forgor-server/internal/models"
+)
+
+func TestPairingCodeIsGeneratedAndResolvesOnce(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, joiner := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(joiner)
+	v := ts.genesis(owner)
+
+	r := ts.must(ts.do("POST", "/v1/pairing_slots", map[string]any{"device_id": joiner.id}, joiner), http.StatusCreated, "create slot")
+	code, _ := r.json()["code"].(string)
+	if len(code) != models.PairingCodeLength || strings.Trim(code, models.PairingCodeAlphabet) != "" {
+		t.Fatalf("code: want %d characters of the pairing alphabet, got %q", models.PairingCodeLength, code)
+	}
+	if _, ok := r.json()["code_hash"]; ok {
+		t.Errorf("slot response: want no code_hash, got %s", r)
+	}
+	hash := crypto.SHA256Hash([]byte(code))
+	if n := ts.count("SELECT COUNT(*) FROM pairing_slots WHERE code_hash = ? AND device_id = ?", hash, joiner.id); n != 1 {
+		t.Fatalf("pairing slots: want the code's hash stored for the device, got %d", n)
+	}
+
+	lookup := func() testResponse {
+		t.Helper()
+		return ts.do("POST", "/v1/pairing_slots/lookup", map[string]any{
+			"vault_id":  v.id.String(),
+			"device_id": owner.id,
+			"code_hash": b64(hash),
+		}, owner)
+	}
+	r = ts.must(lookup(), http.StatusOK, "lookup")
+	if r.json()["device_id"] != joiner.id {
+		t.Errorf("lookup: want the joiner's bundle, got %s", r)
+	}
+	if r := lookup(); r.status != http.StatusNotFound {
+		t.Errorf("second lookup: want 404, got %s", r)
+	}
+}
+
+func TestPairingSlotCreationIsRateLimited(t *testing.T) {
+	ts := newTestServer(t, nil)
+	joiner := newTestDevice(t)
+	ts.register(joiner)
+
+	codes := map[string]bool{}
+	for i := 0; i < ts.cfg.PairingLookupBurst; i++ {
+		r := ts.must(ts.do("POST", "/v1/pairing_slots", map[string]any{"device_id": joiner.id}, joiner), http.StatusCreated, "create slot")
+		code, _ := r.json()["code"].(string)
+		codes[code] = true
+	}
+	if len(codes) != ts.cfg.PairingLookupBurst {
+		t.Errorf("codes: want a fresh code per slot, got %v", codes)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM pairing_slots WHERE device_id = ?", joiner.id); n != 1 {
+		t.Errorf("pairing slots: want only the latest, got %d", n)
+	}
+
+	r := ts.do("POST", "/v1/pairing_slots", map[string]any{"device_id": joiner.id}, joiner)
+	if r.status != http.StatusTooManyRequests || r.errorCode() != "rate_limit_exceeded" {
+		t.Errorf("slot past the burst: want 429 rate_limit_exceeded, got %s", r)
+	}
+}
@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMemberAddRollsBackWhenInviteUpdateFails(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, joiner := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(joiner)
+
+	v := ts.genesis(owner)
+	inviteID := ts.invite(v, owner, joiner)
+	claimSig := ts.claim(v, inviteID, joiner)
+
+	// MarkUsed is the last write of a member_add, after the head CAS, the
+	// member_events insert and the vault_members upsert.
+	restore := ts.failWrites("invites", "UPDATE")
+	r := ts.memberAdd(v, owner, joiner, inviteID, claimSig)
+	restore()
+	ts.must(r, http.StatusInternalServerError, "member_add with failing invite update")
+
+	if n := ts.count("SELECT COUNT(*) FROM member_events WHERE vault_id = ?", v.id[:]); n != 1 {
+		t.Errorf("member_events: want 1 (genesis), got %d", n)
+	}
+	if n := ts.count("SELECT member_seq FROM vault_membership_heads WHERE vault_id = ?", v.id[:]); n != 1 {
+		t.Errorf("membership head seq: want 1, got %d", n)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM vault_membership_heads WHERE vault_id = ? AND member_head_hash = ?", v.id[:], v.head); n != 1 {
+		t.Errorf("membership head hash moved")
+	}
+	if n := ts.count("SELECT COUNT(*) FROM vault_members WHERE vault_id = ? AND device_id = ?", v.id[:], joiner.id); n != 0 {
+		t.Errorf("vault_members: joiner row persisted")
+	}
+	if n := ts.count("SELECT use_count FROM invites WHERE invite_id = ?", inviteID[:]); n != 0 {
+		t.Errorf("invites.use_count: want 0, got %d", n)
+	}
+
+	// The same member_add goes through once the failure is gone.
+	ts.must(ts.memberAdd(v, owner, joiner, inviteID, claimSig), http.StatusCreated, "member_add retry")
+	if n := ts.count("SELECT use_count FROM invites WHERE invite_id = ?", inviteID[:]); n != 1 {
+		t.Errorf("invites.use_count after retry: want 1, got %d", n)
+	}
+}
+
+func TestGenesisRollsBackWhenMemberUpsertFails(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+
+	restore := ts.failWrites("vault_members", "INSERT")
+	v, r := ts.tryGenesis(owner)
+	restore()
+	ts.must(r, http.StatusInternalServerError, "genesis with failing member upsert")
+
+	for _, table := range []string{"vaults", "vault_membership_heads", "member_events", "vault_key_epochs"} {
+		if n := ts.count("SELECT COUNT(*) FROM "+table+" WHERE vault_id = ?", v.id[:]); n != 0 {
+			t.Errorf("%s: want 0 rows, got %d", table, n)
+		}
+	}
+
+	ts.genesis(owner)
+}
+
+func TestEventCreateRollsBackWhenInsertFails(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := newTestDevice(t)
+	ts.register(owner)
+	v := ts.genesis(owner)
+
+	ts.must(ts.push(v, owner, 1), http.StatusCreated, "first event")
+
+	// The event head is advanced before the events row is inserted.
+	restore := ts.failWrites("events", "INSERT")
+	r := ts.push(v, owner, 1)
+	restore()
+	ts.must(r, http.StatusInternalServerError, "event with failing insert")
+
+	if n := ts.count("SELECT COUNT(*) FROM events WHERE vault_id = ?", v.id[:]); n != 1 {
+		t.Errorf("events: want 1, got %d", n)
+	}
+	if n := ts.count("SELECT last_counter FROM event_heads WHERE vault_id = ? AND device_id = ?", v.id[:], owner.id); n != 1 {
+		t.Errorf("event head counter: want 1, got %d", n)
+	}
+	if n := ts.count("SELECT COUNT(*) FROM event_heads WHERE vault_id = ? AND device_id = ? AND last_hash = ?", v.id[:], owner.id, v.heads[owner.id]); n != 1 {
+		t.Errorf("event head hash moved")
+	}
+
+	ts.must(ts.push(v, owner, 1), http.StatusCreated, "event retry")
+}
@@ -1,6 +1,7 @@
 package httpapi
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"time"
@@ -11,6 +12,10 @@ import (
 	"forgor-server/internal/validation"
 )
 
+// requestTimeout bounds every request, including long polls. Streams are
+// bounded by StreamMaxDuration instead.
+const requestTimeout = 30 * time.Second
+
 type Server struct {
 	db     *db.DB
 	config *config.Config
@@ -22,6 +27,11 @@ type Server struct {
 	events       *storage.EventsRepository
 	keyUpdates   *storage.KeyUpdatesRepository
 	snapshots    *storage.SnapshotsRepository
+	auth         *storage.AuthRepository
+
+	equivocations   *storage.EquivocationsRepository
+	memberProposals *storage.MemberProposalsRepository
+	pairingSlots    *storage.PairingSlotsRepository
 
 	deviceValidator     *validation.DeviceValidator
 	membershipValidator *validation.MembershipValidator
@@ -30,7 +40,11 @@ type Server struct {
 	keyUpdatesValidator *validation.KeyUpdatesValidator
 	snapshotsValidator  *validation.SnapshotsValidator
 
-	rateLimiter *IPRateLimiter
+	rateLimiter     *IPRateLimiter
+	pairingLimiter  *IPRateLimiter
+	requestVerifier *RequestVerifier
+	writeLocks      *KeyedMutex
+	hub             *Hub
 }
 
 func NewServer(database *db.DB, cfg *config.Config) *Server {
@@ -41,8 +55,9 @@ func NewServer(database *db.DB, cfg *config.Config) *Server {
 	events := storage.NewEventsRepository(database)
 	keyUpdates := storage.NewKeyUpdatesRepository(database)
 	snapshots := storage.NewSnapshotsRepository(database)
+	auth := storage.NewAuthRepository(database)
 
-	return &Server{
+	s := &Server{
 		db:     database,
 		config: cfg,
 
@@ -53,15 +68,51 @@ func NewServer(database *db.DB, cfg *config.Config) *Server {
 		events:       events,
 		keyUpdates:   keyUpdates,
 		snapshots:    snapshots,
+		auth:         auth,
+
+		equivocations:   storage.NewEquivocationsRepository(database),
+		memberProposals: storage.NewMemberProposalsRepository(database),
+		pairingSlots:    storage.NewPairingSlotsRepository(database),
 
 		deviceValidator:     validation.NewDeviceValidator(devices),
-		membershipValidator: validation.NewMembershipValidator(vaults, memberEvents, invites, devices),
-		invitesValidator:    validation.NewInvitesValidator(vaults, invites, devices),
-		eventsValidator:     validation.NewEventsValidator(vaults, events),
+		membershipValidator: validation.NewMembershipValidator(vaults, memberEvents, invites, devices, cfg.InviteMaxLifetime),
+		invitesValidator:    validation.NewInvitesValidator(vaults, invites, devices, cfg.InviteMaxLifetime),
+		eventsValidator:     validation.NewEventsValidator(vaults, events, keyUpdates),
 		keyUpdatesValidator: validation.NewKeyUpdatesValidator(vaults, keyUpdates, invites),
-		snapshotsValidator:  validation.NewSnapshotsValidator(vaults, snapshots, invites),
+		snapshotsValidator:  validation.NewSnapshotsValidator(vaults, snapshots, invites, keyUpdates),
+
+		rateLimiter:     NewIPRateLimiter(cfg.RateLimitRequestsPerSecond, cfg.RateLimitBurst),
+		pairingLimiter:  NewIPRateLimiter(cfg.PairingLookupRPS, cfg.PairingLookupBurst),
+		requestVerifier: NewRequestVerifier(devices, auth, cfg.RequestMaxClockSkew),
+		writeLocks:      NewKeyedMutex(),
+		hub:             NewHub(cfg.StreamBufferSize),
+	}
+
+	go s.runEvery(cfg.VaultPurgeInterval, s.purgeDeletedVaults)
+	go s.runEvery(cfg.InviteSweepInterval, s.sweepExpiredInvites)
+
+	return s
+}
+
+// Close releases long-polling and streaming requests so graceful shutdown
+// does not wait for them to time out.
+func (s *Server) Close() {
+	s.hub.Close()
+}
 
-		rateLimiter: NewIPRateLimiter(cfg.RateLimitRequestsPerSecond, cfg.RateLimitBurst),
+// runEvery calls fn every interval until the server is closed. A
+// non-positive interval disables it.
+func (s *Server) runEvery(interval time.Duration, fn func(context.Context)) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.hub.Closed() {
+			return
+		}
+		fn(context.Background())
 	}
 }
 
@@ -71,26 +122,46 @@ func (s *Server) Handler() http.Handler {
 	mux.HandleFunc("GET /health", s.handleHealth)
 
 	mux.HandleFunc("POST /v1/devices/register", s.handleDeviceRegister)
-	mux.HandleFunc("GET /v1/devices/{device_id}", s.handleDeviceGet)
-
-	mux.HandleFunc("POST /v1/vaults/{vault_id}/invites", s.handleInviteCreate)
-	mux.HandleFunc("GET /v1/invites", s.handleInvitesList)
-	mux.HandleFunc("POST /v1/invites/{invite_id}/claim", s.handleInviteClaim)
-	mux.HandleFunc("GET /v1/invite_claims", s.handleInviteClaimsList)
-
-	mux.HandleFunc("POST /v1/vaults/{vault_id}/member_events", s.handleMemberEventCreate)
-	mux.HandleFunc("GET /v1/vaults/{vault_id}/member_events", s.handleMemberEventsList)
-	mux.HandleFunc("GET /v1/vaults/{vault_id}/members", s.handleVaultMembersList)
-
-	mux.HandleFunc("POST /v1/vaults/{vault_id}/events", s.handleEventCreate)
-	mux.HandleFunc("GET /v1/vaults/{vault_id}/events", s.handleEventsList)
-
-	mux.HandleFunc("POST /v1/vaults/{vault_id}/key_updates", s.handleKeyUpdateCreate)
-	mux.HandleFunc("GET /v1/key_updates", s.handleKeyUpdatesList)
-	mux.HandleFunc("POST /v1/vaults/{vault_id}/key_update_acks", s.handleKeyUpdateAck)
-
-	mux.HandleFunc("POST /v1/vaults/{vault_id}/snapshots", s.handleSnapshotCreate)
-	mux.HandleFunc("GET /v1/vaults/{vault_id}/snapshots/latest", s.handleSnapshotLatest)
+	mux.Handle("GET /v1/devices/{device_id}", s.authenticated(s.handleDeviceGet))
+
+	mux.HandleFunc("POST /v1/auth/challenge", s.handleAuthChallenge)
+	mux.HandleFunc("POST /v1/auth/session", s.handleAuthSessionCreate)
+	mux.Handle("DELETE /v1/auth/session", s.authenticated(s.handleAuthSessionRevoke))
+	mux.Handle("DELETE /v1/auth/sessions", s.authenticated(s.handleAuthSessionsRevokeAll))
+
+	mux.Handle("POST /v1/vaults/{vault_id}/invites", s.authenticated(s.handleInviteCreate))
+	mux.Handle("GET /v1/vaults/{vault_id}/invites", s.vaultReader(s.handleVaultInvitesList))
+	mux.Handle("GET /v1/invites", s.authenticated(s.handleInvitesList))
+	mux.Handle("GET /v1/invites/{invite_id}", s.authenticated(s.handleInviteGet))
+	mux.Handle("POST /v1/invites/{invite_id}/claim", s.authenticated(s.handleInviteClaim))
+	mux.Handle("POST /v1/invites/{invite_id}/revoke", s.authenticated(s.handleInviteRevoke))
+	mux.Handle("GET /v1/invite_claims", s.authenticated(s.handleInviteClaimsList))
+	mux.Handle("POST /v1/pairing_slots", s.authenticated(s.handlePairingSlotCreate))
+	mux.Handle("POST /v1/pairing_slots/lookup", s.authenticated(s.handlePairingSlotLookup))
+
+	mux.Handle("POST /v1/vaults/{vault_id}/member_events", s.authenticated(s.handleMemberEventCreate))
+	mux.Handle("GET /v1/vaults/{vault_id}/member_events", s.vaultReader(s.handleMemberEventsList))
+	mux.Handle("GET /v1/vaults/{vault_id}/members", s.vaultReader(s.handleVaultMembersList))
+	mux.Handle("POST /v1/vaults/{vault_id}/delete", s.authenticated(s.handleVaultDelete))
+	mux.Handle("GET /v1/vaults/{vault_id}/member_proposals", s.vaultReader(s.handleMemberProposalsList))
+	mux.Handle("GET /v1/vaults/{vault_id}/member_proposals/{member_event_id}", s.vaultReader(s.handleMemberProposalGet))
+	mux.Handle("POST /v1/vaults/{vault_id}/member_proposals/{member_event_id}/signatures", s.authenticated(s.handleMemberProposalSign))
+
+	mux.Handle("POST /v1/vaults/{vault_id}/events", s.authenticated(s.handleEventCreate))
+	mux.Handle("POST /v1/vaults/{vault_id}/events:batch", s.authenticated(s.handleEventBatchCreate))
+	mux.Handle("GET /v1/vaults/{vault_id}/events", s.vaultReader(s.handleEventsList))
+	mux.Handle("GET /v1/vaults/{vault_id}/stream", s.vaultReader(s.handleVaultStream))
+	mux.Handle("GET /v1/vaults/{vault_id}/equivocations", s.vaultReader(s.handleEquivocationsList))
+
+	mux.Handle("POST /v1/vaults/{vault_id}/key_updates", s.authenticated(s.handleKeyUpdateCreate))
+	mux.Handle("POST /v1/vaults/{vault_id}/key_updates:batch", s.authenticated(s.handleKeyUpdateBatchCreate))
+	mux.Handle("GET /v1/key_updates", s.authenticated(s.handleKeyUpdatesList))
+	mux.Handle("POST /v1/vaults/{vault_id}/key_update_acks", s.authenticated(s.handleKeyUpdateAck))
+	mux.Handle("GET /v1/vaults/{vault_id}/rotation", s.vaultReader(s.handleRotationStatus))
+	mux.Handle("GET /v1/vaults/{vault_id}/key_epochs/{epoch}", s.vaultReader(s.handleKeyEpochStatus))
+
+	mux.Handle("POST /v1/vaults/{vault_id}/snapshots", s.authenticated(s.handleSnapshotCreate))
+	mux.Handle("GET /v1/vaults/{vault_id}/snapshots/latest", s.vaultReader(s.handleSnapshotLatest))
 
 	handler := Chain(mux,
 		RecoveryMiddleware,
@@ -99,12 +170,22 @@ func (s *Server) Handler() http.Handler {
 		SecurityHeadersMiddleware,
 		MaxBodySizeMiddleware(s.config.MaxRequestBodySize),
 		RateLimitMiddleware(s.rateLimiter),
-		TimeoutMiddleware(30*time.Second),
+		TimeoutMiddleware(requestTimeout, isStreamRequest),
 	)
 
 	return handler
 }
 
+// authenticated wraps writes and device-scoped reads; handlers still check
+// that the device named in the body or query is the authenticated one.
+func (s *Server) authenticated(h http.HandlerFunc) http.Handler {
+	return Chain(h, RequestAuthMiddleware(s.requestVerifier))
+}
+
+func (s *Server) vaultReader(h http.HandlerFunc) http.Handler {
+	return Chain(h, RequestAuthMiddleware(s.requestVerifier), VaultMemberMiddleware(s.vaults))
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"ok"}`))
@@ -0,0 +1,186 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/cbe"
+	"forgor-server/internal/crypto"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+func (s *Server) handleAuthChallenge(w http.ResponseWriter, r *http.Request) {
+	var req models.AuthChallengeRequest
+	if apiErr := parseJSON(r, &req); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	if err := req.DeviceID.Validate(); err != nil {
+		apierror.InvalidDeviceID().WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	exists, err := s.devices.Exists(ctx, string(req.DeviceID))
+	if err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+	if !exists {
+		apierror.NotFound("device").WriteJSON(w)
+		return
+	}
+
+	challenge := make([]byte, models.ChallengeLength)
+	if _, err := rand.Read(challenge); err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
+	row := &storage.AuthChallengeRow{
+		Challenge: challenge,
+		DeviceID:  string(req.DeviceID),
+		ExpiresAt: time.Now().UTC().Add(s.config.AuthChallengeTTL).Format(time.RFC3339),
+	}
+	if err := s.auth.CreateChallenge(ctx, row); err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
+	response := models.AuthChallenge{
+		DeviceID:  req.DeviceID,
+		Challenge: challenge,
+		ExpiresAt: row.ExpiresAt,
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (s *Server) handleAuthSessionCreate(w http.ResponseWriter, r *http.Request) {
+	var req models.AuthSessionRequest
+	if apiErr := parseJSON(r, &req); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	if req.MsgType != "auth_challenge" {
+		apierror.BadRequest("invalid_msg_type", "expected 'auth_challenge'").WriteJSON(w)
+		return
+	}
+	if err := req.DeviceID.Validate(); err != nil {
+		apierror.InvalidDeviceID().WriteJSON(w)
+		return
+	}
+	if len(req.Challenge) != models.ChallengeLength {
+		apierror.BadRequest("invalid_challenge", "challenge must be 32 bytes").WriteJSON(w)
+		return
+	}
+	if len(req.Signature) != models.SignatureLength {
+		apierror.InvalidSignature().WriteJSON(w)
+		return
+	}
+
+	deviceIDBytes, err := crypto.DeviceIDToBytes(string(req.DeviceID))
+	if err != nil {
+		apierror.InvalidDeviceID().WriteJSON(w)
+		return
+	}
+
+	signBytes, err := cbe.SignBytesAuthChallenge(deviceIDBytes, req.Challenge)
+	if err != nil {
+		apierror.BadRequest("sign_bytes_error", err.Error()).WriteJSON(w)
+		return
+	}
+
+	rawToken := make([]byte, models.TokenLength)
+	if _, err := rand.Read(rawToken); err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
+	session := &storage.AuthSessionRow{
+		TokenHash: crypto.SHA256Hash(rawToken),
+		DeviceID:  string(req.DeviceID),
+		ExpiresAt: time.Now().UTC().Add(s.config.SessionTTL).Format(time.RFC3339),
+	}
+
+	ctx := r.Context()
+
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		auth := s.auth.WithTx(tx)
+
+		challenge, err := auth.ConsumeChallenge(ctx, req.Challenge)
+		if err != nil {
+			return err
+		}
+		if challenge == nil || challenge.DeviceID != string(req.DeviceID) {
+			return apierror.InvalidChallenge()
+		}
+		expiresAt, err := time.Parse(time.RFC3339, challenge.ExpiresAt)
+		if err != nil || time.Now().After(expiresAt) {
+			return apierror.InvalidChallenge()
+		}
+
+		device, err := s.devices.WithTx(tx).Get(ctx, string(req.DeviceID))
+		if err != nil {
+			return err
+		}
+		if device == nil {
+			return apierror.UnknownDevice()
+		}
+
+		if err := crypto.VerifySignature(device.DevicePubkeySign, signBytes, req.Signature); err != nil {
+			return apierror.InvalidSignature()
+		}
+
+		return auth.CreateSession(ctx, session)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := models.AuthSession{
+		DeviceID:  req.DeviceID,
+		Token:     base64.RawURLEncoding.EncodeToString(rawToken),
+		ExpiresAt: session.ExpiresAt,
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (s *Server) handleAuthSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		apierror.BadRequest("missing_session", "a bearer session token is required").WriteJSON(w)
+		return
+	}
+
+	tokenHash, err := hashSessionToken(token)
+	if err != nil {
+		apierror.InvalidSession().WriteJSON(w)
+		return
+	}
+
+	if err := s.auth.RevokeSession(r.Context(), tokenHash); err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAuthSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	if err := s.auth.RevokeDeviceSessions(r.Context(), authenticatedDeviceID(r.Context())); err != nil {
+		apierror.InternalError().WriteJSON(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
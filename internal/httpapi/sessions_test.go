@@ -0,0 +1,59 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"forgor-server/internal/cbe"
+)
+
+func TestSessionTokenLifecycle(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, other := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(other)
+	v := ts.genesis(owner)
+
+	session := func(d, signer *testDevice) testResponse {
+		t.Helper()
+		r := ts.must(ts.do("POST", "/v1/auth/challenge", map[string]any{"device_id": d.id}, nil), http.StatusCreated, "challenge")
+		challenge, _ := r.json()["challenge"].(string)
+		raw := decodeB64(t, challenge)
+		sb, err := cbe.SignBytesAuthChallenge(d.idb, raw)
+		if err != nil {
+			t.Fatalf("challenge sign bytes: %v", err)
+		}
+		body := map[string]any{
+			"msg_type":  "auth_challenge",
+			"device_id": d.id,
+			"challenge": challenge,
+			"signature": b64(signer.sign(sb)),
+		}
+		r = ts.do("POST", "/v1/auth/session", body, nil)
+		if r.status == http.StatusCreated {
+			if again := ts.do("POST", "/v1/auth/session", body, nil); again.status != http.StatusUnauthorized {
+				t.Errorf("reused challenge: want 401, got %s", again)
+			}
+		}
+		return r
+	}
+	bearer := func(method, path, token string) testResponse {
+		t.Helper()
+		req := ts.newRequest(method, path, nil, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return ts.send(req)
+	}
+
+	if r := session(owner, other); r.status == http.StatusCreated {
+		t.Fatalf("challenge signed by another device: want rejected, got %s", r)
+	}
+
+	token, _ := ts.must(session(owner, owner), http.StatusCreated, "session").json()["token"].(string)
+	ts.must(bearer("GET", v.path("/events"), token), http.StatusOK, "read with token")
+	ts.must(bearer("GET", "/v1/invites?device_id="+other.id, token), http.StatusForbidden, "token used for another device")
+
+	ts.must(bearer("DELETE", "/v1/auth/session", token), http.StatusNoContent, "revoke session")
+	if r := bearer("GET", v.path("/events"), token); r.status != http.StatusUnauthorized || r.errorCode() != "invalid_session" {
+		t.Errorf("revoked token: want 401 invalid_session, got %s", r)
+	}
+}
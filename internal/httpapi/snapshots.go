@@ -2,10 +2,12 @@ package httpapi
 
 import (
 	"bytes"
+	"database/sql"
 	"net/http"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
 )
 
 func (s *Server) handleSnapshotCreate(w http.ResponseWriter, r *http.Request) {
@@ -26,27 +28,53 @@ func (s *Server) handleSnapshotCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
-
-	row, apiErr := s.snapshotsValidator.ValidateSnapshot(ctx, &snapshot)
-	if apiErr != nil {
+	if apiErr := requireDevice(r, string(snapshot.CreatedByDeviceID)); apiErr != nil {
 		apiErr.WriteJSON(w)
 		return
 	}
 
-	if err := s.invites.RecordNonceUsed(ctx, "snapshot", vaultID, string(snapshot.CreatedByDeviceID), snapshot.Nonce); err != nil {
-		apierror.InternalError().WriteJSON(w)
-		return
-	}
+	ctx := r.Context()
 
-	if err := s.snapshots.Create(ctx, row); err != nil {
-		apierror.InternalError().WriteJSON(w)
+	unlock := s.writeLocks.Lock(vaultLockKey(vaultID))
+	defer unlock()
+
+	var created *storage.SnapshotRow
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		row, apiErr := s.snapshotsValidator.WithTx(tx).ValidateSnapshot(ctx, &snapshot)
+		if apiErr != nil {
+			return apiErr
+		}
+		created = row
+
+		if err := s.invites.WithTx(tx).RecordNonceUsed(ctx, "snapshot", vaultID, string(snapshot.CreatedByDeviceID), snapshot.Nonce); err != nil {
+			return err
+		}
+
+		snapshots := s.snapshots.WithTx(tx)
+		if err := snapshots.Create(ctx, row); err != nil {
+			return err
+		}
+		return snapshots.PruneOld(ctx, vaultID, 3)
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	go func() {
-		_ = s.snapshots.PruneOld(ctx, vaultID, 3)
-	}()
+	// Subscribers only get the metadata; the ciphertext can be large and is
+	// fetched from snapshots/latest when a client actually wants it.
+	s.hub.Publish(vaultID, StreamMessage{
+		Event: "snapshot",
+		Data: models.SnapshotInfo{
+			SnapshotID:        bytesToUUID(created.SnapshotID),
+			VaultID:           bytesToUUID(created.VaultID),
+			BaseSeq:           models.Uint64String(created.BaseSeq),
+			MemberSeq:         models.Uint64String(created.MemberSeq),
+			KeyEpoch:          models.Uint64String(created.KeyEpoch),
+			CreatedByDeviceID: models.DeviceID(created.CreatedByDeviceID),
+			CreatedAt:         created.CreatedAt,
+		},
+	})
 
 	writeJSON(w, http.StatusCreated, snapshot)
 }
@@ -0,0 +1,216 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/logging"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+func isStreamRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/vaults/") && strings.HasSuffix(r.URL.Path, "/stream")
+}
+
+// handleVaultStream pushes vault activity as Server-Sent Events. Every message
+// id is "<seq>-<member_seq>", the positions in the events feed and membership
+// log the client has seen; sending it back as Last-Event-ID replays whatever
+// was committed since before going live. Key updates are only sent to the
+// device they target and are not replayed (GET /v1/key_updates has them).
+func (s *Server) handleVaultStream(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	seq, memberSeq, err := parseStreamCursor(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		apierror.BadRequest("invalid_last_event_id", "Last-Event-ID must be <seq>-<member_seq>").WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+	deviceID := authenticatedDeviceID(ctx)
+
+	sub := s.hub.Subscribe(vaultID, deviceID)
+	defer s.hub.Unsubscribe(vaultID, sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &sseStream{
+		w:            w,
+		rc:           http.NewResponseController(w),
+		writeTimeout: s.config.WriteTimeout,
+		seq:          seq,
+		memberSeq:    memberSeq,
+	}
+
+	logger := logging.FromContext(ctx)
+
+	if err := s.replayStream(ctx, vaultID, stream); err != nil {
+		logger.Info("stream replay aborted", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(s.config.StreamHeartbeat)
+	defer heartbeat.Stop()
+	expiry := time.NewTimer(s.config.StreamMaxDuration)
+	defer expiry.Stop()
+
+	for {
+		select {
+		case msg := <-sub.C:
+			// Anything committed while the replay ran shows up both in the
+			// replay and on the channel.
+			if msg.Seq != 0 && msg.Seq <= stream.seq {
+				continue
+			}
+			if msg.MemberSeq != 0 && msg.MemberSeq <= stream.memberSeq {
+				continue
+			}
+			if err := stream.send(msg); err != nil {
+				return
+			}
+			if err := stream.flush(); err != nil {
+				return
+			}
+			if msg.Event == "vault_deleted" || removesDevice(msg, deviceID) {
+				return
+			}
+		case <-heartbeat.C:
+			if err := stream.comment("heartbeat"); err != nil {
+				return
+			}
+		case <-sub.Done():
+			if !s.hub.Closed() {
+				logger.Info("dropping slow stream subscriber", "device_id", deviceID)
+			}
+			return
+		case <-expiry.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replayStream sends what was committed after the stream's cursor, reading
+// at most MaxPageSize rows per query and flushing each page before the next,
+// so a large backlog neither pins one long read nor piles up unflushed.
+func (s *Server) replayStream(ctx context.Context, vaultID []byte, stream *sseStream) error {
+	limit := s.config.MaxPageSize
+	for {
+		n := 0
+		err := s.memberEvents.ListSince(ctx, vaultID, storage.Page{After: &storage.Cursor{Seq: stream.memberSeq}, Limit: limit}, func(e *storage.MemberEventRow) error {
+			n++
+			return stream.send(StreamMessage{Event: "member_event", MemberSeq: e.MemberSeq, Data: memberEventFromRow(e)})
+		})
+		if err != nil {
+			return err
+		}
+		if err := stream.flush(); err != nil {
+			return err
+		}
+		if limit <= 0 || n < limit {
+			break
+		}
+	}
+	for {
+		n := 0
+		err := s.events.ListSince(ctx, vaultID, storage.Page{After: &storage.Cursor{Seq: stream.seq}, Limit: limit}, func(e *storage.EventRow) error {
+			n++
+			return stream.send(StreamMessage{Event: "event", Seq: e.Seq, Data: eventFromRow(e)})
+		})
+		if err != nil {
+			return err
+		}
+		if err := stream.flush(); err != nil {
+			return err
+		}
+		if limit <= 0 || n < limit {
+			return nil
+		}
+	}
+}
+
+// removesDevice reports whether msg ends deviceID's membership, after which
+// its stream must not receive anything further.
+func removesDevice(msg StreamMessage, deviceID string) bool {
+	me, ok := msg.Data.(models.MemberEvent)
+	return ok && (me.MsgType == "member_remove" || me.MsgType == "member_leave") && string(me.SubjectDeviceID) == deviceID
+}
+
+func parseStreamCursor(id string) (uint64, uint64, error) {
+	if id == "" {
+		return 0, 0, nil
+	}
+	seqStr, memberSeqStr, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, errors.New("missing separator")
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	memberSeq, err := strconv.ParseUint(memberSeqStr, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return seq, memberSeq, nil
+}
+
+type sseStream struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+	seq          uint64
+	memberSeq    uint64
+}
+
+func (st *sseStream) send(msg StreamMessage) error {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+	if msg.Seq > st.seq {
+		st.seq = msg.Seq
+	}
+	if msg.MemberSeq > st.memberSeq {
+		st.memberSeq = msg.MemberSeq
+	}
+
+	st.extendDeadline()
+	_, err = fmt.Fprintf(st.w, "id: %d-%d\nevent: %s\ndata: %s\n\n", st.seq, st.memberSeq, msg.Event, data)
+	return err
+}
+
+func (st *sseStream) comment(text string) error {
+	st.extendDeadline()
+	if _, err := fmt.Fprintf(st.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return st.flush()
+}
+
+func (st *sseStream) flush() error {
+	return st.rc.Flush()
+}
+
+// extendDeadline gives each write its own WriteTimeout window instead of
+// letting the server's per-response deadline cut a long-lived stream. Writers
+// that cannot set deadlines just keep the server's.
+func (st *sseStream) extendDeadline() {
+	_ = st.rc.SetWriteDeadline(time.Now().Add(st.writeTimeout))
+}
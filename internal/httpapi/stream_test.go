@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"forgor-server/internal/config"
+)
+
+// readStream opens the vault stream and collects the ids and types of the
+// first n messages.
+func (ts *testServer) readStream(v *testVault, d *testDevice, lastEventID string, n int) (ids, events []string) {
+	ts.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := ts.newRequest("GET", v.path("/stream"), nil, d).WithContext(ctx)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		ts.t.Fatalf("open stream: status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+		if event, ok := strings.CutPrefix(line, "event: "); ok {
+			events = append(events, event)
+		}
+	}
+	if len(events) < n {
+		ts.t.Fatalf("stream ended after %d of %d messages: %v", len(events), n, scanner.Err())
+	}
+	return ids, events
+}
+
+func TestStreamReplaysBacklogInPages(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.MaxPageSize = 2 })
+	owner, member := newTestDevice(t), newTestDevice(t)
+	ts.register(owner)
+	ts.register(member)
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, member)
+	for i := 0; i < 5; i++ {
+		ts.must(ts.push(v, owner, 1), http.StatusCreated, "push event")
+	}
+
+	// 2 member events and 5 events, each spanning more than one page.
+	ids, events := ts.readStream(v, owner, "", 7)
+	want := []string{"member_event", "member_event", "event", "event", "event", "event", "event"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Fatalf("replay: want %v, got %v", want, events)
+	}
+	if ids[len(ids)-1] != "5-2" {
+		t.Errorf("last id: want 5-2, got %s", ids[len(ids)-1])
+	}
+
+	// Resuming mid-backlog replays only the rest.
+	ids, _ = ts.readStream(v, owner, "3-2", 2)
+	if ids[0] != "4-2" || ids[1] != "5-2" {
+		t.Errorf("resume: want [4-2 5-2], got %v", ids)
+	}
+}
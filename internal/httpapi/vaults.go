@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/models"
+)
+
+// handleVaultDelete tombstones a vault on the owner's signed request. Every
+// later request for it gets 410 Gone; its data is purged by
+// purgeDeletedVaults once the grace period has passed.
+func (s *Server) handleVaultDelete(w http.ResponseWriter, r *http.Request) {
+	vaultID, err := extractVaultID(r)
+	if err != nil {
+		apierror.InvalidUUID("vault_id").WriteJSON(w)
+		return
+	}
+
+	var del models.VaultDelete
+	if apiErr := parseJSON(r, &del); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	if !bytes.Equal(vaultID, del.VaultID.Bytes()) {
+		apierror.BadRequest("vault_id_mismatch", "vault_id in path does not match body").WriteJSON(w)
+		return
+	}
+
+	if apiErr := requireDevice(r, string(del.DeviceID)); apiErr != nil {
+		apiErr.WriteJSON(w)
+		return
+	}
+
+	ctx := r.Context()
+
+	unlock := s.writeLocks.Lock(vaultLockKey(vaultID))
+	defer unlock()
+
+	deletedAt := time.Now().UTC()
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if apiErr := s.membershipValidator.WithTx(tx).ValidateVaultDelete(ctx, &del); apiErr != nil {
+			return apiErr
+		}
+
+		marked, err := s.vaults.WithTx(tx).MarkDeleted(ctx, vaultID, string(del.DeviceID), del.Signature, deletedAt.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return apierror.VaultDeleted()
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	del.DeletedAt = deletedAt.Format(time.RFC3339)
+	del.PurgeAfter = deletedAt.Add(s.config.VaultPurgeGrace).Format(time.RFC3339)
+
+	s.hub.Publish(vaultID, StreamMessage{
+		Event: "vault_deleted",
+		Data:  del,
+	})
+
+	writeJSON(w, http.StatusOK, del)
+}
+
+// purgeDeletedVaults removes the data of vaults deleted more than
+// VaultPurgeGrace ago.
+func (s *Server) purgeDeletedVaults(ctx context.Context) {
+	vaultIDs, err := s.vaults.ListPurgeable(ctx, time.Now().Add(-s.config.VaultPurgeGrace))
+	if err != nil {
+		slog.Error("failed to list deleted vaults", "error", err)
+		return
+	}
+
+	for _, vaultID := range vaultIDs {
+		err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+			return s.vaults.WithTx(tx).Purge(ctx, vaultID)
+		})
+		if err != nil {
+			slog.Error("failed to purge deleted vault", "vault_id", bytesToUUID(vaultID).String(), "error", err)
+			continue
+		}
+		slog.Info("purged deleted vault", "vault_id", bytesToUUID(vaultID).String())
+	}
+}
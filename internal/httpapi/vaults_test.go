@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"forgor-server/internal/cbe"
+
+	"github.com/google/uuid"
+)
+
+func TestDeletedVaultRejectsEveryWrite(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner, admin, invitee, outsider := newTestDevice(t), newTestDevice(t), newTestDevice(t), newTestDevice(t)
+	for _, d := range []*testDevice{owner, admin, invitee, outsider} {
+		ts.register(d)
+	}
+	v := ts.genesis(owner)
+	ts.addMember(v, owner, admin)
+	ts.must(ts.roleChange(v, "member_promote", owner, admin, "admin"), http.StatusCreated, "promote")
+	ts.must(ts.push(v, owner, 1), http.StatusCreated, "push")
+
+	// Left open across the deletion: an unclaimed invite and a proposal
+	// still waiting for a co-signature.
+	inviteID := ts.invite(v, owner, invitee)
+	ts.must(ts.policy(v, owner, 2), http.StatusCreated, "policy")
+	r, proposalSB := ts.memberEvent(v, owner, map[string]any{
+		"msg_type":          "member_remove",
+		"subject_device_id": admin.id,
+	}, func(id []byte, seq uint64, prev []byte) ([]byte, error) {
+		return cbe.SignBytesMemberRemove(id, v.id[:], seq, prev, owner.idb, admin.idb)
+	})
+	proposalID := ts.must(r, http.StatusAccepted, "propose").json()["member_event"].(map[string]any)["member_event_id"].(string)
+
+	ts.must(ts.vaultDelete(v, owner), http.StatusOK, "delete vault")
+
+	claimSB, _ := cbe.SignBytesInviteClaim(inviteID[:], v.id[:], invitee.idb)
+	revokeSB, _ := cbe.SignBytesInviteRevoke(inviteID[:], v.id[:], owner.idb)
+	event, _ := ts.eventBody(v, owner, 1, 2, v.heads[owner.id])
+	batchEvent, _ := ts.eventBody(v, owner, 1, 2, v.heads[owner.id])
+
+	writes := []struct {
+		name string
+		do   func() testResponse
+	}{
+		{"event", func() testResponse { return ts.do("POST", v.path("/events"), event, owner) }},
+		{"event batch", func() testResponse {
+			return ts.do("POST", v.path("/events:batch"), []any{batchEvent}, owner)
+		}},
+		{"member event", func() testResponse { return ts.memberLeave(v, admin) }},
+		{"proposal signature", func() testResponse {
+			return ts.do("POST", v.path("/member_proposals/"+proposalID+"/signatures"), map[string]any{
+				"device_id": admin.id,
+				"signature": b64(admin.sign(proposalSB)),
+			}, admin)
+		}},
+		{"vault delete", func() testResponse { return ts.vaultDelete(v, owner) }},
+		{"invite", func() testResponse { _, r := ts.tryInvite(v, owner, outsider); return r }},
+		{"invite claim", func() testResponse {
+			return ts.do("POST", "/v1/invites/"+inviteID.String()+"/claim", map[string]any{
+				"msg_type":  "invite_claim",
+				"invite_id": inviteID.String(),
+				"vault_id":  v.id.String(),
+				"device_id": invitee.id,
+				"signature": b64(invitee.sign(claimSB)),
+			}, invitee)
+		}},
+		{"invite revoke", func() testResponse {
+			return ts.do("POST", "/v1/invites/"+inviteID.String()+"/revoke", map[string]any{
+				"msg_type":  "invite_revoke",
+				"invite_id": inviteID.String(),
+				"vault_id":  v.id.String(),
+				"device_id": owner.id,
+				"signature": b64(owner.sign(revokeSB)),
+			}, owner)
+		}},
+		{"key update", func() testResponse { return ts.keyUpdate(v, owner, admin, 2) }},
+		{"key update batch", func() testResponse {
+			return ts.do("POST", v.path("/key_updates:batch"), []any{
+				ts.keyUpdateBody(v, owner, owner, 2),
+				ts.keyUpdateBody(v, owner, admin, 2),
+			}, owner)
+		}},
+		{"key update ack", func() testResponse { return ts.ack(v, admin, 2) }},
+		{"snapshot", func() testResponse {
+			return ts.do("POST", v.path("/snapshots"), map[string]any{
+				"msg_type":             "snapshot",
+				"snapshot_id":          uuid.New().String(),
+				"vault_id":             v.id.String(),
+				"base_seq":             "1",
+				"member_seq":           strconv.FormatUint(v.seq, 10),
+				"member_head_hash":     b64(v.head),
+				"base_counter_map":     b64(nil),
+				"head_hash_map":        b64(nil),
+				"lamport_at_snapshot":  "1",
+				"key_epoch":            "1",
+				"nonce":                b64(randomBytes(24)),
+				"ciphertext":           b64(randomBytes(32)),
+				"signature":            b64(randomBytes(64)),
+				"created_by_device_id": owner.id,
+			}, owner)
+		}},
+	}
+	// Batches wrap it as batch_rejected, per entry.
+	for _, w := range writes {
+		r := w.do()
+		if r.status != http.StatusGone || !bytes.Contains(r.body, []byte(`"vault_deleted"`)) {
+			t.Errorf("%s: want 410 vault_deleted, got %s", w.name, r)
+		}
+	}
+}
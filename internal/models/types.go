@@ -138,24 +138,55 @@ type MemberEvent struct {
 	SubjectBundleSig  Base64Bytes  `json:"subject_bundle_sig,omitempty"`
 	InviteID          UUID         `json:"invite_id,omitempty"`
 	ClaimSig          Base64Bytes  `json:"claim_sig,omitempty"`
+	Role              string       `json:"role,omitempty"`
+	Quorum            Uint64String `json:"quorum,omitempty"`
 	Signature         Base64Bytes  `json:"signature"`
 	CreatedAt         string       `json:"created_at,omitempty"`
 }
 
+// MemberProposal is a member event held back until enough admins have signed
+// it. Signatures include the proposer's.
+type MemberProposal struct {
+	MemberEvent MemberEvent               `json:"member_event"`
+	Status      string                    `json:"status"`
+	Quorum      Uint64String              `json:"quorum"`
+	Signatures  []MemberProposalSignature `json:"signatures"`
+}
+
+// MemberProposalSignature is an admin's signature over the proposed event's
+// sign bytes.
+type MemberProposalSignature struct {
+	DeviceID  DeviceID    `json:"device_id"`
+	Signature Base64Bytes `json:"signature"`
+	CreatedAt string      `json:"created_at,omitempty"`
+}
+
+type MemberProposalsResponse struct {
+	Proposals []MemberProposal `json:"proposals"`
+}
+
 type Invite struct {
 	MsgType               string      `json:"msg_type"`
 	InviteID              UUID        `json:"invite_id"`
 	VaultID               UUID        `json:"vault_id"`
-	TargetDeviceID        DeviceID    `json:"target_device_id"`
-	TargetDevicePubkeySign Base64Bytes `json:"target_device_pubkey_sign"`
-	TargetDevicePubkeyBox  Base64Bytes `json:"target_device_pubkey_box"`
-	TargetDeviceBundleSig  Base64Bytes `json:"target_device_bundle_sig"`
+	TargetDeviceID        DeviceID    `json:"target_device_id,omitempty"`
+	TargetDevicePubkeySign Base64Bytes `json:"target_device_pubkey_sign,omitempty"`
+	TargetDevicePubkeyBox  Base64Bytes `json:"target_device_pubkey_box,omitempty"`
+	TargetDeviceBundleSig  Base64Bytes `json:"target_device_bundle_sig,omitempty"`
 	Nonce                 Base64Bytes `json:"nonce"`
 	WrappedPayload        Base64Bytes `json:"wrapped_payload"`
 	CreatedByDeviceID     DeviceID    `json:"created_by_device_id"`
 	SingleUse             bool        `json:"single_use"`
+	ExpiresAt             string      `json:"expires_at,omitempty"`
+	// invite_multi only: an empty TargetDeviceIDs leaves the invite open to
+	// any registered device.
+	TargetDeviceIDs []DeviceID   `json:"target_device_ids,omitempty"`
+	MaxUses         Uint64String `json:"max_uses,omitempty"`
+	UseCount        Uint64String `json:"use_count,omitempty"`
+	LastUsedAt      string       `json:"last_used_at,omitempty"`
 	Signature             Base64Bytes `json:"signature"`
 	CreatedAt             string      `json:"created_at,omitempty"`
+	RevokedAt             string      `json:"revoked_at,omitempty"`
 }
 
 type InviteClaim struct {
@@ -167,6 +198,54 @@ type InviteClaim struct {
 	CreatedAt string      `json:"created_at,omitempty"`
 }
 
+// VaultInvite is an invite as the vault's admins see it, with its status and
+// the devices that claimed it.
+type VaultInvite struct {
+	Invite
+	Status    string           `json:"status"`
+	Exhausted bool             `json:"exhausted"`
+	Claims    []InviteClaimant `json:"claims"`
+}
+
+type InviteClaimant struct {
+	DeviceID  DeviceID `json:"device_id"`
+	ClaimedAt string   `json:"claimed_at"`
+}
+
+// Invite statuses in GET /v1/vaults/{vault_id}/invites.
+const (
+	InviteStatusPending = "pending"
+	InviteStatusClaimed = "claimed"
+	InviteStatusActive  = "active"
+	InviteStatusUsed    = "used"
+	InviteStatusExpired = "expired"
+	InviteStatusRevoked = "revoked"
+)
+
+type InviteRevoke struct {
+	MsgType   string      `json:"msg_type"`
+	InviteID  UUID        `json:"invite_id"`
+	VaultID   UUID        `json:"vault_id"`
+	DeviceID  DeviceID    `json:"device_id"`
+	Signature Base64Bytes `json:"signature"`
+	RevokedAt string      `json:"revoked_at,omitempty"`
+}
+
+// PairingSlot is registered by a new device, which gets back a short code
+// the server generated for it; PairingLookup is an admin resolving the
+// SHA-256 of that code to the device's bundle.
+type PairingSlot struct {
+	DeviceID  DeviceID `json:"device_id"`
+	Code      string   `json:"code,omitempty"`
+	ExpiresAt string   `json:"expires_at,omitempty"`
+}
+
+type PairingLookup struct {
+	VaultID  UUID        `json:"vault_id"`
+	DeviceID DeviceID    `json:"device_id"`
+	CodeHash Base64Bytes `json:"code_hash"`
+}
+
 type KeyUpdate struct {
 	MsgType           string       `json:"msg_type"`
 	KeyUpdateID       UUID         `json:"key_update_id"`
@@ -193,6 +272,19 @@ type KeyUpdateAck struct {
 	CreatedAt      string       `json:"created_at,omitempty"`
 }
 
+// VaultDelete is the owner's signed request to delete a vault. DeletedAt and
+// PurgeAfter are filled in by the server.
+type VaultDelete struct {
+	MsgType        string       `json:"msg_type"`
+	VaultID        UUID         `json:"vault_id"`
+	DeviceID       DeviceID     `json:"device_id"`
+	MemberSeq      Uint64String `json:"member_seq"`
+	MemberHeadHash Base64Bytes  `json:"member_head_hash"`
+	Signature      Base64Bytes  `json:"signature"`
+	DeletedAt      string       `json:"deleted_at,omitempty"`
+	PurgeAfter     string       `json:"purge_after,omitempty"`
+}
+
 type Snapshot struct {
 	MsgType           string       `json:"msg_type"`
 	SnapshotID        UUID         `json:"snapshot_id"`
@@ -211,27 +303,162 @@ type Snapshot struct {
 	CreatedAt         string       `json:"created_at,omitempty"`
 }
 
+// SnapshotInfo announces a new snapshot on the vault stream without its
+// ciphertext.
+type SnapshotInfo struct {
+	SnapshotID        UUID         `json:"snapshot_id"`
+	VaultID           UUID         `json:"vault_id"`
+	BaseSeq           Uint64String `json:"base_seq"`
+	MemberSeq         Uint64String `json:"member_seq"`
+	KeyEpoch          Uint64String `json:"key_epoch"`
+	CreatedByDeviceID DeviceID     `json:"created_by_device_id"`
+	CreatedAt         string       `json:"created_at"`
+}
+
 type VaultMember struct {
 	DeviceID        DeviceID    `json:"device_id"`
 	DevicePubkeySign Base64Bytes `json:"device_pubkey_sign"`
 	DevicePubkeyBox  Base64Bytes `json:"device_pubkey_box"`
 	KeyEpoch        Uint64String `json:"key_epoch"`
+	Role            string       `json:"role"`
+	Frozen          bool         `json:"frozen,omitempty"`
 }
 
 type VaultMembershipResponse struct {
-	MemberSeq Uint64String  `json:"member_seq"`
-	HeadHash  Base64Bytes   `json:"head_hash"`
-	Members   []VaultMember `json:"members"`
+	MemberSeq       Uint64String  `json:"member_seq"`
+	HeadHash        Base64Bytes   `json:"head_hash"`
+	OwnerDeviceID   DeviceID      `json:"owner_device_id"`
+	KeyEpoch        Uint64String  `json:"key_epoch"`
+	RotationPending bool          `json:"rotation_pending"`
+	MemberQuorum    Uint64String  `json:"member_quorum"`
+	Members         []VaultMember `json:"members"`
+}
+
+// KeyEpochStatus summarizes the distribution of one key epoch to the current
+// members. A complete epoch carries the membership head it completed at.
+type KeyEpochStatus struct {
+	VaultID        UUID             `json:"vault_id"`
+	KeyEpoch       Uint64String     `json:"key_epoch"`
+	Current        bool             `json:"current"`
+	Complete       bool             `json:"complete"`
+	MemberSeq      Uint64String     `json:"member_seq,omitempty"`
+	MemberHeadHash Base64Bytes      `json:"member_head_hash,omitempty"`
+	CompletedAt    string           `json:"completed_at,omitempty"`
+	Members        []KeyEpochMember `json:"members"`
+}
+
+type KeyEpochMember struct {
+	DeviceID  DeviceID `json:"device_id"`
+	KeyUpdate bool     `json:"key_update"`
+	Acked     bool     `json:"acked"`
+}
+
+// RotationStatus reports how far the owner is with the key rotation a member
+// removal requires. TargetEpoch is the highest epoch distributed since that
+// removal; Missing lists current members without a key update for it.
+type RotationStatus struct {
+	KeyEpoch              Uint64String `json:"key_epoch"`
+	RotationPending       bool         `json:"rotation_pending"`
+	PendingSinceMemberSeq Uint64String `json:"pending_since_member_seq,omitempty"`
+	TargetEpoch           Uint64String `json:"target_epoch,omitempty"`
+	Distributed           []DeviceID   `json:"distributed"`
+	Missing               []DeviceID   `json:"missing"`
+}
+
+type EventChainHead struct {
+	DeviceID    DeviceID     `json:"device_id"`
+	LastCounter Uint64String `json:"last_counter"`
+	LastHash    Base64Bytes  `json:"last_hash"`
+}
+
+// EventRef identifies a stored event without its payload.
+type EventRef struct {
+	EventID   UUID         `json:"event_id"`
+	DeviceID  DeviceID     `json:"device_id"`
+	Counter   Uint64String `json:"counter"`
+	EventHash Base64Bytes  `json:"event_hash"`
+	Seq       Uint64String `json:"seq"`
+}
+
+// MemberEventRef identifies a stored member event without its payload.
+type MemberEventRef struct {
+	MemberEventID UUID         `json:"member_event_id"`
+	ActorDeviceID DeviceID     `json:"actor_device_id"`
+	MemberSeq     Uint64String `json:"member_seq"`
+	MemberHash    Base64Bytes  `json:"member_hash"`
+}
+
+// Equivocation is the proof that a device signed two different messages at
+// the same position of an event chain ("event") or the membership log
+// ("member_event").
+type Equivocation struct {
+	EquivocationID     Uint64String    `json:"equivocation_id"`
+	VaultID            UUID            `json:"vault_id"`
+	DeviceID           DeviceID        `json:"device_id"`
+	Kind               string          `json:"kind"`
+	Position           Uint64String    `json:"position"`
+	StoredMessage      json.RawMessage `json:"stored_message"`
+	ConflictingMessage json.RawMessage `json:"conflicting_message"`
+	CreatedAt          string          `json:"created_at"`
+}
+
+// KeyEpochRange is the window of key epochs a device may write under: from
+// the vault's current epoch up to the epoch the device last acked.
+type KeyEpochRange struct {
+	VaultKeyEpoch  Uint64String `json:"vault_key_epoch"`
+	MemberKeyEpoch Uint64String `json:"member_key_epoch"`
+}
+
+type MembershipHead struct {
+	MemberSeq Uint64String `json:"member_seq"`
+	HeadHash  Base64Bytes  `json:"head_hash"`
 }
 
 type EventResponse struct {
 	Seq Uint64String `json:"seq"`
 }
 
+type EventBatchResponse struct {
+	Seqs []Uint64String `json:"seqs"`
+}
+
+type AuthChallengeRequest struct {
+	DeviceID DeviceID `json:"device_id"`
+}
+
+type AuthChallenge struct {
+	DeviceID  DeviceID    `json:"device_id"`
+	Challenge Base64Bytes `json:"challenge"`
+	ExpiresAt string      `json:"expires_at"`
+}
+
+type AuthSessionRequest struct {
+	MsgType   string      `json:"msg_type"`
+	DeviceID  DeviceID    `json:"device_id"`
+	Challenge Base64Bytes `json:"challenge"`
+	Signature Base64Bytes `json:"signature"`
+}
+
+type AuthSession struct {
+	DeviceID  DeviceID `json:"device_id"`
+	Token     string   `json:"token"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+// Member roles. The owner and admins manage the vault; there is exactly one
+// owner, changed only by owner_transfer. Readers can pull but not push.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleReader = "reader"
+)
+
 const (
 	MaxEventCiphertext    = 65536
 	MaxSnapshotCiphertext = 8388608
 	MaxWrappedPayload     = 1024
+	MaxInviteTargets      = 64
 	MaxTags               = 128
 	MaxTagLength          = 64
 	MaxWebsiteLength      = 2048
@@ -239,6 +466,8 @@ const (
 	MaxPasswordLength     = 8192
 	MaxNotesLength        = 65535
 	MaxSnapshotEntries    = 5000
+	MaxEventBatch         = 100
+	MaxKeyUpdateBatch     = 500
 	MaxMapLength          = 1024
 
 	NonceLength     = 24
@@ -246,6 +475,11 @@ const (
 	SignatureLength = 64
 	PublicKeyLength = 32
 	DeviceIDLength  = 64
+	ChallengeLength = 32
+	TokenLength     = 32
+
+	PairingCodeLength   = 8
+	PairingCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
 )
 
 var (
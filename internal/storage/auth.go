@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"forgor-server/internal/db"
+)
+
+type AuthChallengeRow struct {
+	Challenge []byte
+	DeviceID  string
+	CreatedAt string
+	ExpiresAt string
+}
+
+type AuthSessionRow struct {
+	TokenHash []byte
+	DeviceID  string
+	CreatedAt string
+	ExpiresAt string
+	Revoked   bool
+}
+
+type AuthRepository struct {
+	db querier
+}
+
+func NewAuthRepository(database *db.DB) *AuthRepository {
+	return &AuthRepository{db: database}
+}
+
+func (r *AuthRepository) WithTx(tx *sql.Tx) *AuthRepository {
+	return &AuthRepository{db: tx}
+}
+
+// RecordRequestNonce stores a signed-request nonce and reports whether it was
+// fresh. A false result means the nonce was already seen for this device.
+func (r *AuthRepository) RecordRequestNonce(ctx context.Context, deviceID string, nonce []byte) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO request_nonces (device_id, nonce, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, deviceID, nonce, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *AuthRepository) PruneRequestNonces(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM request_nonces WHERE created_at < ?
+	`, before.UTC().Format(time.RFC3339))
+	return err
+}
+
+func (r *AuthRepository) CreateChallenge(ctx context.Context, c *AuthChallengeRow) error {
+	if c.CreatedAt == "" {
+		c.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO auth_challenges (challenge, device_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?)
+	`, c.Challenge, c.DeviceID, c.CreatedAt, c.ExpiresAt)
+	return err
+}
+
+// ConsumeChallenge deletes the challenge and returns it, so each challenge
+// can be answered at most once.
+func (r *AuthRepository) ConsumeChallenge(ctx context.Context, challenge []byte) (*AuthChallengeRow, error) {
+	row := r.db.QueryRowContext(ctx, `
+		DELETE FROM auth_challenges WHERE challenge = ?
+		RETURNING challenge, device_id, created_at, expires_at
+	`, challenge)
+
+	var c AuthChallengeRow
+	err := row.Scan(&c.Challenge, &c.DeviceID, &c.CreatedAt, &c.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *AuthRepository) CreateSession(ctx context.Context, s *AuthSessionRow) error {
+	if s.CreatedAt == "" {
+		s.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO auth_sessions (token_hash, device_id, created_at, expires_at, revoked)
+		VALUES (?, ?, ?, ?, ?)
+	`, s.TokenHash, s.DeviceID, s.CreatedAt, s.ExpiresAt, s.Revoked)
+	return err
+}
+
+func (r *AuthRepository) GetSession(ctx context.Context, tokenHash []byte) (*AuthSessionRow, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT token_hash, device_id, created_at, expires_at, revoked
+		FROM auth_sessions WHERE token_hash = ?
+	`, tokenHash)
+
+	var s AuthSessionRow
+	err := row.Scan(&s.TokenHash, &s.DeviceID, &s.CreatedAt, &s.ExpiresAt, &s.Revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *AuthRepository) RevokeSession(ctx context.Context, tokenHash []byte) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE auth_sessions SET revoked = 1 WHERE token_hash = ?
+	`, tokenHash)
+	return err
+}
+
+func (r *AuthRepository) RevokeDeviceSessions(ctx context.Context, deviceID string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE auth_sessions SET revoked = 1 WHERE device_id = ?
+	`, deviceID)
+	return err
+}
+
+func (r *AuthRepository) PruneExpired(ctx context.Context, now time.Time) error {
+	cutoff := now.UTC().Format(time.RFC3339)
+	if _, err := r.db.ExecContext(ctx, `
+		DELETE FROM auth_challenges WHERE expires_at < ?
+	`, cutoff); err != nil {
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM auth_sessions WHERE expires_at < ?
+	`, cutoff)
+	return err
+}
@@ -19,13 +19,17 @@ type DeviceRow struct {
 }
 
 type DevicesRepository struct {
-	db *db.DB
+	db querier
 }
 
 func NewDevicesRepository(database *db.DB) *DevicesRepository {
 	return &DevicesRepository{db: database}
 }
 
+func (r *DevicesRepository) WithTx(tx *sql.Tx) *DevicesRepository {
+	return &DevicesRepository{db: tx}
+}
+
 func (r *DevicesRepository) Get(ctx context.Context, deviceID string) (*DeviceRow, error) {
 	row := r.db.QueryRowContext(ctx, `
 		SELECT device_id, device_pubkey_sign, device_pubkey_box, device_bundle_sig, created_at
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"forgor-server/internal/db"
+)
+
+// EquivocationRow is the proof that a device signed two different messages
+// at the same chain position: the one the server committed and the one it
+// rejected, both as submitted JSON.
+type EquivocationRow struct {
+	EquivocationID     uint64
+	VaultID            []byte
+	DeviceID           string
+	Kind               string
+	Position           uint64
+	StoredMessage      []byte
+	ConflictingMessage []byte
+	ConflictingHash    []byte
+	CreatedAt          string
+}
+
+type EquivocationsRepository struct {
+	db querier
+}
+
+func NewEquivocationsRepository(database *db.DB) *EquivocationsRepository {
+	return &EquivocationsRepository{db: database}
+}
+
+func (r *EquivocationsRepository) WithTx(tx *sql.Tx) *EquivocationsRepository {
+	return &EquivocationsRepository{db: tx}
+}
+
+// Create records the proof once; resubmitting the same conflicting message
+// does not add another row. It reports whether a row was added.
+func (r *EquivocationsRepository) Create(ctx context.Context, e *EquivocationRow) (bool, error) {
+	if e.CreatedAt == "" {
+		e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO equivocations (vault_id, device_id, kind, position, stored_message, conflicting_message, conflicting_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(vault_id, kind, device_id, position, conflicting_hash) DO NOTHING
+	`, e.VaultID, e.DeviceID, e.Kind, e.Position, e.StoredMessage, e.ConflictingMessage, e.ConflictingHash, e.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// ListByVault calls fn for the vault's equivocations in the order they were
+// recorded, after page.After.Seq.
+func (r *EquivocationsRepository) ListByVault(ctx context.Context, vaultID []byte, page Page, fn func(*EquivocationRow) error) error {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT equivocation_id, vault_id, device_id, kind, position, stored_message, conflicting_message, conflicting_hash, created_at
+		FROM equivocations
+		WHERE vault_id = ? AND equivocation_id > ?
+		ORDER BY equivocation_id ASC
+		LIMIT ?
+	`, vaultID, page.afterSeq(), page.sqlLimit())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e EquivocationRow
+		if err := rows.Scan(&e.EquivocationID, &e.VaultID, &e.DeviceID, &e.Kind, &e.Position, &e.StoredMessage, &e.ConflictingMessage, &e.ConflictingHash, &e.CreatedAt); err != nil {
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -33,13 +33,17 @@ type EventHead struct {
 }
 
 type EventsRepository struct {
-	db *db.DB
+	db querier
 }
 
 func NewEventsRepository(database *db.DB) *EventsRepository {
 	return &EventsRepository{db: database}
 }
 
+func (r *EventsRepository) WithTx(tx *sql.Tx) *EventsRepository {
+	return &EventsRepository{db: tx}
+}
+
 func (r *EventsRepository) Create(ctx context.Context, e *EventRow) (uint64, error) {
 	if e.CreatedAt == "" {
 		e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
@@ -60,27 +64,40 @@ func (r *EventsRepository) Create(ctx context.Context, e *EventRow) (uint64, err
 	return uint64(seq), nil
 }
 
-func (r *EventsRepository) ListSince(ctx context.Context, vaultID []byte, sinceSeq uint64) ([]*EventRow, error) {
+// ListSince calls fn for each event after page.After.Seq in seq order, one
+// row at a time, stopping at the first error fn returns.
+func (r *EventsRepository) ListSince(ctx context.Context, vaultID []byte, page Page, fn func(*EventRow) error) error {
 	rows, err := r.db.QueryContext(ctx, `
 		SELECT seq, event_id, event_hash, vault_id, device_id, counter, lamport, key_epoch, prev_hash, nonce, ciphertext, signature, created_at
 		FROM events
 		WHERE vault_id = ? AND seq > ?
 		ORDER BY seq ASC
-	`, vaultID, sinceSeq)
+		LIMIT ?
+	`, vaultID, page.afterSeq(), page.sqlLimit())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var events []*EventRow
 	for rows.Next() {
 		var e EventRow
 		if err := rows.Scan(&e.Seq, &e.EventID, &e.EventHash, &e.VaultID, &e.DeviceID, &e.Counter, &e.Lamport, &e.KeyEpoch, &e.PrevHash, &e.Nonce, &e.Ciphertext, &e.Signature, &e.CreatedAt); err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
 		}
-		events = append(events, &e)
 	}
-	return events, rows.Err()
+	return rows.Err()
+}
+
+// LatestSeq returns the highest seq in the vault, or 0 if it has no events.
+func (r *EventsRepository) LatestSeq(ctx context.Context, vaultID []byte) (uint64, error) {
+	var seq uint64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(seq), 0) FROM events WHERE vault_id = ?
+	`, vaultID).Scan(&seq)
+	return seq, err
 }
 
 func (r *EventsRepository) GetEventHead(ctx context.Context, vaultID []byte, deviceID string) (*EventHead, error) {
@@ -100,13 +117,49 @@ func (r *EventsRepository) GetEventHead(ctx context.Context, vaultID []byte, dev
 	return &h, nil
 }
 
-func (r *EventsRepository) UpsertEventHead(ctx context.Context, h *EventHead) error {
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO event_heads (vault_id, device_id, last_counter, last_hash)
-		VALUES (?, ?, ?, ?)
-		ON CONFLICT(vault_id, device_id) DO UPDATE SET last_counter = excluded.last_counter, last_hash = excluded.last_hash
-	`, h.VaultID, h.DeviceID, h.LastCounter, h.LastHash)
-	return err
+// AdvanceEventHead moves the device chain head from (prevCounter, prevHash)
+// to h. It reports false without writing if the stored head is no longer the
+// expected one; prevCounter == 0 means the chain must not exist yet.
+func (r *EventsRepository) AdvanceEventHead(ctx context.Context, h *EventHead, prevCounter uint64, prevHash []byte) (bool, error) {
+	var result sql.Result
+	var err error
+	if prevCounter == 0 {
+		result, err = r.db.ExecContext(ctx, `
+			INSERT INTO event_heads (vault_id, device_id, last_counter, last_hash)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(vault_id, device_id) DO NOTHING
+		`, h.VaultID, h.DeviceID, h.LastCounter, h.LastHash)
+	} else {
+		result, err = r.db.ExecContext(ctx, `
+			UPDATE event_heads SET last_counter = ?, last_hash = ?
+			WHERE vault_id = ? AND device_id = ? AND last_counter = ? AND last_hash = ?
+		`, h.LastCounter, h.LastHash, h.VaultID, h.DeviceID, prevCounter, prevHash)
+	}
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *EventsRepository) GetByCounter(ctx context.Context, vaultID []byte, deviceID string, counter uint64) (*EventRow, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT seq, event_id, event_hash, vault_id, device_id, counter, lamport, key_epoch, prev_hash, nonce, ciphertext, signature, created_at
+		FROM events WHERE vault_id = ? AND device_id = ? AND counter = ?
+	`, vaultID, deviceID, counter)
+
+	var e EventRow
+	err := row.Scan(&e.Seq, &e.EventID, &e.EventHash, &e.VaultID, &e.DeviceID, &e.Counter, &e.Lamport, &e.KeyEpoch, &e.PrevHash, &e.Nonce, &e.Ciphertext, &e.Signature, &e.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
 }
 
 func (r *EventsRepository) CheckEventIDExists(ctx context.Context, vaultID []byte, deviceID string, eventID []byte) (bool, error) {
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"errors"
+	"slices"
+	"strings"
 	"time"
 
 	"forgor-server/internal/db"
@@ -20,9 +23,47 @@ type InviteRow struct {
 	WrappedPayload        []byte
 	CreatedByDeviceID     string
 	SingleUse             bool
-	Used                  bool
+	ExpiresAt             string
 	Signature             []byte
 	CreatedAt             string
+	RevokedAt             string
+	// MaxUses is 0 for an unlimited invite.
+	MaxUses    uint64
+	UseCount   uint64
+	LastUsedAt string
+	// Targets are the devices a multi-use invite is addressed to; it is
+	// open to any registered device when there are none. Single-target
+	// invites use TargetDeviceID instead.
+	Targets []string
+}
+
+// IsMulti reports whether the invite is a multi-use invite_multi.
+func (inv *InviteRow) IsMulti() bool {
+	return inv.TargetDeviceID == ""
+}
+
+// Admits reports whether deviceID may claim the invite and be added with it.
+func (inv *InviteRow) Admits(deviceID string) bool {
+	if !inv.IsMulti() {
+		return inv.TargetDeviceID == deviceID
+	}
+	return len(inv.Targets) == 0 || slices.Contains(inv.Targets, deviceID)
+}
+
+// Expired reports whether the invite's lifetime had run out at now. An
+// invite without a signed expiry lasts maxLifetime from its creation.
+func (inv *InviteRow) Expired(now time.Time, maxLifetime time.Duration) bool {
+	if inv.ExpiresAt == "" {
+		createdAt, err := time.Parse(time.RFC3339, inv.CreatedAt)
+		return err != nil || !now.Before(createdAt.Add(maxLifetime))
+	}
+	expiresAt, err := time.Parse(time.RFC3339, inv.ExpiresAt)
+	return err != nil || !now.Before(expiresAt)
+}
+
+// Exhausted reports whether the invite has no uses left.
+func (inv *InviteRow) Exhausted() bool {
+	return inv.MaxUses > 0 && inv.UseCount >= inv.MaxUses
 }
 
 type InviteClaimRow struct {
@@ -34,13 +75,17 @@ type InviteClaimRow struct {
 }
 
 type InvitesRepository struct {
-	db *db.DB
+	db querier
 }
 
 func NewInvitesRepository(database *db.DB) *InvitesRepository {
 	return &InvitesRepository{db: database}
 }
 
+func (r *InvitesRepository) WithTx(tx *sql.Tx) *InvitesRepository {
+	return &InvitesRepository{db: tx}
+}
+
 func (r *InvitesRepository) Create(ctx context.Context, inv *InviteRow) error {
 	if inv.CreatedAt == "" {
 		inv.CreatedAt = time.Now().UTC().Format(time.RFC3339)
@@ -48,63 +93,239 @@ func (r *InvitesRepository) Create(ctx context.Context, inv *InviteRow) error {
 	_, err := r.db.ExecContext(ctx, `
 		INSERT INTO invites (
 			invite_id, vault_id, target_device_id, target_device_pubkey_sign, target_device_pubkey_box,
-			target_device_bundle_sig, nonce, wrapped_payload, created_by_device_id, single_use, used, signature, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			target_device_bundle_sig, nonce, wrapped_payload, created_by_device_id, single_use, expires_at, signature, created_at,
+			max_uses, use_count
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, inv.InviteID, inv.VaultID, inv.TargetDeviceID, inv.TargetDevicePubkeySign, inv.TargetDevicePubkeyBox,
-		inv.TargetDeviceBundleSig, inv.Nonce, inv.WrappedPayload, inv.CreatedByDeviceID, inv.SingleUse, inv.Used, inv.Signature, inv.CreatedAt)
-	return err
+		inv.TargetDeviceBundleSig, inv.Nonce, inv.WrappedPayload, inv.CreatedByDeviceID, inv.SingleUse, inv.ExpiresAt, inv.Signature, inv.CreatedAt,
+		inv.MaxUses, inv.UseCount)
+	if err != nil {
+		return err
+	}
+
+	for _, deviceID := range inv.Targets {
+		if _, err := r.db.ExecContext(ctx, `
+			INSERT INTO invite_targets (invite_id, device_id) VALUES (?, ?)
+		`, inv.InviteID, deviceID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *InvitesRepository) Get(ctx context.Context, inviteID []byte) (*InviteRow, error) {
 	row := r.db.QueryRowContext(ctx, `
 		SELECT invite_id, vault_id, target_device_id, target_device_pubkey_sign, target_device_pubkey_box,
-			   target_device_bundle_sig, nonce, wrapped_payload, created_by_device_id, single_use, used, signature, created_at
+			   target_device_bundle_sig, nonce, wrapped_payload, created_by_device_id, single_use, expires_at, signature, created_at, revoked_at,
+			   max_uses, use_count, last_used_at, (SELECT group_concat(device_id) FROM invite_targets t WHERE t.invite_id = invites.invite_id)
 		FROM invites WHERE invite_id = ?
 	`, inviteID)
 
 	var inv InviteRow
+	var targets sql.NullString
 	err := row.Scan(&inv.InviteID, &inv.VaultID, &inv.TargetDeviceID, &inv.TargetDevicePubkeySign, &inv.TargetDevicePubkeyBox,
-		&inv.TargetDeviceBundleSig, &inv.Nonce, &inv.WrappedPayload, &inv.CreatedByDeviceID, &inv.SingleUse, &inv.Used, &inv.Signature, &inv.CreatedAt)
+		&inv.TargetDeviceBundleSig, &inv.Nonce, &inv.WrappedPayload, &inv.CreatedByDeviceID, &inv.SingleUse, &inv.ExpiresAt, &inv.Signature, &inv.CreatedAt, &inv.RevokedAt,
+		&inv.MaxUses, &inv.UseCount, &inv.LastUsedAt, &targets)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	inv.Targets = splitTargets(targets)
 	return &inv, nil
 }
 
-func (r *InvitesRepository) ListByTargetDevice(ctx context.Context, targetDeviceID string) ([]*InviteRow, error) {
+// ListByTargetDevice calls fn for the unexpired, unrevoked invites with uses
+// left that are addressed to the device, directly or as one of a multi-use
+// invite's targets, newest first, after the (created_at, invite_id) position
+// in page.After. Invites without a signed expiry expire maxLifetime after
+// their creation.
+func (r *InvitesRepository) ListByTargetDevice(ctx context.Context, targetDeviceID string, maxLifetime time.Duration, page Page, fn func(*InviteRow) error) error {
+	first, afterCreatedAt, afterID := page.afterKey()
+	now := time.Now().UTC()
 	rows, err := r.db.QueryContext(ctx, `
 		SELECT invite_id, vault_id, target_device_id, target_device_pubkey_sign, target_device_pubkey_box,
-			   target_device_bundle_sig, nonce, wrapped_payload, created_by_device_id, single_use, used, signature, created_at
-		FROM invites WHERE target_device_id = ?
-		ORDER BY created_at DESC
-	`, targetDeviceID)
+			   target_device_bundle_sig, nonce, wrapped_payload, created_by_device_id, single_use, expires_at, signature, created_at, revoked_at,
+			   max_uses, use_count, last_used_at, (SELECT group_concat(device_id) FROM invite_targets t WHERE t.invite_id = invites.invite_id)
+		FROM invites
+		WHERE (target_device_id = ? OR invite_id IN (SELECT invite_id FROM invite_targets WHERE device_id = ?))
+		  AND (expires_at > ? OR (expires_at = '' AND created_at > ?)) AND revoked_at = ''
+		  AND (max_uses = 0 OR use_count < max_uses)
+		  AND (? OR (created_at, invite_id) < (?, ?))
+		ORDER BY created_at DESC, invite_id DESC
+		LIMIT ?
+	`, targetDeviceID, targetDeviceID, now.Format(time.RFC3339), now.Add(-maxLifetime).Format(time.RFC3339),
+		first, afterCreatedAt, afterID, page.sqlLimit())
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var inv InviteRow
+		var targets sql.NullString
+		if err := rows.Scan(&inv.InviteID, &inv.VaultID, &inv.TargetDeviceID, &inv.TargetDevicePubkeySign, &inv.TargetDevicePubkeyBox,
+			&inv.TargetDeviceBundleSig, &inv.Nonce, &inv.WrappedPayload, &inv.CreatedByDeviceID, &inv.SingleUse, &inv.ExpiresAt, &inv.Signature, &inv.CreatedAt, &inv.RevokedAt,
+			&inv.MaxUses, &inv.UseCount, &inv.LastUsedAt, &targets); err != nil {
+			return err
+		}
+		inv.Targets = splitTargets(targets)
+		if err := fn(&inv); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListByCreator calls fn for every invite the device created, including
+// expired and revoked ones, newest first, after the (created_at, invite_id)
+// position in page.After.
+func (r *InvitesRepository) ListByCreator(ctx context.Context, createdByDeviceID string, page Page, fn func(*InviteRow) error) error {
+	first, afterCreatedAt, afterID := page.afterKey()
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT invite_id, vault_id, target_device_id, target_device_pubkey_sign, target_device_pubkey_box,
+			   target_device_bundle_sig, nonce, wrapped_payload, created_by_device_id, single_use, expires_at, signature, created_at, revoked_at,
+			   max_uses, use_count, last_used_at, (SELECT group_concat(device_id) FROM invite_targets t WHERE t.invite_id = invites.invite_id)
+		FROM invites WHERE created_by_device_id = ?
+		  AND (? OR (created_at, invite_id) < (?, ?))
+		ORDER BY created_at DESC, invite_id DESC
+		LIMIT ?
+	`, createdByDeviceID, first, afterCreatedAt, afterID, page.sqlLimit())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var inv InviteRow
+		var targets sql.NullString
+		if err := rows.Scan(&inv.InviteID, &inv.VaultID, &inv.TargetDeviceID, &inv.TargetDevicePubkeySign, &inv.TargetDevicePubkeyBox,
+			&inv.TargetDeviceBundleSig, &inv.Nonce, &inv.WrappedPayload, &inv.CreatedByDeviceID, &inv.SingleUse, &inv.ExpiresAt, &inv.Signature, &inv.CreatedAt, &inv.RevokedAt,
+			&inv.MaxUses, &inv.UseCount, &inv.LastUsedAt, &targets); err != nil {
+			return err
+		}
+		inv.Targets = splitTargets(targets)
+		if err := fn(&inv); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListByVault calls fn for each of the vault's invites with its claims,
+// newest invite first, after the (created_at, invite_id) position in
+// page.After. Claims come oldest first.
+func (r *InvitesRepository) ListByVault(ctx context.Context, vaultID []byte, page Page, fn func(*InviteRow, []*InviteClaimRow) error) error {
+	first, afterCreatedAt, afterID := page.afterKey()
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT i.invite_id, i.vault_id, i.target_device_id, i.target_device_pubkey_sign, i.target_device_pubkey_box,
+			   i.target_device_bundle_sig, i.nonce, i.wrapped_payload, i.created_by_device_id, i.single_use, i.expires_at, i.signature, i.created_at, i.revoked_at,
+			   i.max_uses, i.use_count, i.last_used_at, (SELECT group_concat(device_id) FROM invite_targets t WHERE t.invite_id = i.invite_id),
+			   c.device_id, c.claim_sig, c.created_at
+		FROM (
+			SELECT * FROM invites WHERE vault_id = ?
+			  AND (? OR (created_at, invite_id) < (?, ?))
+			ORDER BY created_at DESC, invite_id DESC
+			LIMIT ?
+		) i
+		LEFT JOIN invite_claims c ON c.invite_id = i.invite_id
+		ORDER BY i.created_at DESC, i.invite_id DESC, c.created_at ASC, c.device_id ASC
+	`, vaultID, first, afterCreatedAt, afterID, page.sqlLimit())
+	if err != nil {
+		return err
 	}
 	defer rows.Close()
 
-	var invites []*InviteRow
+	var current *InviteRow
+	var claims []*InviteClaimRow
 	for rows.Next() {
 		var inv InviteRow
+		var targets, claimDeviceID, claimCreatedAt sql.NullString
+		var claimSig []byte
 		if err := rows.Scan(&inv.InviteID, &inv.VaultID, &inv.TargetDeviceID, &inv.TargetDevicePubkeySign, &inv.TargetDevicePubkeyBox,
-			&inv.TargetDeviceBundleSig, &inv.Nonce, &inv.WrappedPayload, &inv.CreatedByDeviceID, &inv.SingleUse, &inv.Used, &inv.Signature, &inv.CreatedAt); err != nil {
-			return nil, err
+			&inv.TargetDeviceBundleSig, &inv.Nonce, &inv.WrappedPayload, &inv.CreatedByDeviceID, &inv.SingleUse, &inv.ExpiresAt, &inv.Signature, &inv.CreatedAt, &inv.RevokedAt,
+			&inv.MaxUses, &inv.UseCount, &inv.LastUsedAt, &targets,
+			&claimDeviceID, &claimSig, &claimCreatedAt); err != nil {
+			return err
 		}
-		invites = append(invites, &inv)
+
+		if current == nil || !bytes.Equal(current.InviteID, inv.InviteID) {
+			if current != nil {
+				if err := fn(current, claims); err != nil {
+					return err
+				}
+			}
+			inv.Targets = splitTargets(targets)
+			current = &inv
+			claims = nil
+		}
+		if claimDeviceID.Valid {
+			claims = append(claims, &InviteClaimRow{
+				InviteID:  current.InviteID,
+				VaultID:   current.VaultID,
+				DeviceID:  claimDeviceID.String,
+				ClaimSig:  claimSig,
+				CreatedAt: claimCreatedAt.String,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if current != nil {
+		return fn(current, claims)
+	}
+	return nil
+}
+
+// Revoke marks an invite revoked. It reports false if it already was.
+func (r *InvitesRepository) Revoke(ctx context.Context, inviteID, signature []byte, revokedAt string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE invites SET revoked_at = ?, revoke_signature = ?
+		WHERE invite_id = ? AND revoked_at = ''
+	`, revokedAt, signature, inviteID)
+	if err != nil {
+		return false, err
 	}
-	return invites, rows.Err()
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
 }
 
-func (r *InvitesRepository) MarkUsed(ctx context.Context, inviteID []byte) error {
+// ClearExpiredPayloads empties the wrapped payload of invites that expired
+// before now, counting invites without a signed expiry as expired
+// maxLifetime after their creation. The invite, its targets and claims are
+// kept, so an expired invite still shows up as such instead of disappearing.
+func (r *InvitesRepository) ClearExpiredPayloads(ctx context.Context, now time.Time, maxLifetime time.Duration) error {
 	_, err := r.db.ExecContext(ctx, `
-		UPDATE invites SET used = 1 WHERE invite_id = ?
-	`, inviteID)
+		UPDATE invites SET wrapped_payload = X''
+		WHERE ((expires_at != '' AND expires_at < ?) OR (expires_at = '' AND created_at < ?))
+		  AND length(wrapped_payload) > 0
+	`, now.UTC().Format(time.RFC3339), now.Add(-maxLifetime).UTC().Format(time.RFC3339))
 	return err
 }
 
+// MarkUsed counts one use of the invite. It reports false, changing
+// nothing, if the invite has no uses left; callers run it in the same
+// transaction as the member_add that uses it.
+func (r *InvitesRepository) MarkUsed(ctx context.Context, inviteID []byte) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE invites SET use_count = use_count + 1, last_used_at = ?
+		WHERE invite_id = ? AND (max_uses = 0 OR use_count < max_uses)
+	`, time.Now().UTC().Format(time.RFC3339), inviteID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
 func (r *InvitesRepository) CreateClaim(ctx context.Context, claim *InviteClaimRow) error {
 	if claim.CreatedAt == "" {
 		claim.CreatedAt = time.Now().UTC().Format(time.RFC3339)
@@ -134,28 +355,38 @@ func (r *InvitesRepository) GetClaim(ctx context.Context, inviteID []byte, devic
 	return &claim, nil
 }
 
-func (r *InvitesRepository) ListClaimsByCreator(ctx context.Context, createdByDeviceID string) ([]*InviteClaimRow, error) {
+// ListClaimsByCreator calls fn for claims on the device's invites, newest
+// first, after the (created_at, invite_id, device_id) position in page.After.
+func (r *InvitesRepository) ListClaimsByCreator(ctx context.Context, createdByDeviceID string, page Page, fn func(*InviteClaimRow) error) error {
+	first, afterCreatedAt, afterID := page.afterKey()
+	var afterDeviceID string
+	if page.After != nil {
+		afterDeviceID = page.After.DeviceID
+	}
 	rows, err := r.db.QueryContext(ctx, `
 		SELECT ic.invite_id, ic.vault_id, ic.device_id, ic.claim_sig, ic.created_at
 		FROM invite_claims ic
 		INNER JOIN invites i ON ic.invite_id = i.invite_id
 		WHERE i.created_by_device_id = ?
-		ORDER BY ic.created_at DESC
-	`, createdByDeviceID)
+		  AND (? OR (ic.created_at, ic.invite_id, ic.device_id) < (?, ?, ?))
+		ORDER BY ic.created_at DESC, ic.invite_id DESC, ic.device_id DESC
+		LIMIT ?
+	`, createdByDeviceID, first, afterCreatedAt, afterID, afterDeviceID, page.sqlLimit())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var claims []*InviteClaimRow
 	for rows.Next() {
 		var claim InviteClaimRow
 		if err := rows.Scan(&claim.InviteID, &claim.VaultID, &claim.DeviceID, &claim.ClaimSig, &claim.CreatedAt); err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(&claim); err != nil {
+			return err
 		}
-		claims = append(claims, &claim)
 	}
-	return claims, rows.Err()
+	return rows.Err()
 }
 
 func (r *InvitesRepository) CheckNonceUsed(ctx context.Context, nonceType string, vaultID []byte, deviceID string, nonce []byte) (bool, error) {
@@ -177,3 +408,10 @@ func (r *InvitesRepository) RecordNonceUsed(ctx context.Context, nonceType strin
 	`, nonceType, vaultID, deviceID, nonce, time.Now().UTC().Format(time.RFC3339))
 	return err
 }
+
+func splitTargets(targets sql.NullString) []string {
+	if !targets.Valid || targets.String == "" {
+		return nil
+	}
+	return strings.Split(targets.String, ",")
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSweepKeepsExpiredInvites clears the payload of an expired invite, and
+// of one without expires_at that outlived the maximum lifetime, but keeps
+// the invite and its claim, while expired pairing slots are deleted.
+func TestSweepKeepsExpiredInvites(t *testing.T) {
+	ctx := context.Background()
+	database := openTestDB(t)
+	vaults := NewVaultsRepository(database)
+	invites := NewInvitesRepository(database)
+	pairingSlots := NewPairingSlotsRepository(database)
+
+	now := time.Now().UTC()
+	vaultID := fill(1, 16)
+	if err := vaults.Create(ctx, vaultID, "owner"); err != nil {
+		t.Fatalf("create vault: %v", err)
+	}
+	const maxLifetime = 7 * 24 * time.Hour
+	newInvite := func(id []byte, expiresAt, createdAt string) {
+		t.Helper()
+		if err := invites.Create(ctx, &InviteRow{
+			InviteID:               id,
+			VaultID:                vaultID,
+			TargetDeviceID:         "joiner",
+			TargetDevicePubkeySign: fill(6, 32),
+			TargetDevicePubkeyBox:  fill(7, 32),
+			TargetDeviceBundleSig:  fill(8, 64),
+			Nonce:                  fill(id[0], 24),
+			WrappedPayload:         fill(16, 64),
+			CreatedByDeviceID:      "owner",
+			SingleUse:              true,
+			Signature:              fill(17, 64),
+			MaxUses:                1,
+			ExpiresAt:              expiresAt,
+			CreatedAt:              createdAt,
+		}); err != nil {
+			t.Fatalf("create invite: %v", err)
+		}
+	}
+	expiredID, liveID, staleID, recentID := fill(3, 16), fill(4, 16), fill(5, 16), fill(9, 16)
+	newInvite(expiredID, now.Add(-time.Hour).Format(time.RFC3339), "")
+	newInvite(liveID, now.Add(time.Hour).Format(time.RFC3339), "")
+	newInvite(staleID, "", now.Add(-maxLifetime-time.Hour).Format(time.RFC3339))
+	newInvite(recentID, "", now.Add(-time.Hour).Format(time.RFC3339))
+	if err := invites.CreateClaim(ctx, &InviteClaimRow{
+		InviteID: expiredID,
+		VaultID:  vaultID,
+		DeviceID: "joiner",
+		ClaimSig: fill(18, 64),
+	}); err != nil {
+		t.Fatalf("create claim: %v", err)
+	}
+
+	for i, expiresAt := range []time.Time{now.Add(-time.Minute), now.Add(time.Minute)} {
+		if ok, err := pairingSlots.Create(ctx, &PairingSlotRow{
+			CodeHash:  fill(byte(20+i), 32),
+			DeviceID:  string(rune('a' + i)),
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+		}); err != nil || !ok {
+			t.Fatalf("create pairing slot: ok=%v err=%v", ok, err)
+		}
+	}
+
+	if err := invites.ClearExpiredPayloads(ctx, now, maxLifetime); err != nil {
+		t.Fatalf("clear expired payloads: %v", err)
+	}
+	if err := pairingSlots.PruneExpired(ctx, now); err != nil {
+		t.Fatalf("prune pairing slots: %v", err)
+	}
+
+	expired, err := invites.Get(ctx, expiredID)
+	if err != nil || expired == nil {
+		t.Fatalf("expired invite: want kept, got %+v (err %v)", expired, err)
+	}
+	if len(expired.WrappedPayload) != 0 {
+		t.Errorf("expired invite: want empty payload, got %d bytes", len(expired.WrappedPayload))
+	}
+	if c, err := invites.GetClaim(ctx, expiredID, "joiner"); err != nil || c == nil {
+		t.Errorf("expired invite claim: want kept, got %+v (err %v)", c, err)
+	}
+	if live, err := invites.Get(ctx, liveID); err != nil || len(live.WrappedPayload) != 64 {
+		t.Errorf("live invite: want payload kept, got %+v (err %v)", live, err)
+	}
+	if stale, err := invites.Get(ctx, staleID); err != nil || stale == nil || len(stale.WrappedPayload) != 0 {
+		t.Errorf("invite without expiry past the max lifetime: want kept with empty payload, got %+v (err %v)", stale, err)
+	}
+	if recent, err := invites.Get(ctx, recentID); err != nil || len(recent.WrappedPayload) != 64 {
+		t.Errorf("invite without expiry within the max lifetime: want payload kept, got %+v (err %v)", recent, err)
+	}
+
+	var slots int
+	if err := database.QueryRow("SELECT COUNT(*) FROM pairing_slots").Scan(&slots); err != nil {
+		t.Fatalf("count pairing slots: %v", err)
+	}
+	if slots != 1 {
+		t.Errorf("pairing slots: want only the live one, got %d", slots)
+	}
+}
@@ -34,13 +34,17 @@ type KeyUpdateAckRow struct {
 }
 
 type KeyUpdatesRepository struct {
-	db *db.DB
+	db querier
 }
 
 func NewKeyUpdatesRepository(database *db.DB) *KeyUpdatesRepository {
 	return &KeyUpdatesRepository{db: database}
 }
 
+func (r *KeyUpdatesRepository) WithTx(tx *sql.Tx) *KeyUpdatesRepository {
+	return &KeyUpdatesRepository{db: tx}
+}
+
 func (r *KeyUpdatesRepository) Create(ctx context.Context, ku *KeyUpdateRow) error {
 	if ku.CreatedAt == "" {
 		ku.CreatedAt = time.Now().UTC().Format(time.RFC3339)
@@ -69,26 +73,32 @@ func (r *KeyUpdatesRepository) Get(ctx context.Context, keyUpdateID []byte) (*Ke
 	return &ku, nil
 }
 
-func (r *KeyUpdatesRepository) ListByTargetDevice(ctx context.Context, targetDeviceID string) ([]*KeyUpdateRow, error) {
+// ListByTargetDevice calls fn for the device's key updates, newest first,
+// after the (created_at, key_update_id) position in page.After.
+func (r *KeyUpdatesRepository) ListByTargetDevice(ctx context.Context, targetDeviceID string, page Page, fn func(*KeyUpdateRow) error) error {
+	first, afterCreatedAt, afterID := page.afterKey()
 	rows, err := r.db.QueryContext(ctx, `
 		SELECT key_update_id, vault_id, member_seq, member_head_hash, target_device_id, key_epoch, nonce, wrapped_payload, created_by_device_id, signature, created_at
 		FROM key_updates WHERE target_device_id = ?
-		ORDER BY created_at DESC
-	`, targetDeviceID)
+		  AND (? OR (created_at, key_update_id) < (?, ?))
+		ORDER BY created_at DESC, key_update_id DESC
+		LIMIT ?
+	`, targetDeviceID, first, afterCreatedAt, afterID, page.sqlLimit())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var updates []*KeyUpdateRow
 	for rows.Next() {
 		var ku KeyUpdateRow
 		if err := rows.Scan(&ku.KeyUpdateID, &ku.VaultID, &ku.MemberSeq, &ku.MemberHeadHash, &ku.TargetDeviceID, &ku.KeyEpoch, &ku.Nonce, &ku.WrappedPayload, &ku.CreatedByDeviceID, &ku.Signature, &ku.CreatedAt); err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(&ku); err != nil {
+			return err
 		}
-		updates = append(updates, &ku)
 	}
-	return updates, rows.Err()
+	return rows.Err()
 }
 
 func (r *KeyUpdatesRepository) CheckExists(ctx context.Context, vaultID []byte, keyEpoch uint64, targetDeviceID string) (bool, error) {
@@ -102,6 +112,95 @@ func (r *KeyUpdatesRepository) CheckExists(ctx context.Context, vaultID []byte,
 	return count > 0, nil
 }
 
+// CheckExistsSince reports whether the device has a key update at keyEpoch
+// bound to member_seq minMemberSeq or later.
+func (r *KeyUpdatesRepository) CheckExistsSince(ctx context.Context, vaultID []byte, keyEpoch uint64, targetDeviceID string, minMemberSeq uint64) (bool, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM key_updates
+		WHERE vault_id = ? AND key_epoch = ? AND target_device_id = ? AND member_seq >= ?
+	`, vaultID, keyEpoch, targetDeviceID, minMemberSeq).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CountPendingMembers returns how many current members of the vault lack
+// either a key update at keyEpoch bound to member_seq minMemberSeq or later,
+// or their ack of keyEpoch.
+func (r *KeyUpdatesRepository) CountPendingMembers(ctx context.Context, vaultID []byte, keyEpoch, minMemberSeq uint64) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM vault_members m
+		WHERE m.vault_id = ? AND m.is_member = 1
+		  AND (
+			NOT EXISTS (
+				SELECT 1 FROM key_updates k
+				WHERE k.vault_id = m.vault_id AND k.key_epoch = ? AND k.target_device_id = m.device_id
+				  AND k.member_seq >= ?
+			)
+			OR NOT EXISTS (
+				SELECT 1 FROM key_update_acks a
+				WHERE a.vault_id = m.vault_id AND a.key_epoch = ? AND a.device_id = m.device_id
+			)
+		  )
+	`, vaultID, keyEpoch, minMemberSeq, keyEpoch).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// LatestEpochSince returns the highest key epoch with a key update bound to
+// member_seq minMemberSeq or later, or 0 if there is none.
+func (r *KeyUpdatesRepository) LatestEpochSince(ctx context.Context, vaultID []byte, minMemberSeq uint64) (uint64, error) {
+	var epoch uint64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(key_epoch), 0) FROM key_updates WHERE vault_id = ? AND member_seq >= ?
+	`, vaultID, minMemberSeq).Scan(&epoch)
+	if err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// LatestEpochBefore returns the highest key epoch of any key update bound to
+// a member_seq before memberSeq, or 0 if there is none.
+func (r *KeyUpdatesRepository) LatestEpochBefore(ctx context.Context, vaultID []byte, memberSeq uint64) (uint64, error) {
+	var epoch uint64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(key_epoch), 0) FROM key_updates WHERE vault_id = ? AND member_seq < ?
+	`, vaultID, memberSeq).Scan(&epoch)
+	if err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// ListTargets returns the devices with a key update at keyEpoch bound to
+// member_seq minMemberSeq or later.
+func (r *KeyUpdatesRepository) ListTargets(ctx context.Context, vaultID []byte, keyEpoch, minMemberSeq uint64) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT DISTINCT target_device_id FROM key_updates
+		WHERE vault_id = ? AND key_epoch = ? AND member_seq >= ?
+	`, vaultID, keyEpoch, minMemberSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, err
+		}
+		targets = append(targets, deviceID)
+	}
+	return targets, rows.Err()
+}
+
 func (r *KeyUpdatesRepository) CreateAck(ctx context.Context, ack *KeyUpdateAckRow) error {
 	if ack.CreatedAt == "" {
 		ack.CreatedAt = time.Now().UTC().Format(time.RFC3339)
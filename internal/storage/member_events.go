@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
 
 	"forgor-server/internal/db"
@@ -20,19 +22,25 @@ type MemberEventRow struct {
 	SubjectBundleSig  []byte
 	InviteID          []byte
 	ClaimSig          []byte
+	Role              string
+	Quorum            uint64
 	Signature         []byte
 	MemberHash        []byte
 	CreatedAt         string
 }
 
 type MemberEventsRepository struct {
-	db *db.DB
+	db querier
 }
 
 func NewMemberEventsRepository(database *db.DB) *MemberEventsRepository {
 	return &MemberEventsRepository{db: database}
 }
 
+func (r *MemberEventsRepository) WithTx(tx *sql.Tx) *MemberEventsRepository {
+	return &MemberEventsRepository{db: tx}
+}
+
 func (r *MemberEventsRepository) Create(ctx context.Context, e *MemberEventRow) error {
 	if e.CreatedAt == "" {
 		e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
@@ -41,53 +49,78 @@ func (r *MemberEventsRepository) Create(ctx context.Context, e *MemberEventRow)
 		INSERT INTO member_events (
 			member_event_id, vault_id, member_seq, prev_hash, actor_device_id, subject_device_id,
 			msg_type, subject_pubkey_sign, subject_pubkey_box, subject_bundle_sig, invite_id, claim_sig,
-			signature, member_hash, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			role, quorum, signature, member_hash, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, e.MemberEventID, e.VaultID, e.MemberSeq, e.PrevHash, e.ActorDeviceID, e.SubjectDeviceID,
 		e.MsgType, e.SubjectPubkeySign, e.SubjectPubkeyBox, e.SubjectBundleSig, e.InviteID, e.ClaimSig,
-		e.Signature, e.MemberHash, e.CreatedAt)
+		e.Role, e.Quorum, e.Signature, e.MemberHash, e.CreatedAt)
 	return err
 }
 
-func (r *MemberEventsRepository) ListSince(ctx context.Context, vaultID []byte, sinceSeq uint64) ([]*MemberEventRow, error) {
+// ListSince calls fn for each member event after page.After.Seq in
+// member_seq order, stopping at the first error fn returns.
+func (r *MemberEventsRepository) ListSince(ctx context.Context, vaultID []byte, page Page, fn func(*MemberEventRow) error) error {
 	rows, err := r.db.QueryContext(ctx, `
 		SELECT member_event_id, vault_id, member_seq, prev_hash, actor_device_id, subject_device_id,
 			   msg_type, subject_pubkey_sign, subject_pubkey_box, subject_bundle_sig, invite_id, claim_sig,
-			   signature, member_hash, created_at
+			   role, quorum, signature, member_hash, created_at
 		FROM member_events
 		WHERE vault_id = ? AND member_seq > ?
 		ORDER BY member_seq ASC
-	`, vaultID, sinceSeq)
+		LIMIT ?
+	`, vaultID, page.afterSeq(), page.sqlLimit())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var events []*MemberEventRow
 	for rows.Next() {
 		var e MemberEventRow
 		if err := rows.Scan(&e.MemberEventID, &e.VaultID, &e.MemberSeq, &e.PrevHash, &e.ActorDeviceID, &e.SubjectDeviceID,
 			&e.MsgType, &e.SubjectPubkeySign, &e.SubjectPubkeyBox, &e.SubjectBundleSig, &e.InviteID, &e.ClaimSig,
-			&e.Signature, &e.MemberHash, &e.CreatedAt); err != nil {
-			return nil, err
+			&e.Role, &e.Quorum, &e.Signature, &e.MemberHash, &e.CreatedAt); err != nil {
+			return err
 		}
-		events = append(events, &e)
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *MemberEventsRepository) GetBySeq(ctx context.Context, vaultID []byte, memberSeq uint64) (*MemberEventRow, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT member_event_id, vault_id, member_seq, prev_hash, actor_device_id, subject_device_id,
+			   msg_type, subject_pubkey_sign, subject_pubkey_box, subject_bundle_sig, invite_id, claim_sig,
+			   role, quorum, signature, member_hash, created_at
+		FROM member_events WHERE vault_id = ? AND member_seq = ?
+	`, vaultID, memberSeq)
+
+	var e MemberEventRow
+	err := row.Scan(&e.MemberEventID, &e.VaultID, &e.MemberSeq, &e.PrevHash, &e.ActorDeviceID, &e.SubjectDeviceID,
+		&e.MsgType, &e.SubjectPubkeySign, &e.SubjectPubkeyBox, &e.SubjectBundleSig, &e.InviteID, &e.ClaimSig,
+		&e.Role, &e.Quorum, &e.Signature, &e.MemberHash, &e.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
 	}
-	return events, rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
 }
 
 func (r *MemberEventsRepository) GetByID(ctx context.Context, memberEventID []byte) (*MemberEventRow, error) {
 	row := r.db.QueryRowContext(ctx, `
 		SELECT member_event_id, vault_id, member_seq, prev_hash, actor_device_id, subject_device_id,
 			   msg_type, subject_pubkey_sign, subject_pubkey_box, subject_bundle_sig, invite_id, claim_sig,
-			   signature, member_hash, created_at
+			   role, quorum, signature, member_hash, created_at
 		FROM member_events WHERE member_event_id = ?
 	`, memberEventID)
 
 	var e MemberEventRow
 	err := row.Scan(&e.MemberEventID, &e.VaultID, &e.MemberSeq, &e.PrevHash, &e.ActorDeviceID, &e.SubjectDeviceID,
 		&e.MsgType, &e.SubjectPubkeySign, &e.SubjectPubkeyBox, &e.SubjectBundleSig, &e.InviteID, &e.ClaimSig,
-		&e.Signature, &e.MemberHash, &e.CreatedAt)
+		&e.Role, &e.Quorum, &e.Signature, &e.MemberHash, &e.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"forgor-server/internal/db"
+)
+
+const (
+	ProposalPending    = "pending"
+	ProposalApplied    = "applied"
+	ProposalSuperseded = "superseded"
+)
+
+// MemberProposalRow is a validated member event waiting for enough admin
+// signatures to be appended to the membership log.
+type MemberProposalRow struct {
+	MemberEventRow
+	Status string
+}
+
+type MemberProposalSignatureRow struct {
+	MemberEventID []byte
+	DeviceID      string
+	Signature     []byte
+	CreatedAt     string
+}
+
+type MemberProposalsRepository struct {
+	db querier
+}
+
+func NewMemberProposalsRepository(database *db.DB) *MemberProposalsRepository {
+	return &MemberProposalsRepository{db: database}
+}
+
+func (r *MemberProposalsRepository) WithTx(tx *sql.Tx) *MemberProposalsRepository {
+	return &MemberProposalsRepository{db: tx}
+}
+
+func (r *MemberProposalsRepository) Create(ctx context.Context, e *MemberEventRow) error {
+	if e.CreatedAt == "" {
+		e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO member_proposals (
+			member_event_id, vault_id, member_seq, prev_hash, actor_device_id, subject_device_id,
+			msg_type, subject_pubkey_sign, subject_pubkey_box, subject_bundle_sig, invite_id, claim_sig,
+			role, quorum, signature, member_hash, status, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.MemberEventID, e.VaultID, e.MemberSeq, e.PrevHash, e.ActorDeviceID, e.SubjectDeviceID,
+		e.MsgType, e.SubjectPubkeySign, e.SubjectPubkeyBox, e.SubjectBundleSig, e.InviteID, e.ClaimSig,
+		e.Role, e.Quorum, e.Signature, e.MemberHash, ProposalPending, e.CreatedAt)
+	return err
+}
+
+func (r *MemberProposalsRepository) Get(ctx context.Context, memberEventID []byte) (*MemberProposalRow, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT member_event_id, vault_id, member_seq, prev_hash, actor_device_id, subject_device_id,
+			   msg_type, subject_pubkey_sign, subject_pubkey_box, subject_bundle_sig, invite_id, claim_sig,
+			   role, quorum, signature, member_hash, status, created_at
+		FROM member_proposals WHERE member_event_id = ?
+	`, memberEventID)
+
+	var p MemberProposalRow
+	err := row.Scan(&p.MemberEventID, &p.VaultID, &p.MemberSeq, &p.PrevHash, &p.ActorDeviceID, &p.SubjectDeviceID,
+		&p.MsgType, &p.SubjectPubkeySign, &p.SubjectPubkeyBox, &p.SubjectBundleSig, &p.InviteID, &p.ClaimSig,
+		&p.Role, &p.Quorum, &p.Signature, &p.MemberHash, &p.Status, &p.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListPending returns the vault's pending proposals in member_seq order.
+func (r *MemberProposalsRepository) ListPending(ctx context.Context, vaultID []byte) ([]*MemberProposalRow, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT member_event_id, vault_id, member_seq, prev_hash, actor_device_id, subject_device_id,
+			   msg_type, subject_pubkey_sign, subject_pubkey_box, subject_bundle_sig, invite_id, claim_sig,
+			   role, quorum, signature, member_hash, status, created_at
+		FROM member_proposals
+		WHERE vault_id = ? AND status = ?
+		ORDER BY member_seq ASC, created_at ASC
+	`, vaultID, ProposalPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var proposals []*MemberProposalRow
+	for rows.Next() {
+		var p MemberProposalRow
+		if err := rows.Scan(&p.MemberEventID, &p.VaultID, &p.MemberSeq, &p.PrevHash, &p.ActorDeviceID, &p.SubjectDeviceID,
+			&p.MsgType, &p.SubjectPubkeySign, &p.SubjectPubkeyBox, &p.SubjectBundleSig, &p.InviteID, &p.ClaimSig,
+			&p.Role, &p.Quorum, &p.Signature, &p.MemberHash, &p.Status, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, &p)
+	}
+	return proposals, rows.Err()
+}
+
+func (r *MemberProposalsRepository) SetStatus(ctx context.Context, memberEventID []byte, status string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE member_proposals SET status = ? WHERE member_event_id = ?
+	`, status, memberEventID)
+	return err
+}
+
+// SupersedePending marks pending proposals at or below memberSeq as
+// superseded: the membership log has moved past their position, so they can
+// never be appended.
+func (r *MemberProposalsRepository) SupersedePending(ctx context.Context, vaultID []byte, memberSeq uint64) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE member_proposals SET status = ?
+		WHERE vault_id = ? AND status = ? AND member_seq <= ?
+	`, ProposalSuperseded, vaultID, ProposalPending, memberSeq)
+	return err
+}
+
+// AddSignature records a device's signature on a proposal. It reports false
+// if the device had already signed it.
+func (r *MemberProposalsRepository) AddSignature(ctx context.Context, s *MemberProposalSignatureRow) (bool, error) {
+	if s.CreatedAt == "" {
+		s.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO member_proposal_signatures (member_event_id, device_id, signature, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(member_event_id, device_id) DO NOTHING
+	`, s.MemberEventID, s.DeviceID, s.Signature, s.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *MemberProposalsRepository) ListSignatures(ctx context.Context, memberEventID []byte) ([]*MemberProposalSignatureRow, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT member_event_id, device_id, signature, created_at
+		FROM member_proposal_signatures
+		WHERE member_event_id = ?
+		ORDER BY created_at ASC, device_id ASC
+	`, memberEventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sigs []*MemberProposalSignatureRow
+	for rows.Next() {
+		var s MemberProposalSignatureRow
+		if err := rows.Scan(&s.MemberEventID, &s.DeviceID, &s.Signature, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, &s)
+	}
+	return sigs, rows.Err()
+}
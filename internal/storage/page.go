@@ -0,0 +1,42 @@
+package storage
+
+// Cursor is a keyset position in a listing. Listings ordered by seq use Seq;
+// listings ordered newest first use CreatedAt plus the row's id (and DeviceID
+// where the id alone is not unique) as a tie-breaker.
+type Cursor struct {
+	Seq       uint64
+	CreatedAt string
+	ID        []byte
+	DeviceID  string
+}
+
+// Page bounds a listing: rows strictly after After (nil for the first page),
+// at most Limit of them (0 for no limit).
+type Page struct {
+	After *Cursor
+	Limit int
+}
+
+// sqlLimit maps Limit to a LIMIT argument; SQLite treats -1 as unbounded.
+func (p Page) sqlLimit() int {
+	if p.Limit <= 0 {
+		return -1
+	}
+	return p.Limit
+}
+
+func (p Page) afterSeq() uint64 {
+	if p.After == nil {
+		return 0
+	}
+	return p.After.Seq
+}
+
+// afterKey returns the arguments for a `(? OR (created_at, id) < (?, ?))`
+// clause on newest-first listings.
+func (p Page) afterKey() (bool, string, []byte) {
+	if p.After == nil {
+		return true, "", nil
+	}
+	return false, p.After.CreatedAt, p.After.ID
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"forgor-server/internal/db"
+)
+
+// PairingSlotRow lets an admin find a new device's bundle by a short code
+// instead of its full device ID.
+type PairingSlotRow struct {
+	CodeHash           []byte
+	DeviceID           string
+	ExpiresAt          string
+	LookedUpByDeviceID string
+	CreatedAt          string
+}
+
+type PairingSlotsRepository struct {
+	db querier
+}
+
+func NewPairingSlotsRepository(database *db.DB) *PairingSlotsRepository {
+	return &PairingSlotsRepository{db: database}
+}
+
+func (r *PairingSlotsRepository) WithTx(tx *sql.Tx) *PairingSlotsRepository {
+	return &PairingSlotsRepository{db: tx}
+}
+
+// Create replaces the device's previous slot with slot. It reports
+// false if another live slot already uses the same code hash.
+func (r *PairingSlotsRepository) Create(ctx context.Context, slot *PairingSlotRow) (bool, error) {
+	if slot.CreatedAt == "" {
+		slot.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if _, err := r.db.ExecContext(ctx, `
+		DELETE FROM pairing_slots WHERE device_id = ?
+	`, slot.DeviceID); err != nil {
+		return false, err
+	}
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO pairing_slots (code_hash, device_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(code_hash) DO UPDATE SET
+			device_id = excluded.device_id,
+			expires_at = excluded.expires_at,
+			looked_up_by_device_id = '',
+			created_at = excluded.created_at
+		WHERE pairing_slots.expires_at <= excluded.created_at
+	`, slot.CodeHash, slot.DeviceID, slot.ExpiresAt, slot.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Take returns the live slot for codeHash and marks it looked up
+// by deviceID, so each code resolves once. It returns nil if there is no
+// such slot or it has already been looked up.
+func (r *PairingSlotsRepository) Take(ctx context.Context, codeHash []byte, deviceID string) (*PairingSlotRow, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE pairing_slots SET looked_up_by_device_id = ?
+		WHERE code_hash = ? AND looked_up_by_device_id = '' AND expires_at > ?
+	`, deviceID, codeHash, now)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, nil
+	}
+
+	row := r.db.QueryRowContext(ctx, `
+		SELECT code_hash, device_id, expires_at, looked_up_by_device_id, created_at
+		FROM pairing_slots WHERE code_hash = ?
+	`, codeHash)
+
+	var slot PairingSlotRow
+	err = row.Scan(&slot.CodeHash, &slot.DeviceID, &slot.ExpiresAt, &slot.LookedUpByDeviceID, &slot.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &slot, nil
+}
+
+// PruneExpired deletes slots that expired before now. A slot only ever
+// points at a device bundle, so nothing of it is worth keeping.
+func (r *PairingSlotsRepository) PruneExpired(ctx context.Context, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM pairing_slots WHERE expires_at < ?
+	`, now.UTC().Format(time.RFC3339))
+	return err
+}
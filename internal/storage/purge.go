@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// MarkDeleted tombstones the vault. It reports false if the vault was
+// already deleted.
+func (r *VaultsRepository) MarkDeleted(ctx context.Context, vaultID []byte, deviceID string, signature []byte, deletedAt string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE vaults SET deleted_at = ?, deleted_by_device_id = ?, delete_signature = ?, updated_at = ?
+		WHERE vault_id = ? AND deleted_at = ''
+	`, deletedAt, deviceID, signature, deletedAt, vaultID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// ListPurgeable returns the vaults deleted before deletedBefore whose data
+// has not been purged yet.
+func (r *VaultsRepository) ListPurgeable(ctx context.Context, deletedBefore time.Time) ([][]byte, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT vault_id FROM vaults
+		WHERE deleted_at != '' AND deleted_at <= ? AND purged_at = ''
+		ORDER BY deleted_at ASC
+	`, deletedBefore.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vaultIDs [][]byte
+	for rows.Next() {
+		var vaultID []byte
+		if err := rows.Scan(&vaultID); err != nil {
+			return nil, err
+		}
+		vaultIDs = append(vaultIDs, vaultID)
+	}
+	return vaultIDs, rows.Err()
+}
+
+// purgeStatements remove everything a deleted vault stored except what is
+// deliberately kept, for as long as the server keeps the vault (nothing
+// removes it later):
+//   - the vaults row, including delete_signature as the owner's signed
+//     request for the deletion;
+//   - vault_members and vault_membership_heads, so former members keep
+//     getting 410 for the vault rather than 403 or 404.
+//
+// Rows that are not scoped to a vault are left to their own cleanup:
+// request_nonces age out after twice the allowed clock skew, and
+// pairing_slots once they expire. Invite and key update nonces are kept in
+// used_nonces and go with the vault.
+var purgeStatements = []string{
+	`DELETE FROM events WHERE vault_id = ?`,
+	`DELETE FROM event_heads WHERE vault_id = ?`,
+	`DELETE FROM snapshots WHERE vault_id = ?`,
+	`DELETE FROM key_updates WHERE vault_id = ?`,
+	`DELETE FROM key_update_acks WHERE vault_id = ?`,
+	`DELETE FROM vault_key_epochs WHERE vault_id = ?`,
+	`DELETE FROM invite_claims WHERE vault_id = ?`,
+	`DELETE FROM invite_targets WHERE invite_id IN (SELECT invite_id FROM invites WHERE vault_id = ?)`,
+	`DELETE FROM invites WHERE vault_id = ?`,
+	`DELETE FROM member_events WHERE vault_id = ?`,
+	`DELETE FROM member_proposal_signatures WHERE member_event_id IN (SELECT member_event_id FROM member_proposals WHERE vault_id = ?)`,
+	`DELETE FROM member_proposals WHERE vault_id = ?`,
+	`DELETE FROM equivocations WHERE vault_id = ?`,
+	`DELETE FROM used_nonces WHERE vault_id = ?`,
+}
+
+// Purge deletes a tombstoned vault's data and records when it did. Run it
+// in a transaction.
+func (r *VaultsRepository) Purge(ctx context.Context, vaultID []byte) error {
+	for _, stmt := range purgeStatements {
+		if _, err := r.db.ExecContext(ctx, stmt, vaultID); err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE vaults SET purged_at = ?, updated_at = ? WHERE vault_id = ? AND deleted_at != ''
+	`, now, now, vaultID)
+	return err
+}
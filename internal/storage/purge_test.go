@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPurgeKeepsTombstoneAndMembers(t *testing.T) {
+	ctx := context.Background()
+	database := openTestDB(t)
+	vaults := NewVaultsRepository(database)
+	events := NewEventsRepository(database)
+
+	vaultID := fill(1, 16)
+	headHash := fill(2, 32)
+	deleteSig := fill(3, 64)
+	if err := vaults.Create(ctx, vaultID, "owner"); err != nil {
+		t.Fatalf("create vault: %v", err)
+	}
+	if ok, err := vaults.AdvanceMembershipHead(ctx, vaultID, 0, nil, 1, headHash); err != nil || !ok {
+		t.Fatalf("membership head: ok=%v err=%v", ok, err)
+	}
+	if err := vaults.UpsertMember(ctx, &VaultMemberRow{
+		VaultID:          vaultID,
+		DeviceID:         "owner",
+		DevicePubkeySign: fill(4, 32),
+		DevicePubkeyBox:  fill(5, 32),
+		SubjectBundleSig: fill(6, 64),
+		IsMember:         true,
+		KeyEpoch:         1,
+		Role:             "owner",
+	}); err != nil {
+		t.Fatalf("upsert member: %v", err)
+	}
+	if _, err := events.Create(ctx, &EventRow{
+		EventID:    fill(7, 16),
+		EventHash:  fill(8, 32),
+		VaultID:    vaultID,
+		DeviceID:   "owner",
+		Counter:    1,
+		Lamport:    1,
+		KeyEpoch:   1,
+		PrevHash:   fill(0, 32),
+		Nonce:      fill(9, 24),
+		Ciphertext: fill(10, 100),
+		Signature:  fill(11, 64),
+	}); err != nil {
+		t.Fatalf("create event: %v", err)
+	}
+
+	deletedAt := time.Now().UTC().Format(time.RFC3339)
+	if ok, err := vaults.MarkDeleted(ctx, vaultID, "owner", deleteSig, deletedAt); err != nil || !ok {
+		t.Fatalf("mark deleted: ok=%v err=%v", ok, err)
+	}
+	if err := vaults.Purge(ctx, vaultID); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+
+	vault, err := vaults.Get(ctx, vaultID)
+	if err != nil || vault == nil {
+		t.Fatalf("get vault: %+v (err %v)", vault, err)
+	}
+	if vault.DeletedAt != deletedAt || vault.PurgedAt == "" {
+		t.Errorf("tombstone: want deleted_at %s and purged_at set, got %q / %q", deletedAt, vault.DeletedAt, vault.PurgedAt)
+	}
+	var sig []byte
+	if err := database.QueryRow(`SELECT delete_signature FROM vaults WHERE vault_id = ?`, vaultID).Scan(&sig); err != nil || !bytes.Equal(sig, deleteSig) {
+		t.Errorf("delete_signature not kept (err %v)", err)
+	}
+
+	if m, err := vaults.GetMember(ctx, vaultID, "owner"); err != nil || m == nil || m.Role != "owner" {
+		t.Errorf("vault_members: want the owner row kept, got %+v (err %v)", m, err)
+	}
+	if head, err := vaults.GetMembershipHead(ctx, vaultID); err != nil || head == nil || !bytes.Equal(head.MemberHeadHash, headHash) {
+		t.Errorf("membership head: want it kept, got %+v (err %v)", head, err)
+	}
+
+	if latest, err := events.LatestSeq(ctx, vaultID); err != nil || latest != 0 {
+		t.Errorf("events: want purged, got seq %d (err %v)", latest, err)
+	}
+}
@@ -0,0 +1,14 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// querier is satisfied by both *db.DB and *sql.Tx, so every repository can
+// run standalone or be bound to a caller's transaction with WithTx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
@@ -27,13 +27,17 @@ type SnapshotRow struct {
 }
 
 type SnapshotsRepository struct {
-	db *db.DB
+	db querier
 }
 
 func NewSnapshotsRepository(database *db.DB) *SnapshotsRepository {
 	return &SnapshotsRepository{db: database}
 }
 
+func (r *SnapshotsRepository) WithTx(tx *sql.Tx) *SnapshotsRepository {
+	return &SnapshotsRepository{db: tx}
+}
+
 func (r *SnapshotsRepository) Create(ctx context.Context, s *SnapshotRow) error {
 	if s.CreatedAt == "" {
 		s.CreatedAt = time.Now().UTC().Format(time.RFC3339)
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"forgor-server/internal/db"
+)
+
+func openTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), "forgor.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func fill(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+// TestWithTxRollsBackAfterHeadCAS runs the writes of a member_add and an
+// event append in one transaction and fails the last one; none of the
+// earlier writes, including both head CASes, may survive.
+func TestWithTxRollsBackAfterHeadCAS(t *testing.T) {
+	ctx := context.Background()
+	database := openTestDB(t)
+	vaults := NewVaultsRepository(database)
+	memberEvents := NewMemberEventsRepository(database)
+	invites := NewInvitesRepository(database)
+	events := NewEventsRepository(database)
+
+	vaultID := fill(1, 16)
+	genesisHash := fill(2, 32)
+	if err := vaults.Create(ctx, vaultID, "owner"); err != nil {
+		t.Fatalf("create vault: %v", err)
+	}
+	if ok, err := vaults.AdvanceMembershipHead(ctx, vaultID, 0, nil, 1, genesisHash); err != nil || !ok {
+		t.Fatalf("genesis head: ok=%v err=%v", ok, err)
+	}
+	inviteID := fill(3, 16)
+	if err := invites.Create(ctx, &InviteRow{
+		InviteID:               inviteID,
+		VaultID:                vaultID,
+		TargetDeviceID:         "joiner",
+		TargetDevicePubkeySign: fill(6, 32),
+		TargetDevicePubkeyBox:  fill(7, 32),
+		TargetDeviceBundleSig:  fill(8, 64),
+		Nonce:                  fill(15, 24),
+		WrappedPayload:         fill(16, 64),
+		CreatedByDeviceID:      "owner",
+		SingleUse:              true,
+		Signature:              fill(17, 64),
+		MaxUses:                1,
+	}); err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+
+	if _, err := database.Exec(`
+		CREATE TRIGGER test_fail_events BEFORE INSERT ON events
+		BEGIN SELECT RAISE(ABORT, 'injected failure'); END
+	`); err != nil {
+		t.Fatalf("install trigger: %v", err)
+	}
+
+	err := database.WithTx(ctx, func(tx *sql.Tx) error {
+		if ok, err := vaults.WithTx(tx).AdvanceMembershipHead(ctx, vaultID, 1, genesisHash, 2, fill(4, 32)); err != nil || !ok {
+			t.Fatalf("advance membership head: ok=%v err=%v", ok, err)
+		}
+		if err := memberEvents.WithTx(tx).Create(ctx, &MemberEventRow{
+			MemberEventID:   fill(5, 16),
+			VaultID:         vaultID,
+			MemberSeq:       2,
+			PrevHash:        genesisHash,
+			ActorDeviceID:   "owner",
+			SubjectDeviceID: "joiner",
+			MsgType:         "member_add",
+			InviteID:        inviteID,
+			Signature:       fill(11, 64),
+			MemberHash:      fill(4, 32),
+		}); err != nil {
+			t.Fatalf("create member event: %v", err)
+		}
+		if err := vaults.WithTx(tx).UpsertMember(ctx, &VaultMemberRow{
+			VaultID:          vaultID,
+			DeviceID:         "joiner",
+			DevicePubkeySign: fill(6, 32),
+			DevicePubkeyBox:  fill(7, 32),
+			SubjectBundleSig: fill(8, 64),
+			IsMember:         true,
+			KeyEpoch:         1,
+			Role:             "member",
+		}); err != nil {
+			t.Fatalf("upsert member: %v", err)
+		}
+		if ok, err := invites.WithTx(tx).MarkUsed(ctx, inviteID); err != nil || !ok {
+			t.Fatalf("mark invite used: ok=%v err=%v", ok, err)
+		}
+		head := &EventHead{VaultID: vaultID, DeviceID: "owner", LastCounter: 1, LastHash: fill(9, 32)}
+		if ok, err := events.WithTx(tx).AdvanceEventHead(ctx, head, 0, nil); err != nil || !ok {
+			t.Fatalf("advance event head: ok=%v err=%v", ok, err)
+		}
+		_, err := events.WithTx(tx).Create(ctx, &EventRow{
+			EventID:    fill(10, 16),
+			EventHash:  fill(9, 32),
+			VaultID:    vaultID,
+			DeviceID:   "owner",
+			Counter:    1,
+			Lamport:    1,
+			KeyEpoch:   1,
+			PrevHash:   fill(0, 32),
+			Nonce:      fill(12, 24),
+			Ciphertext: fill(13, 100),
+			Signature:  fill(14, 64),
+		})
+		return err
+	})
+	if err == nil {
+		t.Fatal("WithTx: want the injected failure, got nil")
+	}
+
+	head, err := vaults.GetMembershipHead(ctx, vaultID)
+	if err != nil {
+		t.Fatalf("get membership head: %v", err)
+	}
+	if head.MemberSeq != 1 || !bytes.Equal(head.MemberHeadHash, genesisHash) {
+		t.Errorf("membership head: want seq 1 at genesis, got seq %d", head.MemberSeq)
+	}
+	if e, err := memberEvents.GetBySeq(ctx, vaultID, 2); err != nil || e != nil {
+		t.Errorf("member_events: want no seq 2, got %+v (err %v)", e, err)
+	}
+	if m, err := vaults.GetMember(ctx, vaultID, "joiner"); err != nil || m != nil {
+		t.Errorf("vault_members: want no joiner row, got %+v (err %v)", m, err)
+	}
+	if inv, err := invites.Get(ctx, inviteID); err != nil || inv.UseCount != 0 {
+		t.Errorf("invites.use_count: want 0, got %+v (err %v)", inv, err)
+	}
+	if h, err := events.GetEventHead(ctx, vaultID, "owner"); err != nil || h != nil {
+		t.Errorf("event_heads: want no head, got %+v (err %v)", h, err)
+	}
+	if latest, err := events.LatestSeq(ctx, vaultID); err != nil || latest != 0 {
+		t.Errorf("events: want none, got seq %d (err %v)", latest, err)
+	}
+}
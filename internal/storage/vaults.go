@@ -12,8 +12,19 @@ import (
 type VaultRow struct {
 	VaultID       []byte
 	OwnerDeviceID string
-	CreatedAt     string
-	UpdatedAt     string
+	KeyEpoch      uint64
+	// RotationPendingSeq is the member_seq of a removal the vault key has
+	// not been rotated away from yet, or 0.
+	RotationPendingSeq uint64
+	// MemberQuorum is the number of admin signatures a membership change
+	// needs; 1 means the proposer's alone.
+	MemberQuorum uint64
+	// DeletedAt is set once the owner has deleted the vault; PurgedAt once
+	// its data has been removed.
+	DeletedAt string
+	PurgedAt  string
+	CreatedAt string
+	UpdatedAt string
 }
 
 type VaultMembershipHead struct {
@@ -22,6 +33,16 @@ type VaultMembershipHead struct {
 	MemberHeadHash []byte
 }
 
+// VaultKeyEpochRow binds a completed key epoch to the membership head it was
+// completed at.
+type VaultKeyEpochRow struct {
+	VaultID        []byte
+	KeyEpoch       uint64
+	MemberSeq      uint64
+	MemberHeadHash []byte
+	CompletedAt    string
+}
+
 type VaultMemberRow struct {
 	VaultID          []byte
 	DeviceID         string
@@ -30,24 +51,30 @@ type VaultMemberRow struct {
 	SubjectBundleSig []byte
 	IsMember         bool
 	KeyEpoch         uint64
+	Frozen           bool
+	Role             string
 }
 
 type VaultsRepository struct {
-	db *db.DB
+	db querier
 }
 
 func NewVaultsRepository(database *db.DB) *VaultsRepository {
 	return &VaultsRepository{db: database}
 }
 
+func (r *VaultsRepository) WithTx(tx *sql.Tx) *VaultsRepository {
+	return &VaultsRepository{db: tx}
+}
+
 func (r *VaultsRepository) Get(ctx context.Context, vaultID []byte) (*VaultRow, error) {
 	row := r.db.QueryRowContext(ctx, `
-		SELECT vault_id, owner_device_id, created_at, updated_at
+		SELECT vault_id, owner_device_id, key_epoch, rotation_pending_seq, member_quorum, deleted_at, purged_at, created_at, updated_at
 		FROM vaults WHERE vault_id = ?
 	`, vaultID)
 
 	var v VaultRow
-	err := row.Scan(&v.VaultID, &v.OwnerDeviceID, &v.CreatedAt, &v.UpdatedAt)
+	err := row.Scan(&v.VaultID, &v.OwnerDeviceID, &v.KeyEpoch, &v.RotationPendingSeq, &v.MemberQuorum, &v.DeletedAt, &v.PurgedAt, &v.CreatedAt, &v.UpdatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -66,6 +93,80 @@ func (r *VaultsRepository) Create(ctx context.Context, vaultID []byte, ownerDevi
 	return err
 }
 
+// AdvanceKeyEpoch makes keyEpoch the vault's current key epoch and clears a
+// pending rotation. The epoch never moves backwards; it reports whether it
+// moved.
+func (r *VaultsRepository) AdvanceKeyEpoch(ctx context.Context, vaultID []byte, keyEpoch uint64) (bool, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE vaults SET key_epoch = ?, rotation_pending_seq = 0, updated_at = ?
+		WHERE vault_id = ? AND key_epoch < ?
+	`, keyEpoch, now, vaultID, keyEpoch)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *VaultsRepository) SetOwner(ctx context.Context, vaultID []byte, ownerDeviceID string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE vaults SET owner_device_id = ?, updated_at = ? WHERE vault_id = ?
+	`, ownerDeviceID, now, vaultID)
+	return err
+}
+
+// SetRotationPending records that the removal at memberSeq requires the vault
+// key to be rotated; only key updates bound to that membership or a later one
+// count towards the rotation.
+func (r *VaultsRepository) SetRotationPending(ctx context.Context, vaultID []byte, memberSeq uint64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE vaults SET rotation_pending_seq = ?, updated_at = ? WHERE vault_id = ?
+	`, memberSeq, now, vaultID)
+	return err
+}
+
+func (r *VaultsRepository) SetMemberQuorum(ctx context.Context, vaultID []byte, quorum uint64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE vaults SET member_quorum = ?, updated_at = ? WHERE vault_id = ?
+	`, quorum, now, vaultID)
+	return err
+}
+
+func (r *VaultsRepository) CreateKeyEpoch(ctx context.Context, e *VaultKeyEpochRow) error {
+	if e.CompletedAt == "" {
+		e.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO vault_key_epochs (vault_id, key_epoch, member_seq, member_head_hash, completed_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, e.VaultID, e.KeyEpoch, e.MemberSeq, e.MemberHeadHash, e.CompletedAt)
+	return err
+}
+
+func (r *VaultsRepository) GetKeyEpoch(ctx context.Context, vaultID []byte, keyEpoch uint64) (*VaultKeyEpochRow, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT vault_id, key_epoch, member_seq, member_head_hash, completed_at
+		FROM vault_key_epochs WHERE vault_id = ? AND key_epoch = ?
+	`, vaultID, keyEpoch)
+
+	var e VaultKeyEpochRow
+	err := row.Scan(&e.VaultID, &e.KeyEpoch, &e.MemberSeq, &e.MemberHeadHash, &e.CompletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
 func (r *VaultsRepository) GetMembershipHead(ctx context.Context, vaultID []byte) (*VaultMembershipHead, error) {
 	row := r.db.QueryRowContext(ctx, `
 		SELECT vault_id, member_seq, member_head_hash
@@ -83,23 +184,42 @@ func (r *VaultsRepository) GetMembershipHead(ctx context.Context, vaultID []byte
 	return &h, nil
 }
 
-func (r *VaultsRepository) UpsertMembershipHead(ctx context.Context, vaultID []byte, memberSeq uint64, memberHeadHash []byte) error {
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO vault_membership_heads (vault_id, member_seq, member_head_hash)
-		VALUES (?, ?, ?)
-		ON CONFLICT(vault_id) DO UPDATE SET member_seq = excluded.member_seq, member_head_hash = excluded.member_head_hash
-	`, vaultID, memberSeq, memberHeadHash)
-	return err
+// AdvanceMembershipHead moves the membership head from (prevSeq, prevHash) to
+// (memberSeq, memberHeadHash). It reports false without writing if the stored
+// head is no longer the expected one; prevSeq == 0 means genesis.
+func (r *VaultsRepository) AdvanceMembershipHead(ctx context.Context, vaultID []byte, prevSeq uint64, prevHash []byte, memberSeq uint64, memberHeadHash []byte) (bool, error) {
+	var result sql.Result
+	var err error
+	if prevSeq == 0 {
+		result, err = r.db.ExecContext(ctx, `
+			INSERT INTO vault_membership_heads (vault_id, member_seq, member_head_hash)
+			VALUES (?, ?, ?)
+			ON CONFLICT(vault_id) DO NOTHING
+		`, vaultID, memberSeq, memberHeadHash)
+	} else {
+		result, err = r.db.ExecContext(ctx, `
+			UPDATE vault_membership_heads SET member_seq = ?, member_head_hash = ?
+			WHERE vault_id = ? AND member_seq = ? AND member_head_hash = ?
+		`, memberSeq, memberHeadHash, vaultID, prevSeq, prevHash)
+	}
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
 }
 
 func (r *VaultsRepository) GetMember(ctx context.Context, vaultID []byte, deviceID string) (*VaultMemberRow, error) {
 	row := r.db.QueryRowContext(ctx, `
-		SELECT vault_id, device_id, device_pubkey_sign, device_pubkey_box, subject_bundle_sig, is_member, key_epoch
+		SELECT vault_id, device_id, device_pubkey_sign, device_pubkey_box, subject_bundle_sig, is_member, key_epoch, frozen, role
 		FROM vault_members WHERE vault_id = ? AND device_id = ?
 	`, vaultID, deviceID)
 
 	var m VaultMemberRow
-	err := row.Scan(&m.VaultID, &m.DeviceID, &m.DevicePubkeySign, &m.DevicePubkeyBox, &m.SubjectBundleSig, &m.IsMember, &m.KeyEpoch)
+	err := row.Scan(&m.VaultID, &m.DeviceID, &m.DevicePubkeySign, &m.DevicePubkeyBox, &m.SubjectBundleSig, &m.IsMember, &m.KeyEpoch, &m.Frozen, &m.Role)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -111,35 +231,67 @@ func (r *VaultsRepository) GetMember(ctx context.Context, vaultID []byte, device
 
 func (r *VaultsRepository) UpsertMember(ctx context.Context, m *VaultMemberRow) error {
 	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO vault_members (vault_id, device_id, device_pubkey_sign, device_pubkey_box, subject_bundle_sig, is_member, key_epoch)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO vault_members (vault_id, device_id, device_pubkey_sign, device_pubkey_box, subject_bundle_sig, is_member, key_epoch, frozen, role)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(vault_id, device_id) DO UPDATE SET 
 			device_pubkey_sign = excluded.device_pubkey_sign,
 			device_pubkey_box = excluded.device_pubkey_box,
 			subject_bundle_sig = excluded.subject_bundle_sig,
 			is_member = excluded.is_member,
-			key_epoch = excluded.key_epoch
-	`, m.VaultID, m.DeviceID, m.DevicePubkeySign, m.DevicePubkeyBox, m.SubjectBundleSig, m.IsMember, m.KeyEpoch)
+			key_epoch = excluded.key_epoch,
+			frozen = excluded.frozen,
+			role = excluded.role
+	`, m.VaultID, m.DeviceID, m.DevicePubkeySign, m.DevicePubkeyBox, m.SubjectBundleSig, m.IsMember, m.KeyEpoch, m.Frozen, m.Role)
 	return err
 }
 
 func (r *VaultsRepository) SetMemberRemoved(ctx context.Context, vaultID []byte, deviceID string) error {
 	_, err := r.db.ExecContext(ctx, `
-		UPDATE vault_members SET is_member = 0 WHERE vault_id = ? AND device_id = ?
+		UPDATE vault_members SET is_member = 0, frozen = 0 WHERE vault_id = ? AND device_id = ?
+	`, vaultID, deviceID)
+	return err
+}
+
+// SetMemberFrozen stops the device from writing to the vault, apart from
+// leaving it, until it is removed. Removal clears the flag, so a device that
+// is later added again starts unfrozen.
+func (r *VaultsRepository) SetMemberFrozen(ctx context.Context, vaultID []byte, deviceID string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE vault_members SET frozen = 1 WHERE vault_id = ? AND device_id = ? AND is_member = 1
 	`, vaultID, deviceID)
 	return err
 }
 
+func (r *VaultsRepository) SetMemberRole(ctx context.Context, vaultID []byte, deviceID, role string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE vault_members SET role = ? WHERE vault_id = ? AND device_id = ?
+	`, role, vaultID, deviceID)
+	return err
+}
+
+// UpdateMemberKeyEpoch raises the member's key epoch to keyEpoch; it never
+// lowers it.
 func (r *VaultsRepository) UpdateMemberKeyEpoch(ctx context.Context, vaultID []byte, deviceID string, keyEpoch uint64) error {
 	_, err := r.db.ExecContext(ctx, `
-		UPDATE vault_members SET key_epoch = ? WHERE vault_id = ? AND device_id = ?
+		UPDATE vault_members SET key_epoch = MAX(key_epoch, ?) WHERE vault_id = ? AND device_id = ?
 	`, keyEpoch, vaultID, deviceID)
 	return err
 }
 
+// CountAdmins returns how many current members are the owner or an admin and
+// are not frozen, i.e. can co-sign a membership change.
+func (r *VaultsRepository) CountAdmins(ctx context.Context, vaultID []byte) (uint64, error) {
+	var count uint64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM vault_members
+		WHERE vault_id = ? AND is_member = 1 AND frozen = 0 AND role IN ('owner', 'admin')
+	`, vaultID).Scan(&count)
+	return count, err
+}
+
 func (r *VaultsRepository) ListMembers(ctx context.Context, vaultID []byte) ([]*VaultMemberRow, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT vault_id, device_id, device_pubkey_sign, device_pubkey_box, subject_bundle_sig, is_member, key_epoch
+		SELECT vault_id, device_id, device_pubkey_sign, device_pubkey_box, subject_bundle_sig, is_member, key_epoch, frozen, role
 		FROM vault_members WHERE vault_id = ? AND is_member = 1
 	`, vaultID)
 	if err != nil {
@@ -150,7 +302,7 @@ func (r *VaultsRepository) ListMembers(ctx context.Context, vaultID []byte) ([]*
 	var members []*VaultMemberRow
 	for rows.Next() {
 		var m VaultMemberRow
-		if err := rows.Scan(&m.VaultID, &m.DeviceID, &m.DevicePubkeySign, &m.DevicePubkeyBox, &m.SubjectBundleSig, &m.IsMember, &m.KeyEpoch); err != nil {
+		if err := rows.Scan(&m.VaultID, &m.DeviceID, &m.DevicePubkeySign, &m.DevicePubkeyBox, &m.SubjectBundleSig, &m.IsMember, &m.KeyEpoch, &m.Frozen, &m.Role); err != nil {
 			return nil, err
 		}
 		members = append(members, &m)
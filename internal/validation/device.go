@@ -2,6 +2,7 @@ package validation
 
 import (
 	"context"
+	"database/sql"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/cbe"
@@ -18,6 +19,12 @@ func NewDeviceValidator(devices *storage.DevicesRepository) *DeviceValidator {
 	return &DeviceValidator{devices: devices}
 }
 
+func (v *DeviceValidator) WithTx(tx *sql.Tx) *DeviceValidator {
+	return &DeviceValidator{
+		devices: v.devices.WithTx(tx),
+	}
+}
+
 func (v *DeviceValidator) ValidateBundle(ctx context.Context, bundle *models.DeviceBundle) *apierror.APIError {
 	if err := bundle.DeviceID.Validate(); err != nil {
 		return apierror.InvalidDeviceID()
@@ -3,6 +3,7 @@ package validation
 import (
 	"bytes"
 	"context"
+	"database/sql"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/cbe"
@@ -12,17 +13,31 @@ import (
 )
 
 type EventsValidator struct {
-	vaults *storage.VaultsRepository
-	events *storage.EventsRepository
+	vaults     *storage.VaultsRepository
+	events     *storage.EventsRepository
+	keyUpdates *storage.KeyUpdatesRepository
 }
 
-func NewEventsValidator(vaults *storage.VaultsRepository, events *storage.EventsRepository) *EventsValidator {
+func NewEventsValidator(vaults *storage.VaultsRepository, events *storage.EventsRepository, keyUpdates *storage.KeyUpdatesRepository) *EventsValidator {
 	return &EventsValidator{
-		vaults: vaults,
-		events: events,
+		vaults:     vaults,
+		events:     events,
+		keyUpdates: keyUpdates,
 	}
 }
 
+func (v *EventsValidator) WithTx(tx *sql.Tx) *EventsValidator {
+	return &EventsValidator{
+		vaults:     v.vaults.WithTx(tx),
+		events:     v.events.WithTx(tx),
+		keyUpdates: v.keyUpdates.WithTx(tx),
+	}
+}
+
+// ValidateEvent checks an event against the device's chain and returns the row
+// to append. An exact resubmission of an already stored event (same counter,
+// event_id and signature) is not an error: the stored row is returned with
+// its Seq set, and callers must report it rather than append it again.
 func (v *EventsValidator) ValidateEvent(ctx context.Context, event *models.Event) (*storage.EventRow, *apierror.APIError) {
 	if event.MsgType != "event" {
 		return nil, apierror.BadRequest("invalid_msg_type", "expected 'event'")
@@ -54,6 +69,9 @@ func (v *EventsValidator) ValidateEvent(ctx context.Context, event *models.Event
 	if vault == nil {
 		return nil, apierror.NotFound("vault")
 	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
 
 	member, err := v.vaults.GetMember(ctx, vaultID, string(event.DeviceID))
 	if err != nil {
@@ -69,19 +87,45 @@ func (v *EventsValidator) ValidateEvent(ctx context.Context, event *models.Event
 		return nil, apierror.InternalError()
 	}
 
+	if head != nil && counter >= 1 && counter <= head.LastCounter {
+		return v.checkResubmission(ctx, event, member)
+	}
+
+	if member.Frozen {
+		return nil, apierror.DeviceFrozen()
+	}
+	if member.Role == models.RoleReader {
+		return nil, apierror.ReadOnlyMember()
+	}
+
+	keyEpoch := uint64(event.KeyEpoch)
+	if keyEpoch < vault.KeyEpoch || keyEpoch > member.KeyEpoch {
+		return nil, apierror.StaleKeyEpoch().WithDetails(models.KeyEpochRange{
+			VaultKeyEpoch:  models.Uint64String(vault.KeyEpoch),
+			MemberKeyEpoch: models.Uint64String(member.KeyEpoch),
+		})
+	}
+	blocked, err := rotationBlocks(ctx, v.keyUpdates, vault, keyEpoch)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if blocked {
+		return nil, apierror.RotationPending()
+	}
+
 	if head == nil {
 		if counter != 1 {
-			return nil, apierror.EventChainBroken()
+			return nil, EventChainBrokenAt(string(event.DeviceID), head)
 		}
 		if !bytes.Equal(event.PrevHash, models.Zero32) {
-			return nil, apierror.EventChainBroken()
+			return nil, EventChainBrokenAt(string(event.DeviceID), head)
 		}
 	} else {
 		if counter != head.LastCounter+1 {
-			return nil, apierror.EventChainBroken()
+			return nil, EventChainBrokenAt(string(event.DeviceID), head)
 		}
 		if !bytes.Equal(event.PrevHash, head.LastHash) {
-			return nil, apierror.EventChainBroken()
+			return nil, EventChainBrokenAt(string(event.DeviceID), head)
 		}
 	}
 
@@ -93,6 +137,61 @@ func (v *EventsValidator) ValidateEvent(ctx context.Context, event *models.Event
 		return nil, apierror.Conflict("event_id already exists")
 	}
 
+	signBytes, apiErr := verifyEventSignature(event, member)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	eventHash := crypto.SHA256Hash(signBytes)
+
+	return &storage.EventRow{
+		EventID:    event.EventID.Bytes(),
+		EventHash:  eventHash,
+		VaultID:    vaultID,
+		DeviceID:   string(event.DeviceID),
+		Counter:    counter,
+		Lamport:    uint64(event.Lamport),
+		KeyEpoch:   keyEpoch,
+		PrevHash:   event.PrevHash,
+		Nonce:      event.Nonce,
+		Ciphertext: event.Ciphertext,
+		Signature:  event.Signature,
+		CreatedAt:  event.CreatedAt,
+	}, nil
+}
+
+// checkResubmission handles an event at a counter the device chain has
+// already passed: an identical retry resolves to the stored row, while a
+// different validly signed event at the same counter is equivocation.
+func (v *EventsValidator) checkResubmission(ctx context.Context, event *models.Event, member *storage.VaultMemberRow) (*storage.EventRow, *apierror.APIError) {
+	stored, err := v.events.GetByCounter(ctx, event.VaultID.Bytes(), string(event.DeviceID), uint64(event.Counter))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if stored == nil {
+		return nil, apierror.InternalError()
+	}
+
+	if bytes.Equal(stored.EventID, event.EventID.Bytes()) && bytes.Equal(stored.Signature, event.Signature) {
+		return stored, nil
+	}
+
+	if _, apiErr := verifyEventSignature(event, member); apiErr != nil {
+		return nil, apiErr
+	}
+
+	return nil, apierror.Equivocation().WithDetails(models.EventRef{
+		EventID:   models.UUID(stored.EventID),
+		DeviceID:  models.DeviceID(stored.DeviceID),
+		Counter:   models.Uint64String(stored.Counter),
+		EventHash: stored.EventHash,
+		Seq:       models.Uint64String(stored.Seq),
+	})
+}
+
+// verifyEventSignature checks the event against the member's signing key and
+// returns the signed bytes.
+func verifyEventSignature(event *models.Event, member *storage.VaultMemberRow) ([]byte, *apierror.APIError) {
 	deviceIDBytes, err := crypto.DeviceIDToBytes(string(event.DeviceID))
 	if err != nil {
 		return nil, apierror.InvalidDeviceID()
@@ -100,9 +199,9 @@ func (v *EventsValidator) ValidateEvent(ctx context.Context, event *models.Event
 
 	signBytes, err := cbe.SignBytesEvent(
 		event.EventID.Bytes(),
-		vaultID,
+		event.VaultID.Bytes(),
 		deviceIDBytes,
-		counter,
+		uint64(event.Counter),
 		uint64(event.Lamport),
 		uint64(event.KeyEpoch),
 		event.PrevHash,
@@ -116,21 +215,37 @@ func (v *EventsValidator) ValidateEvent(ctx context.Context, event *models.Event
 	if err := crypto.VerifySignature(member.DevicePubkeySign, signBytes, event.Signature); err != nil {
 		return nil, apierror.InvalidSignature()
 	}
+	return signBytes, nil
+}
 
-	eventHash := crypto.SHA256Hash(signBytes)
+// rotationBlocks reports whether writing under keyEpoch is blocked because
+// a removed member may still hold that key: while a rotation is pending,
+// that is the current epoch and every epoch distributed before the removal.
+func rotationBlocks(ctx context.Context, keyUpdates *storage.KeyUpdatesRepository, vault *storage.VaultRow, keyEpoch uint64) (bool, error) {
+	if vault.RotationPendingSeq == 0 {
+		return false, nil
+	}
+	if keyEpoch <= vault.KeyEpoch {
+		return true, nil
+	}
+	used, err := keyUpdates.LatestEpochBefore(ctx, vault.VaultID, vault.RotationPendingSeq)
+	if err != nil {
+		return false, err
+	}
+	return keyEpoch <= used, nil
+}
 
-	return &storage.EventRow{
-		EventID:    event.EventID.Bytes(),
-		EventHash:  eventHash,
-		VaultID:    vaultID,
-		DeviceID:   string(event.DeviceID),
-		Counter:    counter,
-		Lamport:    uint64(event.Lamport),
-		KeyEpoch:   uint64(event.KeyEpoch),
-		PrevHash:   event.PrevHash,
-		Nonce:      event.Nonce,
-		Ciphertext: event.Ciphertext,
-		Signature:  event.Signature,
-		CreatedAt:  event.CreatedAt,
-	}, nil
+// EventChainBrokenAt reports a chain mismatch together with the device's
+// current head (counter 0 and a zero hash if it has no events yet), so the
+// client can rebase its pending events.
+func EventChainBrokenAt(deviceID string, head *storage.EventHead) *apierror.APIError {
+	current := models.EventChainHead{
+		DeviceID: models.DeviceID(deviceID),
+		LastHash: models.Zero32,
+	}
+	if head != nil {
+		current.LastCounter = models.Uint64String(head.LastCounter)
+		current.LastHash = head.LastHash
+	}
+	return apierror.EventChainBroken().WithDetails(current)
 }
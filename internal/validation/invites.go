@@ -3,6 +3,9 @@ package validation
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"slices"
+	"time"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/cbe"
@@ -15,23 +18,40 @@ type InvitesValidator struct {
 	vaults  *storage.VaultsRepository
 	invites *storage.InvitesRepository
 	devices *storage.DevicesRepository
+	// maxLifetime bounds a signed expires_at and is how long an invite
+	// without one lasts.
+	maxLifetime time.Duration
 }
 
 func NewInvitesValidator(
 	vaults *storage.VaultsRepository,
 	invites *storage.InvitesRepository,
 	devices *storage.DevicesRepository,
+	maxLifetime time.Duration,
 ) *InvitesValidator {
 	return &InvitesValidator{
-		vaults:  vaults,
-		invites: invites,
-		devices: devices,
+		vaults:      vaults,
+		invites:     invites,
+		devices:     devices,
+		maxLifetime: maxLifetime,
+	}
+}
+
+func (v *InvitesValidator) WithTx(tx *sql.Tx) *InvitesValidator {
+	return &InvitesValidator{
+		vaults:      v.vaults.WithTx(tx),
+		invites:     v.invites.WithTx(tx),
+		devices:     v.devices.WithTx(tx),
+		maxLifetime: v.maxLifetime,
 	}
 }
 
 func (v *InvitesValidator) ValidateInvite(ctx context.Context, invite *models.Invite) (*storage.InviteRow, *apierror.APIError) {
+	if invite.MsgType == "invite_multi" {
+		return v.validateInviteMulti(ctx, invite)
+	}
 	if invite.MsgType != "invite" {
-		return nil, apierror.BadRequest("invalid_msg_type", "expected 'invite'")
+		return nil, apierror.BadRequest("invalid_msg_type", "expected 'invite' or 'invite_multi'")
 	}
 
 	if len(invite.Nonce) != models.NonceLength {
@@ -69,13 +89,13 @@ func (v *InvitesValidator) ValidateInvite(ctx context.Context, invite *models.In
 	if vault == nil {
 		return nil, apierror.NotFound("vault")
 	}
-
-	creator, err := v.vaults.GetMember(ctx, vaultID, string(invite.CreatedByDeviceID))
-	if err != nil {
-		return nil, apierror.InternalError()
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
 	}
-	if creator == nil || !creator.IsMember {
-		return nil, apierror.MembershipRequired()
+
+	creator, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(invite.CreatedByDeviceID))
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
 	if err := crypto.VerifyDeviceID(string(invite.TargetDeviceID), invite.TargetDevicePubkeySign); err != nil {
@@ -109,18 +129,42 @@ func (v *InvitesValidator) ValidateInvite(ctx context.Context, invite *models.In
 		return nil, apierror.InvalidDeviceID()
 	}
 
-	signBytes, err := cbe.SignBytesInvite(
-		invite.InviteID.Bytes(),
-		vaultID,
-		targetDeviceIDBytes,
-		invite.TargetDevicePubkeySign,
-		invite.TargetDevicePubkeyBox,
-		invite.TargetDeviceBundleSig,
-		invite.Nonce,
-		invite.WrappedPayload,
-		creatorDeviceIDBytes,
-		invite.SingleUse,
-	)
+	// An invite with expires_at is signed with the v2 layout, which covers it.
+	var signBytes []byte
+	var expiresAt string
+	if invite.ExpiresAt == "" {
+		signBytes, err = cbe.SignBytesInvite(
+			invite.InviteID.Bytes(),
+			vaultID,
+			targetDeviceIDBytes,
+			invite.TargetDevicePubkeySign,
+			invite.TargetDevicePubkeyBox,
+			invite.TargetDeviceBundleSig,
+			invite.Nonce,
+			invite.WrappedPayload,
+			creatorDeviceIDBytes,
+			invite.SingleUse,
+		)
+	} else {
+		t, apiErr := parseInviteExpiry(invite.ExpiresAt, v.maxLifetime)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		expiresAt = t.UTC().Format(time.RFC3339)
+		signBytes, err = cbe.SignBytesInviteV2(
+			invite.InviteID.Bytes(),
+			vaultID,
+			targetDeviceIDBytes,
+			invite.TargetDevicePubkeySign,
+			invite.TargetDevicePubkeyBox,
+			invite.TargetDeviceBundleSig,
+			invite.Nonce,
+			invite.WrappedPayload,
+			creatorDeviceIDBytes,
+			invite.SingleUse,
+			uint64(t.Unix()),
+		)
+	}
 	if err != nil {
 		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
 	}
@@ -129,6 +173,11 @@ func (v *InvitesValidator) ValidateInvite(ctx context.Context, invite *models.In
 		return nil, apierror.InvalidSignature()
 	}
 
+	var maxUses uint64
+	if invite.SingleUse {
+		maxUses = 1
+	}
+
 	return &storage.InviteRow{
 		InviteID:              invite.InviteID.Bytes(),
 		VaultID:               vaultID,
@@ -140,9 +189,134 @@ func (v *InvitesValidator) ValidateInvite(ctx context.Context, invite *models.In
 		WrappedPayload:        invite.WrappedPayload,
 		CreatedByDeviceID:     string(invite.CreatedByDeviceID),
 		SingleUse:             invite.SingleUse,
-		Used:                  false,
+		ExpiresAt:             expiresAt,
 		Signature:             invite.Signature,
 		CreatedAt:             invite.CreatedAt,
+		MaxUses:               maxUses,
+	}, nil
+}
+
+// validateInviteMulti checks an invite_multi, which can be used max_uses
+// times by the devices in target_device_ids, or by any registered device
+// when that is empty.
+func (v *InvitesValidator) validateInviteMulti(ctx context.Context, invite *models.Invite) (*storage.InviteRow, *apierror.APIError) {
+	if len(invite.Nonce) != models.NonceLength {
+		return nil, apierror.InvalidNonce()
+	}
+	if len(invite.Signature) != models.SignatureLength {
+		return nil, apierror.InvalidSignature()
+	}
+	if len(invite.WrappedPayload) > models.MaxWrappedPayload {
+		return nil, apierror.PayloadTooLarge("wrapped_payload exceeds maximum size")
+	}
+	if invite.TargetDeviceID != "" || len(invite.TargetDevicePubkeySign) != 0 || len(invite.TargetDevicePubkeyBox) != 0 || len(invite.TargetDeviceBundleSig) != 0 {
+		return nil, apierror.BadRequest("invalid_invite_multi", "invite_multi is addressed with target_device_ids, not target_device_id")
+	}
+	if len(invite.TargetDeviceIDs) > models.MaxInviteTargets {
+		return nil, apierror.BadRequest("too_many_targets", "target_device_ids exceeds maximum length")
+	}
+	if invite.MaxUses == 0 {
+		return nil, apierror.BadRequest("invalid_max_uses", "max_uses must be at least 1")
+	}
+	if len(invite.TargetDeviceIDs) > 0 && uint64(invite.MaxUses) > uint64(len(invite.TargetDeviceIDs)) {
+		return nil, apierror.BadRequest("invalid_max_uses", "max_uses exceeds the number of target devices")
+	}
+
+	if err := invite.CreatedByDeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	targets := make([]string, 0, len(invite.TargetDeviceIDs))
+	targetIDBytes := make([][]byte, 0, len(invite.TargetDeviceIDs))
+	for _, target := range invite.TargetDeviceIDs {
+		if err := target.Validate(); err != nil {
+			return nil, apierror.InvalidDeviceID()
+		}
+		if slices.Contains(targets, string(target)) {
+			return nil, apierror.BadRequest("duplicate_target", "target_device_ids contains a device more than once")
+		}
+		idBytes, err := crypto.DeviceIDToBytes(string(target))
+		if err != nil {
+			return nil, apierror.InvalidDeviceID()
+		}
+		targets = append(targets, string(target))
+		targetIDBytes = append(targetIDBytes, idBytes)
+	}
+
+	vaultID := invite.VaultID.Bytes()
+
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault == nil {
+		return nil, apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
+	creator, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(invite.CreatedByDeviceID))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	used, err := v.invites.CheckNonceUsed(ctx, "invite", vaultID, string(invite.CreatedByDeviceID), invite.Nonce)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if used {
+		return nil, apierror.BadRequest("nonce_reused", "nonce has already been used")
+	}
+
+	var expiresAt string
+	var expiresAtUnix uint64
+	if invite.ExpiresAt != "" {
+		t, apiErr := parseInviteExpiry(invite.ExpiresAt, v.maxLifetime)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		expiresAt = t.UTC().Format(time.RFC3339)
+		expiresAtUnix = uint64(t.Unix())
+	}
+
+	creatorDeviceIDBytes, err := crypto.DeviceIDToBytes(string(invite.CreatedByDeviceID))
+	if err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	signBytes, err := cbe.SignBytesInviteMulti(
+		invite.InviteID.Bytes(),
+		vaultID,
+		targetIDBytes,
+		invite.Nonce,
+		invite.WrappedPayload,
+		creatorDeviceIDBytes,
+		uint64(invite.MaxUses),
+		expiresAtUnix,
+	)
+	if err != nil {
+		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+
+	if err := crypto.VerifySignature(creator.DevicePubkeySign, signBytes, invite.Signature); err != nil {
+		return nil, apierror.InvalidSignature()
+	}
+
+	return &storage.InviteRow{
+		InviteID:               invite.InviteID.Bytes(),
+		VaultID:                vaultID,
+		TargetDevicePubkeySign: []byte{},
+		TargetDevicePubkeyBox:  []byte{},
+		TargetDeviceBundleSig:  []byte{},
+		Nonce:                  invite.Nonce,
+		WrappedPayload:         invite.WrappedPayload,
+		CreatedByDeviceID:      string(invite.CreatedByDeviceID),
+		ExpiresAt:              expiresAt,
+		Signature:              invite.Signature,
+		CreatedAt:              invite.CreatedAt,
+		MaxUses:                uint64(invite.MaxUses),
+		Targets:                targets,
 	}, nil
 }
 
@@ -166,12 +340,23 @@ func (v *InvitesValidator) ValidateInviteClaim(ctx context.Context, claim *model
 	if invite == nil {
 		return nil, apierror.NotFound("invite")
 	}
+	if apiErr := checkInviteUsable(invite, v.maxLifetime); apiErr != nil {
+		return nil, apiErr
+	}
 
 	if !bytes.Equal(invite.VaultID, claim.VaultID.Bytes()) {
 		return nil, apierror.BadRequest("vault_mismatch", "vault_id does not match invite")
 	}
 
-	if invite.TargetDeviceID != string(claim.DeviceID) {
+	vault, err := v.vaults.Get(ctx, invite.VaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault != nil && vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
+	if !invite.Admits(string(claim.DeviceID)) {
 		return nil, apierror.BadRequest("device_mismatch", "device_id does not match invite target")
 	}
 
@@ -205,3 +390,112 @@ func (v *InvitesValidator) ValidateInviteClaim(ctx context.Context, claim *model
 		CreatedAt: claim.CreatedAt,
 	}, nil
 }
+
+// ValidateInviteRevoke checks the creator's signed request to revoke an
+// invite and returns the invite being revoked.
+func (v *InvitesValidator) ValidateInviteRevoke(ctx context.Context, revoke *models.InviteRevoke) (*storage.InviteRow, *apierror.APIError) {
+	if revoke.MsgType != "invite_revoke" {
+		return nil, apierror.BadRequest("invalid_msg_type", "expected 'invite_revoke'")
+	}
+
+	if len(revoke.Signature) != models.SignatureLength {
+		return nil, apierror.InvalidSignature()
+	}
+
+	if err := revoke.DeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	invite, err := v.invites.Get(ctx, revoke.InviteID.Bytes())
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if invite == nil {
+		return nil, apierror.NotFound("invite")
+	}
+
+	if !bytes.Equal(invite.VaultID, revoke.VaultID.Bytes()) {
+		return nil, apierror.BadRequest("vault_mismatch", "vault_id does not match invite")
+	}
+
+	vault, err := v.vaults.Get(ctx, invite.VaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault != nil && vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
+	if invite.CreatedByDeviceID != string(revoke.DeviceID) {
+		return nil, apierror.Forbidden("only the invite creator may revoke it")
+	}
+	if invite.RevokedAt != "" {
+		return nil, apierror.Conflict("invite has already been revoked")
+	}
+	if invite.Exhausted() {
+		return nil, apierror.InviteAlreadyUsed()
+	}
+
+	device, err := v.devices.Get(ctx, string(revoke.DeviceID))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if device == nil {
+		return nil, apierror.NotFound("device")
+	}
+
+	deviceIDBytes, err := crypto.DeviceIDToBytes(string(revoke.DeviceID))
+	if err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	signBytes, err := cbe.SignBytesInviteRevoke(revoke.InviteID.Bytes(), revoke.VaultID.Bytes(), deviceIDBytes)
+	if err != nil {
+		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+
+	if err := crypto.VerifySignature(device.DevicePubkeySign, signBytes, revoke.Signature); err != nil {
+		return nil, apierror.InvalidSignature()
+	}
+
+	return invite, nil
+}
+
+// ValidateVaultInvitesAccess checks that deviceID may see all of the vault's
+// invites, i.e. that it is the owner or an admin.
+func (v *InvitesValidator) ValidateVaultInvitesAccess(ctx context.Context, vaultID []byte, deviceID string) *apierror.APIError {
+	_, apiErr := requireAdmin(ctx, v.vaults, vaultID, deviceID)
+	return apiErr
+}
+
+// checkInviteUsable rejects invites that were revoked, have expired or have
+// no uses left.
+func checkInviteUsable(invite *storage.InviteRow, maxLifetime time.Duration) *apierror.APIError {
+	if invite.RevokedAt != "" {
+		return apierror.InviteRevoked()
+	}
+	if invite.Expired(time.Now(), maxLifetime) {
+		return apierror.InviteExpired()
+	}
+	if invite.Exhausted() {
+		return apierror.InviteAlreadyUsed()
+	}
+	return nil
+}
+
+// parseInviteExpiry parses an invite's expires_at, which must lie in the
+// future and no further than maxLifetime from now.
+func parseInviteExpiry(expiresAt string, maxLifetime time.Duration) (time.Time, *apierror.APIError) {
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return time.Time{}, apierror.BadRequest("invalid_expires_at", "expires_at must be an RFC3339 timestamp")
+	}
+	now := time.Now()
+	if !t.After(now) {
+		return time.Time{}, apierror.BadRequest("invalid_expires_at", "expires_at must be in the future")
+	}
+	if t.After(now.Add(maxLifetime)) {
+		return time.Time{}, apierror.BadRequest("invalid_expires_at", "expires_at is beyond the server's maximum invite lifetime")
+	}
+	return t, nil
+}
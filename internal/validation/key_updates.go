@@ -3,6 +3,7 @@ package validation
 import (
 	"bytes"
 	"context"
+	"database/sql"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/cbe"
@@ -29,6 +30,14 @@ func NewKeyUpdatesValidator(
 	}
 }
 
+func (v *KeyUpdatesValidator) WithTx(tx *sql.Tx) *KeyUpdatesValidator {
+	return &KeyUpdatesValidator{
+		vaults:     v.vaults.WithTx(tx),
+		keyUpdates: v.keyUpdates.WithTx(tx),
+		invites:    v.invites.WithTx(tx),
+	}
+}
+
 func (v *KeyUpdatesValidator) ValidateKeyUpdate(ctx context.Context, ku *models.KeyUpdate) (*storage.KeyUpdateRow, *apierror.APIError) {
 	if ku.MsgType != "key_update" {
 		return nil, apierror.BadRequest("invalid_msg_type", "expected 'key_update'")
@@ -63,17 +72,13 @@ func (v *KeyUpdatesValidator) ValidateKeyUpdate(ctx context.Context, ku *models.
 	if vault == nil {
 		return nil, apierror.NotFound("vault")
 	}
-
-	if string(ku.CreatedByDeviceID) != vault.OwnerDeviceID {
-		return nil, apierror.OwnerRequired()
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
 	}
 
-	creator, err := v.vaults.GetMember(ctx, vaultID, string(ku.CreatedByDeviceID))
-	if err != nil {
-		return nil, apierror.InternalError()
-	}
-	if creator == nil || !creator.IsMember {
-		return nil, apierror.MembershipRequired()
+	creator, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(ku.CreatedByDeviceID))
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
 	isMember, err := v.vaults.IsMember(ctx, vaultID, string(ku.TargetDeviceID))
@@ -99,6 +104,18 @@ func (v *KeyUpdatesValidator) ValidateKeyUpdate(ctx context.Context, ku *models.
 		return nil, apierror.BadRequest("member_head_hash_mismatch", "member_head_hash does not match current membership head")
 	}
 
+	// The removed device may hold keys for any epoch distributed before its
+	// removal, so a rotation has to move past all of them.
+	if vault.RotationPendingSeq != 0 {
+		used, err := v.keyUpdates.LatestEpochBefore(ctx, vaultID, vault.RotationPendingSeq)
+		if err != nil {
+			return nil, apierror.InternalError()
+		}
+		if uint64(ku.KeyEpoch) <= used {
+			return nil, apierror.KeyEpochUsed()
+		}
+	}
+
 	exists, err := v.keyUpdates.CheckExists(ctx, vaultID, uint64(ku.KeyEpoch), string(ku.TargetDeviceID))
 	if err != nil {
 		return nil, apierror.InternalError()
@@ -158,6 +175,28 @@ func (v *KeyUpdatesValidator) ValidateKeyUpdate(ctx context.Context, ku *models.
 	}, nil
 }
 
+// ValidateCoverage checks that targets, the devices of a batch of key updates
+// already validated one by one, are exactly the vault's current members.
+func (v *KeyUpdatesValidator) ValidateCoverage(ctx context.Context, vaultID []byte, targets map[string]bool) *apierror.APIError {
+	members, err := v.vaults.ListMembers(ctx, vaultID)
+	if err != nil {
+		return apierror.InternalError()
+	}
+
+	missing := []models.DeviceID{}
+	for _, m := range members {
+		if !targets[m.DeviceID] {
+			missing = append(missing, models.DeviceID(m.DeviceID))
+		}
+	}
+	if len(missing) > 0 || len(targets) != len(members) {
+		return apierror.BadRequest("incomplete_key_update_batch", "batch must contain one key update for every current member").WithDetails(struct {
+			Missing []models.DeviceID `json:"missing"`
+		}{Missing: missing})
+	}
+	return nil
+}
+
 func (v *KeyUpdatesValidator) ValidateKeyUpdateAck(ctx context.Context, ack *models.KeyUpdateAck) (*storage.KeyUpdateAckRow, *apierror.APIError) {
 	if ack.MsgType != "key_update_ack" {
 		return nil, apierror.BadRequest("invalid_msg_type", "expected 'key_update_ack'")
@@ -176,6 +215,17 @@ func (v *KeyUpdatesValidator) ValidateKeyUpdateAck(ctx context.Context, ack *mod
 
 	vaultID := ack.VaultID.Bytes()
 
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault == nil {
+		return nil, apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
 	member, err := v.vaults.GetMember(ctx, vaultID, string(ack.DeviceID))
 	if err != nil {
 		return nil, apierror.InternalError()
@@ -183,6 +233,37 @@ func (v *KeyUpdatesValidator) ValidateKeyUpdateAck(ctx context.Context, ack *mod
 	if member == nil || !member.IsMember {
 		return nil, apierror.MembershipRequired()
 	}
+	if member.Frozen {
+		return nil, apierror.DeviceFrozen()
+	}
+
+	// An ack confirms a key the device was actually sent, and never moves
+	// it back to an epoch it has already left. While a rotation is pending
+	// only keys sent after the removal count, as the removed device may
+	// hold any earlier one.
+	keyEpoch := uint64(ack.KeyEpoch)
+	if keyEpoch < member.KeyEpoch {
+		return nil, apierror.StaleKeyEpoch().WithDetails(models.KeyEpochRange{
+			VaultKeyEpoch:  models.Uint64String(vault.KeyEpoch),
+			MemberKeyEpoch: models.Uint64String(member.KeyEpoch),
+		})
+	}
+	exists, err := v.keyUpdates.CheckExists(ctx, vaultID, keyEpoch, string(ack.DeviceID))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if !exists {
+		return nil, apierror.BadRequest("key_update_not_found", "no key update for device_id at key_epoch")
+	}
+	if vault.RotationPendingSeq != 0 {
+		current, err := v.keyUpdates.CheckExistsSince(ctx, vaultID, keyEpoch, string(ack.DeviceID), vault.RotationPendingSeq)
+		if err != nil {
+			return nil, apierror.InternalError()
+		}
+		if !current {
+			return nil, apierror.RotationPending()
+		}
+	}
 
 	head, err := v.vaults.GetMembershipHead(ctx, vaultID)
 	if err != nil {
@@ -3,6 +3,9 @@ package validation
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"fmt"
+	"time"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/cbe"
@@ -16,6 +19,8 @@ type MembershipValidator struct {
 	memberEvents *storage.MemberEventsRepository
 	invites      *storage.InvitesRepository
 	devices      *storage.DevicesRepository
+	// inviteMaxLifetime is how long an invite without a signed expiry lasts.
+	inviteMaxLifetime time.Duration
 }
 
 func NewMembershipValidator(
@@ -23,12 +28,24 @@ func NewMembershipValidator(
 	memberEvents *storage.MemberEventsRepository,
 	invites *storage.InvitesRepository,
 	devices *storage.DevicesRepository,
+	inviteMaxLifetime time.Duration,
 ) *MembershipValidator {
 	return &MembershipValidator{
-		vaults:       vaults,
-		memberEvents: memberEvents,
-		invites:      invites,
-		devices:      devices,
+		vaults:            vaults,
+		memberEvents:      memberEvents,
+		invites:           invites,
+		devices:           devices,
+		inviteMaxLifetime: inviteMaxLifetime,
+	}
+}
+
+func (v *MembershipValidator) WithTx(tx *sql.Tx) *MembershipValidator {
+	return &MembershipValidator{
+		vaults:            v.vaults.WithTx(tx),
+		memberEvents:      v.memberEvents.WithTx(tx),
+		invites:           v.invites.WithTx(tx),
+		devices:           v.devices.WithTx(tx),
+		inviteMaxLifetime: v.inviteMaxLifetime,
 	}
 }
 
@@ -106,9 +123,12 @@ func (v *MembershipValidator) ValidateMemberAdd(ctx context.Context, event *mode
 		if vault == nil {
 			return nil, apierror.NotFound("vault")
 		}
+		if vault.DeletedAt != "" {
+			return nil, apierror.VaultDeleted()
+		}
 
-		if string(event.ActorDeviceID) != vault.OwnerDeviceID {
-			return nil, apierror.OwnerRequired()
+		if _, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(event.ActorDeviceID)); apiErr != nil {
+			return nil, apiErr
 		}
 
 		head, err := v.vaults.GetMembershipHead(ctx, vaultID)
@@ -119,11 +139,14 @@ func (v *MembershipValidator) ValidateMemberAdd(ctx context.Context, event *mode
 			return nil, apierror.BadRequest("missing_membership_head", "vault exists but membership head is missing")
 		}
 
+		if memberSeq <= head.MemberSeq {
+			return nil, v.checkEquivocation(ctx, event, head)
+		}
 		if memberSeq != head.MemberSeq+1 {
-			return nil, apierror.MembershipChainBroken()
+			return nil, MembershipChainBrokenAt(head)
 		}
 		if !bytes.Equal(event.PrevHash, head.MemberHeadHash) {
-			return nil, apierror.MembershipChainBroken()
+			return nil, MembershipChainBrokenAt(head)
 		}
 
 		invite, err := v.invites.Get(ctx, event.InviteID.Bytes())
@@ -133,16 +156,16 @@ func (v *MembershipValidator) ValidateMemberAdd(ctx context.Context, event *mode
 		if invite == nil {
 			return nil, apierror.NotFound("invite")
 		}
+		if apiErr := checkInviteUsable(invite, v.inviteMaxLifetime); apiErr != nil {
+			return nil, apiErr
+		}
 
 		if !bytes.Equal(invite.VaultID, vaultID) {
 			return nil, apierror.BadRequest("invite_vault_mismatch", "invite is for a different vault")
 		}
-		if invite.TargetDeviceID != string(event.SubjectDeviceID) {
+		if !invite.Admits(string(event.SubjectDeviceID)) {
 			return nil, apierror.BadRequest("invite_target_mismatch", "invite is for a different device")
 		}
-		if invite.SingleUse && invite.Used {
-			return nil, apierror.InviteAlreadyUsed()
-		}
 
 		claim, err := v.invites.GetClaim(ctx, invite.InviteID, string(event.SubjectDeviceID))
 		if err != nil {
@@ -262,9 +285,13 @@ func (v *MembershipValidator) ValidateMemberRemove(ctx context.Context, event *m
 	if vault == nil {
 		return nil, apierror.NotFound("vault")
 	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
 
-	if string(event.ActorDeviceID) != vault.OwnerDeviceID {
-		return nil, apierror.OwnerRequired()
+	actor, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(event.ActorDeviceID))
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
 	head, err := v.vaults.GetMembershipHead(ctx, vaultID)
@@ -276,20 +303,30 @@ func (v *MembershipValidator) ValidateMemberRemove(ctx context.Context, event *m
 	}
 
 	memberSeq := uint64(event.MemberSeq)
+	if memberSeq <= head.MemberSeq {
+		return nil, v.checkEquivocation(ctx, event, head)
+	}
 	if memberSeq != head.MemberSeq+1 {
-		return nil, apierror.MembershipChainBroken()
+		return nil, MembershipChainBrokenAt(head)
 	}
 	if !bytes.Equal(event.PrevHash, head.MemberHeadHash) {
-		return nil, apierror.MembershipChainBroken()
+		return nil, MembershipChainBrokenAt(head)
 	}
 
-	isMember, err := v.vaults.IsMember(ctx, vaultID, string(event.SubjectDeviceID))
+	subject, err := v.vaults.GetMember(ctx, vaultID, string(event.SubjectDeviceID))
 	if err != nil {
 		return nil, apierror.InternalError()
 	}
-	if !isMember {
+	if subject == nil || !subject.IsMember {
 		return nil, apierror.BadRequest("subject_not_member", "subject_device_id is not a current member")
 	}
+	if subject.Role == models.RoleOwner {
+		return nil, apierror.CannotRemoveOwner()
+	}
+	// Admins manage ordinary members; only the owner may remove an admin.
+	if subject.DeviceID != actor.DeviceID && roleRank(subject.Role) >= roleRank(models.RoleAdmin) && actor.Role != models.RoleOwner {
+		return nil, apierror.OwnerRequired()
+	}
 
 	actorDeviceIDBytes, err := crypto.DeviceIDToBytes(string(event.ActorDeviceID))
 	if err != nil {
@@ -312,6 +349,60 @@ func (v *MembershipValidator) ValidateMemberRemove(ctx context.Context, event *m
 		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
 	}
 
+	if err := crypto.VerifySignature(actor.DevicePubkeySign, signBytes, event.Signature); err != nil {
+		return nil, apierror.InvalidSignature()
+	}
+
+	memberHash := crypto.SHA256Hash(signBytes)
+
+	return &storage.MemberEventRow{
+		MemberEventID:   event.MemberEventID.Bytes(),
+		VaultID:         vaultID,
+		MemberSeq:       memberSeq,
+		PrevHash:        event.PrevHash,
+		ActorDeviceID:   string(event.ActorDeviceID),
+		SubjectDeviceID: string(event.SubjectDeviceID),
+		MsgType:         "member_remove",
+		Signature:       event.Signature,
+		MemberHash:      memberHash,
+		CreatedAt:       event.CreatedAt,
+	}, nil
+}
+
+// ValidateOwnerTransfer checks an owner_transfer handing the vault to another
+// current member, signed by the current owner or, while the owner is frozen,
+// by an admin.
+func (v *MembershipValidator) ValidateOwnerTransfer(ctx context.Context, event *models.MemberEvent) (*storage.MemberEventRow, *apierror.APIError) {
+	if event.MsgType != "owner_transfer" {
+		return nil, apierror.BadRequest("invalid_msg_type", "expected owner_transfer")
+	}
+
+	if len(event.PrevHash) != models.HashLength {
+		return nil, apierror.InvalidHash()
+	}
+	if len(event.Signature) != models.SignatureLength {
+		return nil, apierror.InvalidSignature()
+	}
+
+	if err := event.ActorDeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+	if err := event.SubjectDeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	vaultID := event.VaultID.Bytes()
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault == nil {
+		return nil, apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
 	actor, err := v.vaults.GetMember(ctx, vaultID, string(event.ActorDeviceID))
 	if err != nil {
 		return nil, apierror.InternalError()
@@ -319,12 +410,151 @@ func (v *MembershipValidator) ValidateMemberRemove(ctx context.Context, event *m
 	if actor == nil || !actor.IsMember {
 		return nil, apierror.MembershipRequired()
 	}
+	if actor.Frozen {
+		return nil, apierror.DeviceFrozen()
+	}
+	if string(event.ActorDeviceID) != vault.OwnerDeviceID {
+		// A frozen owner can neither act nor be removed, so an admin may
+		// take the vault over from it.
+		owner, err := v.vaults.GetMember(ctx, vaultID, vault.OwnerDeviceID)
+		if err != nil {
+			return nil, apierror.InternalError()
+		}
+		if actor.Role != models.RoleAdmin || owner == nil || !owner.Frozen {
+			return nil, apierror.OwnerRequired()
+		}
+	}
+	if string(event.SubjectDeviceID) == vault.OwnerDeviceID {
+		return nil, apierror.BadRequest("invalid_subject", "subject_device_id is already the owner")
+	}
+
+	head, err := v.vaults.GetMembershipHead(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if head == nil {
+		return nil, apierror.BadRequest("missing_membership_head", "vault membership head is missing")
+	}
+
+	memberSeq := uint64(event.MemberSeq)
+	if memberSeq <= head.MemberSeq {
+		return nil, v.checkEquivocation(ctx, event, head)
+	}
+	if memberSeq != head.MemberSeq+1 {
+		return nil, MembershipChainBrokenAt(head)
+	}
+	if !bytes.Equal(event.PrevHash, head.MemberHeadHash) {
+		return nil, MembershipChainBrokenAt(head)
+	}
+
+	subject, err := v.vaults.GetMember(ctx, vaultID, string(event.SubjectDeviceID))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if subject == nil || !subject.IsMember {
+		return nil, apierror.BadRequest("subject_not_member", "subject_device_id is not a current member")
+	}
+	if subject.Frozen {
+		return nil, apierror.BadRequest("subject_frozen", "subject_device_id is frozen")
+	}
+
+	signBytes, err := memberEventSignBytes(event)
+	if err != nil {
+		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
+	}
 
 	if err := crypto.VerifySignature(actor.DevicePubkeySign, signBytes, event.Signature); err != nil {
 		return nil, apierror.InvalidSignature()
 	}
 
-	memberHash := crypto.SHA256Hash(signBytes)
+	return &storage.MemberEventRow{
+		MemberEventID:   event.MemberEventID.Bytes(),
+		VaultID:         vaultID,
+		MemberSeq:       memberSeq,
+		PrevHash:        event.PrevHash,
+		ActorDeviceID:   string(event.ActorDeviceID),
+		SubjectDeviceID: string(event.SubjectDeviceID),
+		MsgType:         "owner_transfer",
+		Signature:       event.Signature,
+		MemberHash:      crypto.SHA256Hash(signBytes),
+		CreatedAt:       event.CreatedAt,
+	}, nil
+}
+
+// ValidateMemberLeave checks a member_leave, in which a current member removes
+// itself (actor and subject are the same device). The owner has to hand the
+// vault over first.
+func (v *MembershipValidator) ValidateMemberLeave(ctx context.Context, event *models.MemberEvent) (*storage.MemberEventRow, *apierror.APIError) {
+	if event.MsgType != "member_leave" {
+		return nil, apierror.BadRequest("invalid_msg_type", "expected member_leave")
+	}
+
+	if len(event.PrevHash) != models.HashLength {
+		return nil, apierror.InvalidHash()
+	}
+	if len(event.Signature) != models.SignatureLength {
+		return nil, apierror.InvalidSignature()
+	}
+
+	if err := event.ActorDeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+	if event.SubjectDeviceID != event.ActorDeviceID {
+		return nil, apierror.BadRequest("leave_actor_mismatch", "member_leave must have actor_device_id == subject_device_id")
+	}
+
+	vaultID := event.VaultID.Bytes()
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault == nil {
+		return nil, apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
+	member, err := v.vaults.GetMember(ctx, vaultID, string(event.ActorDeviceID))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	// A frozen device may still leave; that is the one change it can make
+	// to the vault, and it ends the freeze along with the membership.
+	if member == nil || !member.IsMember {
+		return nil, apierror.MembershipRequired()
+	}
+	if member.Role == models.RoleOwner {
+		return nil, apierror.OwnerCannotLeave()
+	}
+
+	head, err := v.vaults.GetMembershipHead(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if head == nil {
+		return nil, apierror.BadRequest("missing_membership_head", "vault membership head is missing")
+	}
+
+	memberSeq := uint64(event.MemberSeq)
+	if memberSeq <= head.MemberSeq {
+		return nil, v.checkEquivocation(ctx, event, head)
+	}
+	if memberSeq != head.MemberSeq+1 {
+		return nil, MembershipChainBrokenAt(head)
+	}
+	if !bytes.Equal(event.PrevHash, head.MemberHeadHash) {
+		return nil, MembershipChainBrokenAt(head)
+	}
+
+	signBytes, err := memberEventSignBytes(event)
+	if err != nil {
+		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+
+	if err := crypto.VerifySignature(member.DevicePubkeySign, signBytes, event.Signature); err != nil {
+		return nil, apierror.InvalidSignature()
+	}
 
 	return &storage.MemberEventRow{
 		MemberEventID:   event.MemberEventID.Bytes(),
@@ -333,9 +563,426 @@ func (v *MembershipValidator) ValidateMemberRemove(ctx context.Context, event *m
 		PrevHash:        event.PrevHash,
 		ActorDeviceID:   string(event.ActorDeviceID),
 		SubjectDeviceID: string(event.SubjectDeviceID),
-		MsgType:         "member_remove",
+		MsgType:         "member_leave",
 		Signature:       event.Signature,
-		MemberHash:      memberHash,
+		MemberHash:      crypto.SHA256Hash(signBytes),
 		CreatedAt:       event.CreatedAt,
 	}, nil
 }
+
+// ValidateVaultPolicy checks a vault_policy event, which only the owner may
+// sign. Its subject is the owner itself, and the quorum it sets must be
+// reachable by the current owner and admins.
+func (v *MembershipValidator) ValidateVaultPolicy(ctx context.Context, event *models.MemberEvent) (*storage.MemberEventRow, *apierror.APIError) {
+	if event.MsgType != "vault_policy" {
+		return nil, apierror.BadRequest("invalid_msg_type", "expected vault_policy")
+	}
+
+	if len(event.PrevHash) != models.HashLength {
+		return nil, apierror.InvalidHash()
+	}
+	if len(event.Signature) != models.SignatureLength {
+		return nil, apierror.InvalidSignature()
+	}
+
+	if err := event.ActorDeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+	if event.SubjectDeviceID != event.ActorDeviceID {
+		return nil, apierror.BadRequest("policy_subject_mismatch", "vault_policy must have actor_device_id == subject_device_id")
+	}
+
+	vaultID := event.VaultID.Bytes()
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault == nil {
+		return nil, apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
+	actor, err := v.vaults.GetMember(ctx, vaultID, string(event.ActorDeviceID))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if actor == nil || !actor.IsMember {
+		return nil, apierror.MembershipRequired()
+	}
+	if actor.Frozen {
+		return nil, apierror.DeviceFrozen()
+	}
+	if actor.Role != models.RoleOwner {
+		return nil, apierror.OwnerRequired()
+	}
+
+	admins, err := v.vaults.CountAdmins(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	quorum := uint64(event.Quorum)
+	if quorum < 1 || quorum > admins {
+		return nil, apierror.BadRequest("invalid_quorum", fmt.Sprintf("quorum must be between 1 and the number of admins (%d)", admins))
+	}
+
+	head, err := v.vaults.GetMembershipHead(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if head == nil {
+		return nil, apierror.BadRequest("missing_membership_head", "vault membership head is missing")
+	}
+
+	memberSeq := uint64(event.MemberSeq)
+	if memberSeq <= head.MemberSeq {
+		return nil, v.checkEquivocation(ctx, event, head)
+	}
+	if memberSeq != head.MemberSeq+1 {
+		return nil, MembershipChainBrokenAt(head)
+	}
+	if !bytes.Equal(event.PrevHash, head.MemberHeadHash) {
+		return nil, MembershipChainBrokenAt(head)
+	}
+
+	signBytes, err := memberEventSignBytes(event)
+	if err != nil {
+		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+
+	if err := crypto.VerifySignature(actor.DevicePubkeySign, signBytes, event.Signature); err != nil {
+		return nil, apierror.InvalidSignature()
+	}
+
+	return &storage.MemberEventRow{
+		MemberEventID:   event.MemberEventID.Bytes(),
+		VaultID:         vaultID,
+		MemberSeq:       memberSeq,
+		PrevHash:        event.PrevHash,
+		ActorDeviceID:   string(event.ActorDeviceID),
+		SubjectDeviceID: string(event.SubjectDeviceID),
+		MsgType:         "vault_policy",
+		Quorum:          quorum,
+		Signature:       event.Signature,
+		MemberHash:      crypto.SHA256Hash(signBytes),
+		CreatedAt:       event.CreatedAt,
+	}, nil
+}
+
+// ValidateVaultDelete checks the owner's signed request to delete the vault.
+// It must name the current membership head, so a delete signed before a later
+// membership change cannot be replayed.
+func (v *MembershipValidator) ValidateVaultDelete(ctx context.Context, del *models.VaultDelete) *apierror.APIError {
+	if del.MsgType != "vault_delete" {
+		return apierror.BadRequest("invalid_msg_type", "expected 'vault_delete'")
+	}
+
+	if len(del.Signature) != models.SignatureLength {
+		return apierror.InvalidSignature()
+	}
+	if len(del.MemberHeadHash) != models.HashLength {
+		return apierror.InvalidHash()
+	}
+
+	if err := del.DeviceID.Validate(); err != nil {
+		return apierror.InvalidDeviceID()
+	}
+
+	vaultID := del.VaultID.Bytes()
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return apierror.InternalError()
+	}
+	if vault == nil {
+		return apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return apierror.VaultDeleted()
+	}
+
+	owner, err := v.vaults.GetMember(ctx, vaultID, string(del.DeviceID))
+	if err != nil {
+		return apierror.InternalError()
+	}
+	if owner == nil || !owner.IsMember {
+		return apierror.MembershipRequired()
+	}
+	if owner.Frozen {
+		return apierror.DeviceFrozen()
+	}
+	if owner.Role != models.RoleOwner {
+		return apierror.OwnerRequired()
+	}
+
+	head, err := v.vaults.GetMembershipHead(ctx, vaultID)
+	if err != nil {
+		return apierror.InternalError()
+	}
+	if head == nil {
+		return apierror.BadRequest("missing_membership_head", "vault membership head is missing")
+	}
+	if uint64(del.MemberSeq) != head.MemberSeq || !bytes.Equal(del.MemberHeadHash, head.MemberHeadHash) {
+		return MembershipChainBrokenAt(head)
+	}
+
+	deviceIDBytes, err := crypto.DeviceIDToBytes(string(del.DeviceID))
+	if err != nil {
+		return apierror.InvalidDeviceID()
+	}
+
+	signBytes, err := cbe.SignBytesVaultDelete(vaultID, deviceIDBytes, uint64(del.MemberSeq), del.MemberHeadHash)
+	if err != nil {
+		return apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+
+	if err := crypto.VerifySignature(owner.DevicePubkeySign, signBytes, del.Signature); err != nil {
+		return apierror.InvalidSignature()
+	}
+	return nil
+}
+
+// ValidateRoleChange checks a member_promote or member_demote. Admins may
+// change the roles of ordinary members; granting or revoking admin takes the
+// owner, and the owner's own role only changes through owner_transfer.
+func (v *MembershipValidator) ValidateRoleChange(ctx context.Context, event *models.MemberEvent) (*storage.MemberEventRow, *apierror.APIError) {
+	if event.MsgType != "member_promote" && event.MsgType != "member_demote" {
+		return nil, apierror.BadRequest("invalid_msg_type", "expected member_promote or member_demote")
+	}
+
+	if len(event.PrevHash) != models.HashLength {
+		return nil, apierror.InvalidHash()
+	}
+	if len(event.Signature) != models.SignatureLength {
+		return nil, apierror.InvalidSignature()
+	}
+	if event.Role == models.RoleOwner || roleRank(event.Role) == 0 {
+		return nil, apierror.BadRequest("invalid_role", "role must be 'admin', 'member' or 'reader'")
+	}
+
+	if err := event.ActorDeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+	if err := event.SubjectDeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	vaultID := event.VaultID.Bytes()
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if vault == nil {
+		return nil, apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
+
+	actor, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(event.ActorDeviceID))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	head, err := v.vaults.GetMembershipHead(ctx, vaultID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if head == nil {
+		return nil, apierror.BadRequest("missing_membership_head", "vault membership head is missing")
+	}
+
+	memberSeq := uint64(event.MemberSeq)
+	if memberSeq <= head.MemberSeq {
+		return nil, v.checkEquivocation(ctx, event, head)
+	}
+	if memberSeq != head.MemberSeq+1 {
+		return nil, MembershipChainBrokenAt(head)
+	}
+	if !bytes.Equal(event.PrevHash, head.MemberHeadHash) {
+		return nil, MembershipChainBrokenAt(head)
+	}
+
+	subject, err := v.vaults.GetMember(ctx, vaultID, string(event.SubjectDeviceID))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if subject == nil || !subject.IsMember {
+		return nil, apierror.BadRequest("subject_not_member", "subject_device_id is not a current member")
+	}
+	if subject.Role == models.RoleOwner {
+		return nil, apierror.BadRequest("invalid_subject", "the owner's role changes only through owner_transfer")
+	}
+
+	current, target := roleRank(subject.Role), roleRank(event.Role)
+	if event.MsgType == "member_promote" && target <= current {
+		return nil, apierror.BadRequest("invalid_role", "member_promote must raise the subject's role")
+	}
+	if event.MsgType == "member_demote" && target >= current {
+		return nil, apierror.BadRequest("invalid_role", "member_demote must lower the subject's role")
+	}
+	if max(current, target) >= roleRank(models.RoleAdmin) && actor.Role != models.RoleOwner {
+		return nil, apierror.OwnerRequired()
+	}
+
+	signBytes, err := memberEventSignBytes(event)
+	if err != nil {
+		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+
+	if err := crypto.VerifySignature(actor.DevicePubkeySign, signBytes, event.Signature); err != nil {
+		return nil, apierror.InvalidSignature()
+	}
+
+	return &storage.MemberEventRow{
+		MemberEventID:   event.MemberEventID.Bytes(),
+		VaultID:         vaultID,
+		MemberSeq:       memberSeq,
+		PrevHash:        event.PrevHash,
+		ActorDeviceID:   string(event.ActorDeviceID),
+		SubjectDeviceID: string(event.SubjectDeviceID),
+		MsgType:         event.MsgType,
+		Role:            event.Role,
+		Signature:       event.Signature,
+		MemberHash:      crypto.SHA256Hash(signBytes),
+		CreatedAt:       event.CreatedAt,
+	}, nil
+}
+
+// checkEquivocation handles a member event at a member_seq the log has
+// already passed. If the stored event there has the same actor and this one
+// is a different message validly signed by that actor, the actor has
+// equivocated; anything else is an ordinary stale write.
+func (v *MembershipValidator) checkEquivocation(ctx context.Context, event *models.MemberEvent, head *storage.VaultMembershipHead) *apierror.APIError {
+	vaultID := event.VaultID.Bytes()
+
+	stored, err := v.memberEvents.GetBySeq(ctx, vaultID, uint64(event.MemberSeq))
+	if err != nil {
+		return apierror.InternalError()
+	}
+	if stored == nil || stored.ActorDeviceID != string(event.ActorDeviceID) || bytes.Equal(stored.Signature, event.Signature) {
+		return MembershipChainBrokenAt(head)
+	}
+
+	// The actor's row outlives its membership, so a removed actor's key is
+	// still available here.
+	actor, err := v.vaults.GetMember(ctx, vaultID, string(event.ActorDeviceID))
+	if err != nil {
+		return apierror.InternalError()
+	}
+	if actor == nil {
+		return MembershipChainBrokenAt(head)
+	}
+
+	signBytes, err := memberEventSignBytes(event)
+	if err != nil {
+		return apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+	if err := crypto.VerifySignature(actor.DevicePubkeySign, signBytes, event.Signature); err != nil {
+		return MembershipChainBrokenAt(head)
+	}
+
+	return apierror.Equivocation().WithDetails(models.MemberEventRef{
+		MemberEventID: models.UUID(stored.MemberEventID),
+		ActorDeviceID: models.DeviceID(stored.ActorDeviceID),
+		MemberSeq:     models.Uint64String(stored.MemberSeq),
+		MemberHash:    stored.MemberHash,
+	})
+}
+
+// memberEventSignBytes rebuilds the CBE bytes the actor signed for a
+// non-genesis member event.
+func memberEventSignBytes(event *models.MemberEvent) ([]byte, error) {
+	actorDeviceIDBytes, err := crypto.DeviceIDToBytes(string(event.ActorDeviceID))
+	if err != nil {
+		return nil, err
+	}
+	subjectDeviceIDBytes, err := crypto.DeviceIDToBytes(string(event.SubjectDeviceID))
+	if err != nil {
+		return nil, err
+	}
+
+	switch event.MsgType {
+	case "member_add":
+		return cbe.SignBytesMemberAdd(
+			event.MemberEventID.Bytes(),
+			event.VaultID.Bytes(),
+			uint64(event.MemberSeq),
+			event.PrevHash,
+			actorDeviceIDBytes,
+			subjectDeviceIDBytes,
+			event.InviteID.Bytes(),
+			event.ClaimSig,
+			event.SubjectBundleSig,
+			event.SubjectPubkeySign,
+			event.SubjectPubkeyBox,
+		)
+	case "member_remove":
+		return cbe.SignBytesMemberRemove(
+			event.MemberEventID.Bytes(),
+			event.VaultID.Bytes(),
+			uint64(event.MemberSeq),
+			event.PrevHash,
+			actorDeviceIDBytes,
+			subjectDeviceIDBytes,
+		)
+	case "owner_transfer":
+		return cbe.SignBytesOwnerTransfer(
+			event.MemberEventID.Bytes(),
+			event.VaultID.Bytes(),
+			uint64(event.MemberSeq),
+			event.PrevHash,
+			actorDeviceIDBytes,
+			subjectDeviceIDBytes,
+		)
+	case "member_leave":
+		return cbe.SignBytesMemberLeave(
+			event.MemberEventID.Bytes(),
+			event.VaultID.Bytes(),
+			uint64(event.MemberSeq),
+			event.PrevHash,
+			actorDeviceIDBytes,
+		)
+	case "vault_policy":
+		return cbe.SignBytesVaultPolicy(
+			event.MemberEventID.Bytes(),
+			event.VaultID.Bytes(),
+			uint64(event.MemberSeq),
+			event.PrevHash,
+			actorDeviceIDBytes,
+			uint64(event.Quorum),
+		)
+	case "member_promote":
+		return cbe.SignBytesMemberPromote(
+			event.MemberEventID.Bytes(),
+			event.VaultID.Bytes(),
+			uint64(event.MemberSeq),
+			event.PrevHash,
+			actorDeviceIDBytes,
+			subjectDeviceIDBytes,
+			event.Role,
+		)
+	case "member_demote":
+		return cbe.SignBytesMemberDemote(
+			event.MemberEventID.Bytes(),
+			event.VaultID.Bytes(),
+			uint64(event.MemberSeq),
+			event.PrevHash,
+			actorDeviceIDBytes,
+			subjectDeviceIDBytes,
+			event.Role,
+		)
+	}
+	return nil, fmt.Errorf("unsupported msg_type %q", event.MsgType)
+}
+
+// MembershipChainBrokenAt reports a membership chain mismatch together with
+// the vault's current membership head.
+func MembershipChainBrokenAt(head *storage.VaultMembershipHead) *apierror.APIError {
+	current := models.MembershipHead{HeadHash: models.Zero32}
+	if head != nil {
+		current.MemberSeq = models.Uint64String(head.MemberSeq)
+		current.HeadHash = head.MemberHeadHash
+	}
+	return apierror.MembershipChainBroken().WithDetails(current)
+}
@@ -0,0 +1,59 @@
+package validation
+
+import (
+	"context"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+// ValidatePairingSlot checks a new device's request to be found by a short
+// code. The device must already be registered, since its bundle is what the
+// code resolves to. The caller fills in the hash of the code it generates.
+func (v *InvitesValidator) ValidatePairingSlot(ctx context.Context, slot *models.PairingSlot) (*storage.PairingSlotRow, *apierror.APIError) {
+	if err := slot.DeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	device, err := v.devices.Get(ctx, string(slot.DeviceID))
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if device == nil {
+		return nil, apierror.NotFound("device")
+	}
+
+	return &storage.PairingSlotRow{
+		DeviceID: string(slot.DeviceID),
+	}, nil
+}
+
+// ValidatePairingLookup checks that the device resolving a pairing code may
+// invite into the vault it names.
+func (v *InvitesValidator) ValidatePairingLookup(ctx context.Context, lookup *models.PairingLookup) *apierror.APIError {
+	if len(lookup.CodeHash) != models.HashLength {
+		return apierror.InvalidHash()
+	}
+	if err := lookup.DeviceID.Validate(); err != nil {
+		return apierror.InvalidDeviceID()
+	}
+
+	vaultID := lookup.VaultID.Bytes()
+
+	vault, err := v.vaults.Get(ctx, vaultID)
+	if err != nil {
+		return apierror.InternalError()
+	}
+	if vault == nil {
+		return apierror.NotFound("vault")
+	}
+	if vault.DeletedAt != "" {
+		return apierror.VaultDeleted()
+	}
+
+	if _, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(lookup.DeviceID)); apiErr != nil {
+		return apiErr
+	}
+	return nil
+}
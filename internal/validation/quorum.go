@@ -0,0 +1,58 @@
+package validation
+
+import (
+	"context"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/crypto"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+// ValidateProposalSignature checks an admin's co-signature on a proposed
+// member event. The signer must currently be the owner or an admin and must
+// have signed the same bytes as the proposer.
+func (v *MembershipValidator) ValidateProposalSignature(ctx context.Context, event *models.MemberEvent, sig *models.MemberProposalSignature) (*storage.MemberProposalSignatureRow, *apierror.APIError) {
+	if len(sig.Signature) != models.SignatureLength {
+		return nil, apierror.InvalidSignature()
+	}
+	if err := sig.DeviceID.Validate(); err != nil {
+		return nil, apierror.InvalidDeviceID()
+	}
+
+	signer, apiErr := requireAdmin(ctx, v.vaults, event.VaultID.Bytes(), string(sig.DeviceID))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	signBytes, err := memberEventSignBytes(event)
+	if err != nil {
+		return nil, apierror.BadRequest("sign_bytes_error", err.Error())
+	}
+	if err := crypto.VerifySignature(signer.DevicePubkeySign, signBytes, sig.Signature); err != nil {
+		return nil, apierror.InvalidSignature()
+	}
+
+	return &storage.MemberProposalSignatureRow{
+		MemberEventID: event.MemberEventID.Bytes(),
+		DeviceID:      string(sig.DeviceID),
+		Signature:     sig.Signature,
+	}, nil
+}
+
+// CountQuorum counts the signatures whose signers are still the owner or an
+// admin; a signature stops counting once its signer is demoted, removed or
+// frozen.
+func (v *MembershipValidator) CountQuorum(ctx context.Context, vaultID []byte, sigs []*storage.MemberProposalSignatureRow) (uint64, *apierror.APIError) {
+	var count uint64
+	for _, sig := range sigs {
+		member, err := v.vaults.GetMember(ctx, vaultID, sig.DeviceID)
+		if err != nil {
+			return 0, apierror.InternalError()
+		}
+		if member != nil && member.IsMember && !member.Frozen && roleRank(member.Role) >= roleRank(models.RoleAdmin) {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,44 @@
+package validation
+
+import (
+	"context"
+
+	"forgor-server/internal/apierror"
+	"forgor-server/internal/models"
+	"forgor-server/internal/storage"
+)
+
+// roleRank orders roles by privilege; unknown roles rank below every valid
+// one.
+func roleRank(role string) int {
+	switch role {
+	case models.RoleOwner:
+		return 4
+	case models.RoleAdmin:
+		return 3
+	case models.RoleMember:
+		return 2
+	case models.RoleReader:
+		return 1
+	}
+	return 0
+}
+
+// requireAdmin loads deviceID's membership and checks that it may manage the
+// vault, i.e. that it is the owner or an admin whose chain is not frozen.
+func requireAdmin(ctx context.Context, vaults *storage.VaultsRepository, vaultID []byte, deviceID string) (*storage.VaultMemberRow, *apierror.APIError) {
+	member, err := vaults.GetMember(ctx, vaultID, deviceID)
+	if err != nil {
+		return nil, apierror.InternalError()
+	}
+	if member == nil || !member.IsMember {
+		return nil, apierror.MembershipRequired()
+	}
+	if member.Frozen {
+		return nil, apierror.DeviceFrozen()
+	}
+	if roleRank(member.Role) < roleRank(models.RoleAdmin) {
+		return nil, apierror.AdminRequired()
+	}
+	return member, nil
+}
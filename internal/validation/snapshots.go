@@ -3,6 +3,7 @@ package validation
 import (
 	"bytes"
 	"context"
+	"database/sql"
 
 	"forgor-server/internal/apierror"
 	"forgor-server/internal/cbe"
@@ -12,20 +13,32 @@ import (
 )
 
 type SnapshotsValidator struct {
-	vaults    *storage.VaultsRepository
-	snapshots *storage.SnapshotsRepository
-	invites   *storage.InvitesRepository
+	vaults     *storage.VaultsRepository
+	snapshots  *storage.SnapshotsRepository
+	invites    *storage.InvitesRepository
+	keyUpdates *storage.KeyUpdatesRepository
 }
 
 func NewSnapshotsValidator(
 	vaults *storage.VaultsRepository,
 	snapshots *storage.SnapshotsRepository,
 	invites *storage.InvitesRepository,
+	keyUpdates *storage.KeyUpdatesRepository,
 ) *SnapshotsValidator {
 	return &SnapshotsValidator{
-		vaults:    vaults,
-		snapshots: snapshots,
-		invites:   invites,
+		vaults:     vaults,
+		snapshots:  snapshots,
+		invites:    invites,
+		keyUpdates: keyUpdates,
+	}
+}
+
+func (v *SnapshotsValidator) WithTx(tx *sql.Tx) *SnapshotsValidator {
+	return &SnapshotsValidator{
+		vaults:     v.vaults.WithTx(tx),
+		snapshots:  v.snapshots.WithTx(tx),
+		invites:    v.invites.WithTx(tx),
+		keyUpdates: v.keyUpdates.WithTx(tx),
 	}
 }
 
@@ -66,17 +79,21 @@ func (v *SnapshotsValidator) ValidateSnapshot(ctx context.Context, s *models.Sna
 	if vault == nil {
 		return nil, apierror.NotFound("vault")
 	}
+	if vault.DeletedAt != "" {
+		return nil, apierror.VaultDeleted()
+	}
 
-	if string(s.CreatedByDeviceID) != vault.OwnerDeviceID {
-		return nil, apierror.OwnerRequired()
+	creator, apiErr := requireAdmin(ctx, v.vaults, vaultID, string(s.CreatedByDeviceID))
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
-	creator, err := v.vaults.GetMember(ctx, vaultID, string(s.CreatedByDeviceID))
+	blocked, err := rotationBlocks(ctx, v.keyUpdates, vault, uint64(s.KeyEpoch))
 	if err != nil {
 		return nil, apierror.InternalError()
 	}
-	if creator == nil || !creator.IsMember {
-		return nil, apierror.MembershipRequired()
+	if blocked {
+		return nil, apierror.RotationPending()
 	}
 
 	head, err := v.vaults.GetMembershipHead(ctx, vaultID)